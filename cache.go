@@ -0,0 +1,24 @@
+package be2fn
+
+import "github.com/wqvoon/be2fn/internal"
+
+// 以表达式为 key 缓存 Compile 的结果，见 NewCache
+type Cache = internal.UnitCache
+
+// Cache 的命中次数、未命中次数、淘汰次数等统计信息
+type CacheStats = internal.CacheStats
+
+// Cache 的设置
+type CacheConfig struct {
+	Size           int  // 最多缓存的表达式个数，超过时淘汰最久没有使用的表达式，<= 0 时为 1024
+	NormalizeSpace bool // 只是空白不同的表达式（如 `a>1` 和 `a > 1`）是否共用一个编译结果
+}
+
+// 创建一个可以在多个 goroutine 中使用的编译缓存，cache.Compile(expr) 命中时直接返回之前编译好的函数，
+// 否则使用 opts 调用 Compile 并加入缓存；编译失败的表达式不会被缓存，
+// 调用 RegisterOperator 或 RegisterInfixOperator 之后，之前缓存的结果会在下一次获取时使用新的实现重新编译
+func NewCache(cfg CacheConfig, opts ...Option) *Cache {
+	return internal.NewUnitCache(cfg.Size, cfg.NormalizeSpace, func(expr string) (Unit, error) {
+		return Compile(expr, opts...)
+	})
+}
@@ -0,0 +1,37 @@
+package be2fn
+
+import "testing"
+
+func TestNewCache(t *testing.T) {
+	cache := NewCache(CacheConfig{Size: 2, NormalizeSpace: true}, WithOperators(foldOperators))
+	for _, expr := range []string{`a == "x"`, `a=="x"`, " a ==\n\t\"x\" "} {
+		fn, err := cache.Compile(expr)
+		if err != nil {
+			t.Fatal("failed to call Compile, err:", err)
+		}
+		if ret, err := fn.Eval(Kv{"a": "X"}); !ret || err != nil { // opts 对缓存中的表达式生效
+			t.Fatalf("%q should be true, got %v, %v", expr, ret, err)
+		}
+	}
+	if stats := cache.Stats(); stats != (CacheStats{Hits: 2, Misses: 1, Len: 1, Size: 2}) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// 编译失败的表达式不会被缓存，自动插入分号的换行不会被当作空白
+	for _, expr := range []string{"a ==", "a == \"x\"\n&& b == 1", "a == \"x\"\n&& b == 1"} {
+		if _, err := cache.Compile(expr); err == nil {
+			t.Fatalf("%q should return error", expr)
+		}
+	}
+	if stats := cache.Stats(); stats.Misses != 4 || stats.Len != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// 不做规范化时只是空白不同的表达式分别缓存
+	cache = NewCache(CacheConfig{})
+	cache.Compile("a > 1")
+	cache.Compile("a>1")
+	if stats := cache.Stats(); stats.Misses != 2 || stats.Size != 1024 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,31 @@
+package be2fn
+
+import "github.com/wqvoon/be2fn/internal"
+
+// Check 检查出的问题，即永远不会成立或永远成立的子表达式
+type Issue = internal.Issue
+
+// 检查 expr 中永远不会成立（如 `a > 10 && a < 5`）或永远成立（如 `a > 0 || a <= 0`）的子表达式，
+// 推导基于同一个变量上的区间和等值关系，返回的问题为空时不代表表达式一定没有问题；
+// 推导基于内置运算符的语义，用到了 WithOperators 或 RegisterOperator 中的实现以及具名中缀运算符时不做检查，总是返回空；
+// expr 不合法时返回错误，opts 中只有 WithLimits 和 WithOperators 会生效
+func Check(expr string, opts ...Option) ([]Issue, error) {
+	o := newOptions(opts)
+	lexer, err := parse(expr, o)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := o.operators.tokens()
+	if err != nil {
+		return nil, err
+	}
+	if !internal.BuiltinOperators(lexer.Params, ops) {
+		return nil, nil
+	}
+
+	tree, err := internal.BuildTree(lexer.Params)
+	if err != nil {
+		return nil, err
+	}
+	return internal.Lint(tree), nil
+}
@@ -0,0 +1,24 @@
+package be2fn
+
+import "testing"
+
+func TestCheckOperators(t *testing.T) {
+	cases := []struct {
+		Expr   string
+		Opts   []Option
+		Issues int
+	}{
+		{`a == "x" && a == "X"`, nil, 1},
+		{`a == "x" && a == "X"`, []Option{WithOperators(foldOperators)}, 0}, // 不区分大小写时可以同时成立
+		{`a > 10 && a < 5`, []Option{WithOperators(foldOperators)}, 1},      // 没有用到替换的运算符
+	}
+	for _, c := range cases {
+		issues, err := Check(c.Expr, c.Opts...)
+		if err != nil {
+			t.Fatalf("failed to check %q, err: %v", c.Expr, err)
+		}
+		if len(issues) != c.Issues {
+			t.Fatalf("%q should have %d issues, got %v", c.Expr, c.Issues, issues)
+		}
+	}
+}
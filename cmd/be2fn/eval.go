@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/wqvoon/be2fn"
+)
+
+// 退出码
+const (
+	exitTrue  = 0 // 表达式成立，或 -check 时表达式合法
+	exitFalse = 1 // 表达式不成立
+	exitError = 2 // 参数、表达式或变量有误，或者求值出错
+)
+
+// be2fn [flags] expr [vars]：编译表达式并用 JSON 格式的变量求值，输出结果，
+// 变量可以通过第二个参数、-f 指定的文件或标准输入传入
+func runEval(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("be2fn", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	file := fs.String("f", "", "read vars from `file`, - means stdin")
+	explain := fs.Bool("explain", false, "show the result of every subexpression")
+	check := fs.Bool("check", false, "only check whether expr is valid")
+	optimize := fs.Bool("optimize", false, "simplify expr before compiling")
+	vm := fs.Bool("vm", false, "run expr with the bytecode VM")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 || (fs.NArg() == 2 && *file != "") {
+		fs.Usage()
+		return exitError
+	}
+	expr := fs.Arg(0)
+
+	var opts []be2fn.Option
+	if *optimize {
+		opts = append(opts, be2fn.WithOptimize())
+	}
+	if *vm {
+		opts = append(opts, be2fn.WithVM())
+	}
+
+	fn, err := be2fn.Compile(expr, opts...)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	if *check {
+		issues, _ := be2fn.Check(expr)
+		for _, issue := range issues {
+			fmt.Fprintln(stderr, "warning:", issue)
+		}
+		fmt.Fprintln(stdout, "ok")
+		return exitTrue
+	}
+
+	vars, err := readVars(fs.Arg(1), *file, stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	if *explain {
+		tree, err := be2fn.Trace(expr, vars, opts...)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		fmt.Fprint(stdout, tree)
+	}
+
+	ret, err := fn.Eval(vars)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	fmt.Fprintln(stdout, ret)
+	if !ret {
+		return exitFalse
+	}
+	return exitTrue
+}
+
+// 读取变量，arg 不为空时直接解析，否则从 file 读取，file 为空或 `-` 时从标准输入读取
+func readVars(arg, file string, stdin io.Reader) (be2fn.Kv, error) {
+	if arg != "" {
+		return be2fn.KvFromJSON([]byte(arg))
+	}
+
+	var data []byte
+	var err error
+	if file == "" || file == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return be2fn.KvFromJSON(data)
+}
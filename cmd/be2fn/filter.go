@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/wqvoon/be2fn"
+)
+
+// be2fn filter [flags] expr：把表达式当作过滤条件，从标准输入逐行读取 JSON，输出表达式成立的行，
+// 和 grep 一样，有输出的行时退出码为 0，没有时为 1，出错时为 2；指定 -k 时跳过出错的行继续处理，但只要有行出错，退出码仍然为 2
+func runFilter(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("filter", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	count := fs.Bool("c", false, "only print the number of selected lines")
+	invert := fs.Bool("v", false, "select lines that do not match")
+	keepGoing := fs.Bool("k", false, "report errors of a line to stderr and skip it instead of aborting")
+	optimize := fs.Bool("optimize", false, "simplify expr before compiling")
+	vm := fs.Bool("vm", false, "run expr with the bytecode VM")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: be2fn filter [flags] expr < input.jsonl")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitError
+	}
+
+	var opts []be2fn.Option
+	if *optimize {
+		opts = append(opts, be2fn.WithOptimize())
+	}
+	if *vm {
+		opts = append(opts, be2fn.WithVM())
+	}
+	fn, err := be2fn.Compile(fs.Arg(0), opts...)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	in := bufio.NewReader(stdin)
+	out := bufio.NewWriter(stdout)
+	defer out.Flush()
+
+	selected, failed := 0, false
+	for lineNo := 1; ; lineNo++ {
+		line, readErr := in.ReadBytes('\n') // 不使用 bufio.Scanner，避免单行过长时失败
+		if readErr != nil && readErr != io.EOF {
+			fmt.Fprintln(stderr, readErr)
+			return exitError
+		}
+
+		if data := bytes.TrimSpace(line); len(data) > 0 {
+			ret, err := filterLine(fn, data)
+			switch {
+			case err != nil && *keepGoing:
+				failed = true
+				fmt.Fprintf(stderr, "line %d: %v\n", lineNo, err)
+			case err != nil:
+				out.Flush()
+				fmt.Fprintf(stderr, "line %d: %v\n", lineNo, err)
+				return exitError
+			case ret != *invert:
+				selected++
+				if !*count { // 原样输出这一行，包括首尾的空白
+					out.Write(line)
+					if !bytes.HasSuffix(line, []byte{'\n'}) {
+						out.WriteByte('\n')
+					}
+				}
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	if *count {
+		fmt.Fprintln(out, selected)
+	}
+	if failed {
+		return exitError
+	}
+	if selected == 0 {
+		return exitFalse
+	}
+	return exitTrue
+}
+
+// 解析一行 JSON 并求值
+func filterLine(fn be2fn.Unit, data []byte) (bool, error) {
+	vars, err := be2fn.KvFromJSON(data)
+	if err != nil {
+		return false, err
+	}
+	return fn.Eval(vars)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/wqvoon/be2fn"
+)
+
+// be2fn gen：把表达式转换成 Go 源代码，写入 -o 指定的文件或标准输出
+func runGen(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	out := fs.String("o", "", "output file, default is stdout")
+	pkg := fs.String("pkg", "rules", "package name")
+	fn := fs.String("func", "Match", "function name")
+	typ := fs.String("type", "Input", "input struct name")
+	schema := fs.String("schema", "", "variable types, e.g. `age=int,created=time.Time`")
+	optimize := fs.Bool("optimize", false, "simplify expr before generating")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: be2fn gen [flags] expr")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitError
+	}
+
+	cfg := be2fn.GenConfig{Package: *pkg, FuncName: *fn, TypeName: *typ, Schema: map[string]string{}}
+	for _, kv := range strings.Split(*schema, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			fmt.Fprintf(stderr, "invalid schema %q, should be name=type\n", kv)
+			return exitError
+		}
+		cfg.Schema[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+	}
+
+	var opts []be2fn.Option
+	if *optimize {
+		opts = append(opts, be2fn.WithOptimize())
+	}
+	src, err := be2fn.Generate(fs.Arg(0), cfg, opts...)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	if *out == "" {
+		stdout.Write(src)
+		return exitTrue
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	return exitTrue
+}
@@ -0,0 +1,41 @@
+// be2fn 命令行工具
+//
+//	be2fn [flags] expr [vars]  用 JSON 格式的变量对表达式求值，成立时退出码为 0，不成立时为 1，出错时为 2
+//	be2fn filter [flags] expr  从标准输入逐行读取 JSON，输出表达式成立的行
+//	be2fn gen [flags] expr     把表达式转换成 Go 源代码
+//	be2fn repl                 交互式地设置变量、编写表达式
+package main
+
+import (
+	"io"
+	"os"
+)
+
+const usage = `usage:
+  be2fn [flags] expr [vars]    evaluate expr with JSON vars from the argument, -f file or stdin,
+                               exit with 0 if true, 1 if false and 2 on error
+  be2fn filter [flags] expr    print lines of JSON from stdin which make expr true
+  be2fn gen [flags] expr       generate Go source code from expr
+  be2fn repl                   write expressions interactively
+
+flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// 执行命令，返回退出码
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "filter":
+			return runFilter(args[1:], stdin, stdout, stderr)
+		case "gen":
+			return runGen(args[1:], stdout, stderr)
+		case "repl":
+			return runREPL(args[1:], stdin, stdout, stderr)
+		}
+	}
+	return runEval(args, stdin, stdout, stderr)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const filterInput = `{"a": 2}
+{"a": 1}
+
+{"a": 3, "b": {"c": 1}}
+`
+
+func TestRun(t *testing.T) {
+	cases := []struct {
+		Args   []string
+		Stdin  string
+		Code   int
+		Stdout string
+	}{
+		{[]string{`a > 1 && user.name == "x"`, `{"a": 2, "user": {"name": "x"}}`}, "", exitTrue, "true\n"},
+		{[]string{`a > 1`}, `{"a": 1}`, exitFalse, "false\n"},
+		{[]string{`a > 1`, `{"b": 1}`}, "", exitError, ""},
+		{[]string{`a > 1`, `{`}, "", exitError, ""},
+		{[]string{"-check", `a > 1`}, "", exitTrue, "ok\n"},
+		{[]string{"-check", `a >`}, "", exitError, ""},
+		{[]string{"-explain", `a > 1 || !(a == 2)`, `{"a": 2}`}, "", exitTrue, "|| => true\n  a > 1 => true\n  ! => false\n    a == 2 => true\n"},
+		{[]string{"-vm", `a > 1`, `{"a": 2}`}, "", exitTrue, "true\n"},
+		{[]string{}, "", exitError, ""},
+		{[]string{"gen", "-func", "Adult", `age >= 18`}, "", exitTrue, "func Adult(in *Input) bool"},
+		{[]string{"gen", `age >=`}, "", exitError, ""},
+		{[]string{"gen", "-o", "/nonexistent/rules.go", `age >= 18`}, "", exitError, ""},
+		{[]string{"filter", `a > 1`}, filterInput, exitTrue, "{\"a\": 2}\n{\"a\": 3, \"b\": {\"c\": 1}}\n"},
+		{[]string{"filter", "-v", `a > 1`}, filterInput, exitTrue, "{\"a\": 1}\n"},
+		{[]string{"filter", "-c", `a > 1`}, filterInput + `{"b": 1}`, exitError, ""},
+		{[]string{"filter", "-c", "-k", `a > 1`}, filterInput + `{"b": 1}` + "\n{\n", exitError, "2\n"}, // 跳过出错的行，但退出码为 2
+		{[]string{"filter", "-k", `a > 1`}, "{\"a\": 2}\n{\"b\": 1}\n{\"a\": 3}", exitError, "{\"a\": 2}\n{\"a\": 3}\n"},
+		{[]string{"filter", `a > 1`}, "  {\"a\": 2}\t\r\n{\"a\": 3}", exitTrue, "  {\"a\": 2}\t\r\n{\"a\": 3}\n"}, // 原样输出
+		{[]string{"filter", "-c", `b.c == 1`}, `{"b": {"c": 1}}`, exitTrue, "1\n"},
+		{[]string{"filter", `a > 10`}, filterInput, exitFalse, ""},
+	}
+
+	for _, c := range cases {
+		var stdout, stderr bytes.Buffer
+		code := run(c.Args, strings.NewReader(c.Stdin), &stdout, &stderr)
+		if code != c.Code || !strings.Contains(stdout.String(), c.Stdout) {
+			t.Fatalf("%q: expect code %d and %q, got %d and %q, stderr: %s", c.Args, c.Code, c.Stdout, code, stdout.String(), stderr.String())
+		}
+	}
+}
+
+func TestREPL(t *testing.T) {
+	input := strings.Join([]string{
+		`user.age > 18 && region == "EU"`,
+		`:set user.age 20`,
+		`:set region EU`,
+		`:set user {"age": 10}`,
+		`:vars`,
+		`a >`,
+		`a == b && in(c, []int{1})`,
+		`b > 1 && in(c, []int{1, "x"})`,
+		`:history`,
+		`!2`,
+		`:unknown`,
+		`:quit`,
+		`a > 1`,
+	}, "\n")
+
+	var stdout, stderr bytes.Buffer
+	if code := runREPL(nil, strings.NewReader(input), &stdout, &stderr); code != exitTrue {
+		t.Fatalf("should exit with 0, got %d, stderr: %s", code, stderr.String())
+	}
+
+	out := stdout.String()
+	for _, expect := range []string{
+		`rpn: user age . 18 > region "EU" == &&`,
+		"error: failed to get int by key(user.age)",  // 还没有设置变量
+		"error: failed to get string by key(region)", // 只设置了 user.age 时 region 不存在
+		"=> true\n> rpn",     // 设置了 region 后重新求值
+		"=> false\n> region", // 修改 user.age 后重新求值
+		"user.age = 10\n",
+		"  a >\n     ^\nerror: 1:4: expected operand",
+		"  a == b && in(c, []int{1})\n    ^\nerror: both subExpr of `==` is Ident, err at 3", // 类型检查的错误
+		"  b > 1 && in(c, []int{1, \"x\"})\n                          ^\nerror: invalid array elem, want int, err at 25",
+		"   3  :set region EU\n",
+		":set user.age 20\nrpn:",
+		"error: unknown command :unknown",
+	} {
+		if !strings.Contains(out, expect) {
+			t.Fatalf("output should contain %q, got:\n%s", expect, out)
+		}
+	}
+	if strings.Contains(out, "a > 1") {
+		t.Fatalf("should not read input after :quit, got:\n%s", out)
+	}
+}
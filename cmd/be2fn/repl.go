@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wqvoon/be2fn"
+	"github.com/wqvoon/be2fn/internal"
+)
+
+const replHelp = `:set key value   set a variable, value is JSON or a plain string, e.g. :set user.age 20
+:unset key       remove a variable
+:load file       load variables from a JSON file
+:vars            show all variables
+:clear           remove all variables
+:history         show history, !n runs the n-th line again and !! runs the last one
+:help            show this help
+:quit            exit
+anything else is evaluated as an expression, it is evaluated again when variables change
+`
+
+// 交互式编写规则：设置变量、输入表达式，立即看到逆波兰表达式、结果和错误
+type repl struct {
+	vars    be2fn.Kv
+	history []string
+	last    string // 最近一次编译成功的表达式，变量变化时重新求值
+	out     io.Writer
+}
+
+// be2fn repl：从标准输入逐行读取命令或表达式
+func runREPL(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) != 0 {
+		fmt.Fprintln(stderr, "usage: be2fn repl")
+		return exitError
+	}
+
+	r := &repl{vars: be2fn.Kv{}, out: stdout}
+	fmt.Fprint(stdout, "be2fn repl, type :help for help\n> ")
+
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if !r.handle(strings.TrimSpace(scanner.Text())) {
+			return exitTrue
+		}
+		fmt.Fprint(stdout, "> ")
+	}
+	fmt.Fprintln(stdout)
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	return exitTrue
+}
+
+// 处理一行输入，返回 false 时退出
+func (r *repl) handle(line string) bool {
+	switch {
+	case line == "":
+		return true
+
+	case line == "!!" || strings.HasPrefix(line, "!") && isNumber(line[1:]):
+		idx := len(r.history)
+		if line != "!!" {
+			idx, _ = strconv.Atoi(line[1:])
+		}
+		if idx < 1 || idx > len(r.history) {
+			fmt.Fprintln(r.out, "error: no such history")
+			return true
+		}
+		line = r.history[idx-1]
+		fmt.Fprintln(r.out, line)
+	}
+
+	r.history = append(r.history, line)
+	if !strings.HasPrefix(line, ":") {
+		r.eval(line)
+		return true
+	}
+
+	cmd, arg := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	switch cmd {
+	case ":set":
+		r.set(arg)
+	case ":unset":
+		delete(r.vars, arg)
+		r.reeval()
+	case ":load":
+		r.load(arg)
+	case ":vars":
+		r.printVars()
+	case ":clear":
+		r.vars = be2fn.Kv{}
+		r.reeval()
+	case ":history":
+		for i, h := range r.history[:len(r.history)-1] {
+			fmt.Fprintf(r.out, "%4d  %s\n", i+1, h)
+		}
+	case ":help":
+		fmt.Fprint(r.out, replHelp)
+	case ":quit", ":q":
+		return false
+	default:
+		fmt.Fprintf(r.out, "error: unknown command %s, type :help for help\n", cmd)
+	}
+	return true
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// :set key value，value 不是合法的 JSON 时当作字符串
+func (r *repl) set(arg string) {
+	i := strings.IndexByte(arg, ' ')
+	if i < 0 {
+		fmt.Fprintln(r.out, "error: usage :set key value")
+		return
+	}
+	key, val := arg[:i], strings.TrimSpace(arg[i+1:])
+
+	name, _ := json.Marshal(key)
+	vars, err := be2fn.KvFromJSON([]byte(`{` + string(name) + `: ` + val + `}`))
+	if err != nil {
+		vars = be2fn.Kv{key: val}
+	}
+	for k, v := range vars {
+		r.vars[k] = v
+	}
+	r.reeval()
+}
+
+// :load file
+func (r *repl) load(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(r.out, "error:", err)
+		return
+	}
+	vars, err := be2fn.KvFromJSON(data)
+	if err != nil {
+		fmt.Fprintln(r.out, "error:", err)
+		return
+	}
+	for k, v := range vars {
+		r.vars[k] = v
+	}
+	fmt.Fprintf(r.out, "loaded %d variables\n", len(vars))
+	r.reeval()
+}
+
+func (r *repl) printVars() {
+	keys := make([]string, 0, len(r.vars))
+	for k := range r.vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(r.out, "%s = %#v\n", k, r.vars[k])
+	}
+}
+
+// 变量变化后重新对最近的表达式求值
+func (r *repl) reeval() {
+	if r.last != "" {
+		r.eval(r.last)
+	}
+}
+
+// 输出逆波兰表达式和求值结果，出错时在表达式下面标出位置
+func (r *repl) eval(expr string) {
+	lexer := internal.NewLexer(expr)
+	if err := lexer.Parse(); err != nil {
+		r.printErr(expr, err)
+		return
+	}
+
+	rpn := make([]string, 0, len(lexer.Params))
+	for _, p := range lexer.Params {
+		rpn = append(rpn, rpnSource(p))
+	}
+	fmt.Fprintln(r.out, "rpn:", strings.Join(rpn, " "))
+
+	fn, err := be2fn.Compile(expr)
+	if err != nil {
+		r.printErr(expr, err)
+		return
+	}
+	r.last = expr
+
+	ret, err := fn.Eval(r.vars)
+	if err != nil {
+		fmt.Fprintln(r.out, "error:", err)
+		return
+	}
+	fmt.Fprintln(r.out, "=>", ret)
+}
+
+func (r *repl) printErr(expr string, err error) {
+	var pe *internal.ParseError
+	if errors.As(err, &pe) && pe.Offset >= 0 && pe.Offset <= len(expr) {
+		fmt.Fprintf(r.out, "  %s\n  %s^\n", expr, strings.Repeat(" ", pe.Offset))
+	}
+	fmt.Fprintln(r.out, "error:", err)
+}
+
+// token 在逆波兰表达式中的写法
+func rpnSource(p *internal.Param) string {
+	switch p.Typ {
+	case internal.IDENT, internal.INT, internal.STRING, internal.BOOLEAN,
+		internal.INT_SLICE, internal.STR_SLICE, internal.TIME, internal.DURATION:
+		return internal.ParamSource(p)
+	case internal.FUNC:
+		return p.Val + "()"
+	default:
+		return p.Typ.String()
+	}
+}
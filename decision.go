@@ -0,0 +1,55 @@
+package be2fn
+
+import (
+	"fmt"
+
+	"github.com/wqvoon/be2fn/internal"
+)
+
+// 决策列表中的一条规则
+type Decision struct {
+	Expr    string      // 表达式
+	Payload interface{} // 表达式成立时返回的结果
+}
+
+// 决策列表，按顺序执行规则，返回第一条成立的规则对应的结果，都不成立时返回默认值
+type DecisionList = internal.DecisionList
+
+// 被前面更宽泛的规则遮蔽、永远不会被选中的规则
+type Shadow = internal.Shadow
+
+// 编译决策列表，每条规则都通过 Compile 编译，opts 对所有规则生效；
+// 某条规则编译失败时返回的错误中带有它的下标，可以通过 dl.Shadowed() 检查被遮蔽的规则
+func CompileDecisionList(rules []Decision, def interface{}, opts ...Option) (*DecisionList, error) {
+	o := newOptions(opts)
+
+	units := make([]Unit, 0, len(rules))
+	trees := make([]*internal.Node, 0, len(rules))
+	payloads := make([]interface{}, 0, len(rules))
+	for i, r := range rules {
+		u, err := Compile(r.Expr, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		units = append(units, u)
+		trees = append(trees, decisionTree(r.Expr, o))
+		payloads = append(payloads, r.Payload)
+	}
+	return internal.NewDecisionList(units, trees, payloads, def), nil
+}
+
+// 还原出用于检查遮蔽关系的表达式树，无法还原或用到了自定义实现的运算符时返回 nil
+func decisionTree(expr string, o *options) *internal.Node {
+	lexer, err := parse(expr, o)
+	if err != nil {
+		return nil
+	}
+	if ops, err := o.operators.tokens(); err != nil || !internal.BuiltinOperators(lexer.Params, ops) {
+		return nil
+	}
+	tree, err := internal.BuildTree(lexer.Params)
+	if err != nil {
+		return nil
+	}
+	return tree
+}
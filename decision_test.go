@@ -0,0 +1,54 @@
+package be2fn
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompileDecisionList(t *testing.T) {
+	rules := []Decision{
+		{Expr: `country == "US" && age >= 18`, Payload: "adult"},
+		{Expr: `age > 10`, Payload: 10},
+		{Expr: `age > 12 && country == "CN"`, Payload: 12}, // 被上一条遮蔽
+	}
+	cases := []struct {
+		Vars Kv
+		Ret  interface{}
+	}{
+		{Kv{"country": "US", "age": 20}, "adult"},
+		{Kv{"country": "CN", "age": 20}, 10},
+		{Kv{"country": "CN", "age": 1}, "none"},
+	}
+
+	for _, opts := range [][]Option{nil, {WithOptimize()}, {WithVM()}} {
+		dl, err := CompileDecisionList(rules, "none", opts...)
+		if err != nil {
+			t.Fatal("failed to call CompileDecisionList, err:", err)
+		}
+		for _, c := range cases {
+			if ret, err := dl.Eval(c.Vars); err != nil || ret != c.Ret {
+				t.Fatalf("%v should be %v, got %v, %v", c.Vars, c.Ret, ret, err)
+			}
+		}
+		want := []Shadow{{Index: 2, By: 1, Expr: rules[2].Expr, ByExpr: rules[1].Expr}}
+		if shadows := dl.Shadowed(); !reflect.DeepEqual(shadows, want) {
+			t.Fatalf("should be %v, got %v", want, shadows)
+		}
+	}
+
+	// 用到了自定义实现的规则不检查遮蔽关系
+	dl, err := CompileDecisionList(rules, "none", WithOperators(OperatorSet{">": {}}))
+	if err != nil {
+		t.Fatal("failed to call CompileDecisionList, err:", err)
+	}
+	if shadows := dl.Shadowed(); len(shadows) != 0 {
+		t.Fatalf("should not check custom operators, got %v", shadows)
+	}
+
+	// 编译失败时错误中带有规则的下标
+	_, err = CompileDecisionList(append(rules, Decision{Expr: "age >"}), nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "rule 3: ") {
+		t.Fatalf("should return error of rule 3, got %v", err)
+	}
+}
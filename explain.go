@@ -0,0 +1,60 @@
+package be2fn
+
+import "github.com/wqvoon/be2fn/internal"
+
+// 输出 expr 编译后的表达式树，每行一个节点，子节点按执行顺序缩进排列，并附带估算的代价和成立的概率；
+// opts 中的 WithOptimize、WithReorder 和 WithOperators 会生效，可以用来观察化简和调整顺序后的结果，
+// 没有指定 WithReorder 时按默认的代价估算
+func Explain(expr string, opts ...Option) (string, error) {
+	o := newOptions(opts)
+	lexer, err := parse(expr, o)
+	if err != nil {
+		return "", err
+	}
+
+	ops, err := o.operators.tokens()
+	if err != nil {
+		return "", err
+	}
+	builtin := internal.BuiltinOperators(lexer.Params, ops) // 和 Compile 一样，用到了自定义实现的表达式不化简、不调整顺序
+
+	tree, err := internal.BuildTree(lexer.Params)
+	if err != nil {
+		return "", err
+	}
+	if o.optimize && builtin {
+		tree = internal.Optimize(tree)
+	}
+
+	costs := o.costs
+	if costs == nil {
+		costs = &CostModel{}
+	} else if builtin {
+		tree = costs.Reorder(tree)
+	}
+	return costs.Explain(tree), nil
+}
+
+// 使用 vars 对 expr 中的每个子表达式分别求值，输出带有每个子表达式结果的表达式树，
+// 用于排查规则为什么成立或不成立；opts 中的 WithOptimize、WithClock 和 WithOperators 会生效
+func Trace(expr string, vars Kv, opts ...Option) (string, error) {
+	o := newOptions(opts)
+	lexer, err := parse(expr, o)
+	if err != nil {
+		return "", err
+	}
+
+	ops, err := o.operators.tokens()
+	if err != nil {
+		return "", err
+	}
+
+	tree, err := internal.BuildTree(lexer.Params)
+	if err != nil {
+		return "", err
+	}
+	if o.optimize && internal.BuiltinOperators(lexer.Params, ops) {
+		tree = internal.Optimize(tree)
+	}
+	return internal.Trace(tree, NewEnv(vars), o.clock, ops)
+}
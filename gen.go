@@ -0,0 +1,38 @@
+package be2fn
+
+import (
+	"errors"
+
+	"github.com/wqvoon/be2fn/internal"
+)
+
+// 生成 Go 代码时的配置，可以指定包名、函数名、输入的结构体名以及变量的类型
+type GenConfig = internal.GenConfig
+
+// 把 expr 转换成 Go 源代码，其中包含一个输入的结构体和 func(in *Input) bool 形式的函数，
+// 适合把足够稳定的规则直接编译进程序；变量的类型未在 cfg.Schema 中指定时根据比较的常量推导，
+// 生成的代码已经过 gofmt 格式化；opts 中只有 WithLimits、WithOptimize 和 WithOperators 会生效，
+// 生成的代码只能使用内置的运算符，用到了 WithOperators 或 RegisterOperator 中的实现以及具名中缀运算符时返回错误
+func Generate(expr string, cfg GenConfig, opts ...Option) ([]byte, error) {
+	o := newOptions(opts)
+	lexer, err := parse(expr, o)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := o.operators.tokens()
+	if err != nil {
+		return nil, err
+	}
+	if !internal.BuiltinOperators(lexer.Params, ops) {
+		return nil, errors.New("generated code only supports builtin operators")
+	}
+
+	tree, err := internal.BuildTree(lexer.Params)
+	if err != nil {
+		return nil, err
+	}
+	if o.optimize {
+		tree = internal.Optimize(tree)
+	}
+	return internal.Generate(expr, tree, cfg)
+}
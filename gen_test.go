@@ -0,0 +1,29 @@
+package be2fn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateOperators(t *testing.T) {
+	cases := []struct {
+		Expr string
+		Opts []Option
+		Ok   bool
+	}{
+		{`a == "x" && b > 1`, nil, true},
+		{`a == "x" && b > 1`, []Option{WithOptimize()}, true},
+		{`a == "x" && b > 1`, []Option{WithOperators(OperatorSet{"==": {}})}, false},
+		{`a == "x" && b > 1`, []Option{WithOperators(OperatorSet{"!=": {}})}, true}, // 没有用到的运算符不影响
+		{`a == "x"`, []Option{WithOperators(OperatorSet{"<>": {}})}, false},
+	}
+	for _, c := range cases {
+		src, err := Generate(c.Expr, GenConfig{}, c.Opts...)
+		if (err == nil) != c.Ok {
+			t.Fatalf("%q with %d options should succeed: %v, got err: %v", c.Expr, len(c.Opts), c.Ok, err)
+		}
+		if c.Ok && !strings.Contains(string(src), "func Match(in *Input) bool") {
+			t.Fatalf("unexpected source of %q:\n%s", c.Expr, src)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"container/list"
+	"go/scanner"
+	"go/token"
+	"strings"
+	"sync"
+)
+
+// UnitCache 的默认容量
+const defaultCacheSize = 1024
+
+// UnitCache 的统计信息
+type CacheStats struct {
+	Hits      uint64 // 命中的次数
+	Misses    uint64 // 未命中、需要编译的次数
+	Evictions uint64 // 因为超过容量被淘汰的表达式个数
+	Len       int    // 当前缓存的表达式个数
+	Size      int    // 容量
+}
+
+// 以表达式为 key 缓存编译结果，超过容量时淘汰最久没有使用的表达式，可以在多个 goroutine 中使用；
+// 编译失败的表达式不会被缓存，注册运算符（见 OperatorGeneration）之前编译的结果会被当作未命中，重新编译
+type UnitCache struct {
+	size      int
+	normalize bool
+	compile   func(expr string) (Unit, error)
+
+	mu    sync.Mutex
+	ll    *list.List               // 按最近使用的时间排列，最近使用的在前面
+	items map[string]*list.Element // key -> ll 中的元素
+	stats CacheStats
+}
+
+type cacheEntry struct {
+	key string
+	gen uint64 // 编译前的 OperatorGeneration
+	u   Unit
+}
+
+// 创建缓存，size <= 0 时使用默认容量 1024；normalize 为 true 时只是空白不同的表达式使用同一个 key
+func NewUnitCache(size int, normalize bool, compile func(expr string) (Unit, error)) *UnitCache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &UnitCache{
+		size:      size,
+		normalize: normalize,
+		compile:   compile,
+		ll:        list.New(),
+		items:     make(map[string]*list.Element),
+	}
+}
+
+// 获取 expr 的编译结果，没有缓存时编译并加入缓存
+func (c *UnitCache) Compile(expr string) (Unit, error) {
+	key := expr
+	if c.normalize {
+		key = NormalizeSpace(expr)
+	}
+
+	gen := OperatorGeneration()
+
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok && e.Value.(*cacheEntry).gen == gen {
+		c.ll.MoveToFront(e)
+		c.stats.Hits++
+		c.mu.Unlock()
+		return e.Value.(*cacheEntry).u, nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	// 编译时不持有锁，同一个表达式同时未命中时可能会编译多次，但只会缓存一份；
+	// 编译时注册了运算符的话，缓存的结果在下一次获取时会被重新编译
+	u, err := c.compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		if entry.gen == gen {
+			c.ll.MoveToFront(e)
+			return entry.u, nil
+		}
+		c.ll.Remove(e) // 过期的结果，替换成新编译的
+		delete(c.items, key)
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, gen: gen, u: u})
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+	return u, nil
+}
+
+// 统计信息
+func (c *UnitCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Len, stats.Size = c.ll.Len(), c.size
+	return stats
+}
+
+// 清空缓存，统计信息保持不变
+func (c *UnitCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+// 把表达式中 token 之间的空白统一成一个空格，字符串常量中的空白保持不变，
+// 所以 `a>1&&b==2` 和 ` a > 1 &&\n b == 2` 的结果相同；
+// 换行处自动插入了分号时保留换行，`a == 1\n&& b == 2` 无法解析，不能和 `a == 1 && b == 2` 共用结果；
+// 无法识别的表达式原样返回
+func NormalizeSpace(expr string) string {
+	var s scanner.Scanner
+	src := []byte(expr)
+	fset := token.NewFileSet()
+	ok := true
+	s.Init(fset.AddFile("", -1, len(src)), src, func(token.Position, string) { ok = false }, scanner.ScanComments)
+
+	var b strings.Builder
+	sep := byte(' ')
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.SEMICOLON && lit == "\n" { // 换行时自动插入的分号，在结尾时可以去掉
+			sep = '\n'
+			continue
+		}
+		if tok == token.ILLEGAL || tok == token.COMMENT {
+			return expr
+		}
+		if lit == "" {
+			lit = tok.String()
+		}
+		if b.Len() > 0 {
+			b.WriteByte(sep)
+		}
+		b.WriteString(lit)
+		sep = ' '
+	}
+	if !ok {
+		return expr
+	}
+	return b.String()
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNormalizeSpace(t *testing.T) {
+	cases := []struct {
+		Expr string
+		Ret  string
+	}{
+		{"a>1&&b==2", "a > 1 && b == 2"},
+		{" a > 1 &&\n\tb == 2 ", "a > 1 && b == 2"},
+		{`a == "x  y"`, `a == "x  y"`},
+		{"in(a,[]int{1,2})", "in ( a , [ ] int { 1 , 2 } )"},
+		{"a.b   >   -1", "a . b > - 1"},
+		{"a > 1 // c", "a > 1 // c"},                 // 带有注释时原样返回
+		{`a == "x`, `a == "x`},                       // 无法识别时原样返回
+		{"a == 1\n&& b == 2\n", "a == 1\n&& b == 2"}, // 自动插入了分号的换行需要保留
+		{"a == 1 &&\n\n b == 2", "a == 1 && b == 2"},
+	}
+	for _, c := range cases {
+		if ret := NormalizeSpace(c.Expr); ret != c.Ret {
+			t.Fatalf("%q should be %q, got %q", c.Expr, c.Ret, ret)
+		}
+	}
+}
+
+func TestUnitCache(t *testing.T) {
+	var compiles int32
+	compile := func(expr string) (Unit, error) {
+		atomic.AddInt32(&compiles, 1)
+		lex := NewLexer(expr)
+		if err := lex.Parse(); err != nil {
+			return nil, err
+		}
+		return NewCompiler(lex).Compile()
+	}
+
+	c := NewUnitCache(2, true, compile)
+	for _, expr := range []string{"a > 1", "a>1", "  a >\n1", "b == 2", "a > 1"} {
+		fn, err := c.Compile(expr)
+		if err != nil {
+			t.Fatal("failed to call Compile, err:", err)
+		}
+		if ret, err := fn.Eval(Kv{"a": 2, "b": 2}); !ret || err != nil {
+			t.Fatalf("%q should be true, got %v, %v", expr, ret, err)
+		}
+	}
+	if stats := c.Stats(); compiles != 2 || stats != (CacheStats{Hits: 3, Misses: 2, Len: 2, Size: 2}) {
+		t.Fatalf("unexpected stats: %+v, compiles %d", stats, compiles)
+	}
+
+	// 超过容量时淘汰最久没有使用的表达式，b == 2 最久没有使用
+	if _, err := c.Compile("c == 3"); err != nil {
+		t.Fatal("failed to call Compile, err:", err)
+	}
+	if _, err := c.Compile("a > 1"); err != nil || compiles != 3 {
+		t.Fatalf("a > 1 should be cached, got %v, compiles %d", err, compiles)
+	}
+	if _, err := c.Compile("b == 2"); err != nil || compiles != 4 {
+		t.Fatalf("b == 2 should be evicted, got %v, compiles %d", err, compiles)
+	}
+	if stats := c.Stats(); stats.Evictions != 2 || stats.Len != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// 编译失败的表达式不会被缓存
+	for i := 0; i < 2; i++ {
+		if _, err := c.Compile("a +"); err == nil {
+			t.Fatal("should return error")
+		}
+	}
+	if compiles != 6 {
+		t.Fatalf("errors should not be cached, compiles %d", compiles)
+	}
+
+	c.Purge()
+	if stats := c.Stats(); stats.Len != 0 || stats.Misses != 6 {
+		t.Fatalf("unexpected stats after purge: %+v", stats)
+	}
+
+	// 不做规范化时空白不同的表达式分别缓存
+	c = NewUnitCache(0, false, compile)
+	c.Compile("a > 1")
+	c.Compile("a>1")
+	if stats := c.Stats(); stats.Misses != 2 || stats.Size != defaultCacheSize {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestUnitCacheNewline(t *testing.T) {
+	const valid, invalid = "a == 1 && b == 2", "a == 1\n&& b == 2"
+	compile := func(expr string) (Unit, error) {
+		lex := NewLexer(expr)
+		if err := lex.Parse(); err != nil {
+			return nil, err
+		}
+		return NewCompiler(lex).Compile()
+	}
+
+	// 无论先编译哪一个，换行处插入了分号的表达式都应该报错
+	for _, order := range [][]string{{valid, invalid}, {invalid, valid}} {
+		c := NewUnitCache(0, true, compile)
+		for _, expr := range order {
+			_, err := c.Compile(expr)
+			if (err == nil) != (expr == valid) {
+				t.Fatalf("order %q: unexpected result of %q, err: %v", order, expr, err)
+			}
+		}
+	}
+}
+
+func TestUnitCacheOperatorGeneration(t *testing.T) {
+	tok := registerMatches(t)
+	origin, _ := LookupOperator(tok)
+	defer func() {
+		if _, err := RegisterInfixOperator("matches", origin); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	c := NewUnitCache(0, false, func(expr string) (Unit, error) {
+		lex := NewLexer(expr)
+		if err := lex.Parse(); err != nil {
+			return nil, err
+		}
+		return NewCompiler(lex).Compile()
+	})
+	eval := func() bool {
+		t.Helper()
+		fn, err := c.Compile(`a matches "X"`)
+		if err != nil {
+			t.Fatal("failed to call Compile, err:", err)
+		}
+		return fn.GetBool(Kv{"a": "x"})
+	}
+
+	if eval() || eval() {
+		t.Fatal("should be false with the original implementation")
+	}
+	// 重新注册后缓存的结果过期，使用新的实现重新编译
+	if _, err := RegisterInfixOperator("matches", OperatorFuncs{VarToStr: foldEqual}); err != nil {
+		t.Fatal("failed to call RegisterInfixOperator, err:", err)
+	}
+	if !eval() || !eval() {
+		t.Fatal("should use the new implementation")
+	}
+	if stats := c.Stats(); stats.Hits != 2 || stats.Misses != 2 || stats.Len != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestUnitCacheConcurrent(t *testing.T) {
+	compile := func(expr string) (Unit, error) {
+		lex := NewLexer(expr)
+		if err := lex.Parse(); err != nil {
+			return nil, err
+		}
+		return NewCompiler(lex).Compile()
+	}
+	c := NewUnitCache(8, true, compile)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				n := (g + i) % 16
+				fn, err := c.Compile(fmt.Sprintf("a > %d", n))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if ret, err := fn.Eval(Kv{"a": 8}); err != nil || ret != (8 > n) {
+					t.Errorf("a > %d should be %v, got %v, %v", n, 8 > n, ret, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if stats := c.Stats(); stats.Hits+stats.Misses != 1600 || stats.Len > 8 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// 表达式的类型，由类型检查推导得出
+type exprType int
+
+const (
+	typUnknown  exprType = iota // 不支持的表达式，只有出现在需要特定类型的位置时才会报错
+	typBool                     // 布尔表达式，即比较、函数调用以及它们的与或非组合
+	typIdent                    // 变量
+	typInt                      // 整数常量
+	typString                   // 字符串常量
+	typBoolLit                  // 布尔常量
+	typIntSlice                 // 整数切片常量
+	typStrSlice                 // 字符串切片常量
+	typTime                     // 时间常量
+	typDuration                 // 时长常量
+)
+
+var exprType2String = map[exprType]string{
+	typUnknown:  "unknown",
+	typBool:     "bool expr",
+	typIdent:    "ident",
+	typInt:      "int",
+	typString:   "string",
+	typBoolLit:  "boolean",
+	typIntSlice: "[]int",
+	typStrSlice: "[]string",
+	typTime:     "time",
+	typDuration: "duration",
+}
+
+func (t exprType) String() string {
+	return exprType2String[t]
+}
+
+// 是否是可以和变量比较的常量
+func (t exprType) isConst() bool {
+	switch t {
+	case typInt, typString, typBoolLit, typTime, typDuration:
+		return true
+	}
+	return false
+}
+
+// 对 AST 做类型检查，返回 expr 的类型，infix 为具名中缀运算符被替换成 `==` 的位置（见 translateInfix）；
+// 与或非的操作数只要求是布尔表达式，不再限制 AST 的形状，所以括号可以任意嵌套，非也可以任意叠加
+func typeCheck(expr ast.Expr, infix map[token.Pos]Token) (exprType, error) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.INT:
+			return typInt, nil
+		case token.STRING:
+			return typString, nil
+		default:
+			return typUnknown, invalidTokenError(e.Kind, e.Pos())
+		}
+
+	case *ast.Ident:
+		if isBoolIdent(e) {
+			return typBoolLit, nil
+		}
+		return typIdent, nil
+
+	case *ast.SelectorExpr:
+		if !isSelectorExpr(e.X) && !isIdent(e.X) {
+			return typUnknown, errorAt(e.Pos(), "SelectorExpr.X must be SelectorExpr or Ident")
+		}
+		if t, err := typeCheck(e.X, infix); err != nil {
+			return t, err
+		} else if t != typIdent {
+			return typUnknown, errorAt(e.Pos(), "SelectorExpr.X must be Ident, got %v", t)
+		}
+		return typIdent, nil
+
+	case *ast.ParenExpr:
+		return typeCheck(e.X, infix)
+
+	case *ast.UnaryExpr:
+		return checkUnaryExpr(e, infix)
+
+	case *ast.BinaryExpr:
+		return checkBinaryExpr(e, infix)
+
+	case *ast.CallExpr:
+		return checkCallExpr(e, infix)
+
+	case *ast.CompositeLit:
+		return checkCompositeLit(e)
+	}
+
+	return typUnknown, nil
+}
+
+// 检查一元表达式
+func checkUnaryExpr(ue *ast.UnaryExpr, infix map[token.Pos]Token) (exprType, error) {
+	switch ue.Op {
+	case token.NOT: // not 的操作数必须是布尔表达式
+		t, err := typeCheck(ue.X, infix)
+		if err != nil {
+			return t, err
+		}
+		if t != typBool {
+			return typUnknown, errorAt(ue.OpPos, "`!`'s subExpr must be bool expr, got %v", t)
+		}
+		return typBool, nil
+
+	case token.SUB: // 负号后面必须跟着一个数字常量
+		basicLit, ok := ue.X.(*ast.BasicLit)
+		if !ok || basicLit.Kind != token.INT {
+			return typUnknown, errorAt(ue.OpPos, "`-`'s subExpr must be number")
+		}
+		return typInt, nil
+
+	default:
+		return typUnknown, invalidTokenError(ue.Op, ue.OpPos)
+	}
+}
+
+// 检查二元表达式
+func checkBinaryExpr(be *ast.BinaryExpr, infix map[token.Pos]Token) (exprType, error) {
+	if be.Op != token.ADD && be.Op != token.SUB && golangToken2Token[be.Op] == INVALID {
+		return typUnknown, invalidTokenError(be.Op, be.OpPos)
+	}
+	op := be.Op.String()
+	if t, ok := infix[be.OpPos]; ok {
+		op = t.String()
+	}
+
+	xt, err := typeCheck(be.X, infix)
+	if err != nil {
+		return xt, err
+	}
+	yt, err := typeCheck(be.Y, infix)
+	if err != nil {
+		return yt, err
+	}
+
+	switch be.Op {
+	case token.ADD, token.SUB: // 加减法只能用于时间和时长，其他情况下减号只能被用于表示负数
+		switch {
+		case xt == typTime && yt == typDuration:
+			return typTime, nil
+		case xt == typDuration && yt == typTime && be.Op == token.ADD:
+			return typTime, nil
+		case xt == typDuration && yt == typDuration:
+			return typDuration, nil
+		case be.Op == token.SUB:
+			return typUnknown, errorAt(be.OpPos, "`-` can only be used for negative numbers or time arithmetic")
+		default:
+			return typUnknown, errorAt(be.OpPos, "`+` can only be used for time arithmetic")
+		}
+
+	case token.LAND, token.LOR: // and/or 的操作数必须都是布尔表达式
+		if xt != typBool || yt != typBool {
+			return typUnknown, errorAt(be.OpPos, "`%s`'s subExpr must be bool expr, got %v and %v", be.Op, xt, yt)
+		}
+
+	default: // 比较操作的操作数必须一个是变量一个是常量
+		if xt.isConst() && yt.isConst() {
+			return typUnknown, errorAt(be.OpPos, "both subExpr of `%s` is const", op)
+		}
+		if xt == typIdent && yt == typIdent {
+			return typUnknown, errorAt(be.OpPos, "both subExpr of `%s` is Ident", op)
+		}
+		if !(xt == typIdent && yt.isConst()) && !(xt.isConst() && yt == typIdent) {
+			return typUnknown, errorAt(be.OpPos, "`%s` must compare an ident with a const, got %v and %v", op, xt, yt)
+		}
+	}
+
+	return typBool, nil
+}
+
+// 检查函数调用
+func checkCallExpr(ce *ast.CallExpr, infix map[token.Pos]Token) (exprType, error) {
+	fnName, ok := ce.Fun.(*ast.Ident)
+	if !ok {
+		return typUnknown, errorAt(ce.Pos(), "invalid func call")
+	}
+
+	args := make([]exprType, 0, len(ce.Args))
+	for _, arg := range ce.Args {
+		t, err := typeCheck(arg, infix)
+		if err != nil {
+			return t, err
+		}
+		args = append(args, t)
+	}
+
+	switch fnName.Name {
+	case "in":
+		if len(args) != 2 {
+			return typUnknown, errorAt(ce.Pos(), "`in` func must have 2 args")
+		}
+
+		// in 函数的第一个参数必须是标识符，第二个参数必须是一个切片
+		if args[0] != typIdent || (args[1] != typIntSlice && args[1] != typStrSlice) {
+			return typUnknown, errorAt(ce.Pos(), "`in` func's signature is in(ident, []int) or in(ident, []string)")
+		}
+		return typBool, nil
+
+	case "time", "duration": // time("2006-01-02T15:04:05Z") 和 duration("15m") 都只接受一个字符串常量
+		if len(args) != 1 || args[0] != typString {
+			return typUnknown, errorAt(ce.Pos(), "`%s` func's signature is %s(string)", fnName.Name, fnName.Name)
+		}
+		if fnName.Name == "time" {
+			return typTime, nil
+		}
+		return typDuration, nil
+
+	case "now":
+		if len(args) != 0 {
+			return typUnknown, errorAt(ce.Pos(), "`now` func must have no args")
+		}
+		return typTime, nil
+
+	default:
+		return typUnknown, errorAt(ce.Pos(), "invalid builtin func(%v)", fnName.Name)
+	}
+}
+
+// 检查切片
+func checkCompositeLit(cl *ast.CompositeLit) (exprType, error) {
+	typ, ok := cl.Type.(*ast.ArrayType)
+	if !ok {
+		return typUnknown, errorAt(cl.Pos(), "invalid CompositeLit")
+	}
+	elt, ok := typ.Elt.(*ast.Ident)
+	if !ok {
+		return typUnknown, errorAt(typ.Pos(), "invalid array type")
+	}
+
+	var sliceTyp exprType
+	var elemKind token.Token
+	switch elt.Name {
+	case "int":
+		sliceTyp, elemKind = typIntSlice, token.INT
+	case "string":
+		sliceTyp, elemKind = typStrSlice, token.STRING
+	default: // 不支持其他类型
+		return typUnknown, errorAt(typ.Pos(), "invalid array type(%v)", elt.Name)
+	}
+
+	for _, elem := range cl.Elts {
+		bl, ok := elem.(*ast.BasicLit)
+		if !ok || bl.Kind != elemKind {
+			return typUnknown, errorAt(elem.Pos(), "invalid array elem, want %v", elt.Name)
+		}
+	}
+	return sliceTyp, nil
+}
@@ -3,12 +3,66 @@ package internal
 import (
 	"errors"
 	"fmt"
+	"strconv"
 )
 
+// 处理完所有 token 后栈的状态不对时返回的错误
+var ErrInvalidTokenSequence = errors.New("invalid token sequence")
+
+// 编译失败时返回的错误，附带出错时编译器的状态，方便定位问题
+type CompileError struct {
+	Err      error    // 错误原因
+	Index    int      // 出错的 token 在 Compiler.Params() 中的下标，处理完所有 token 后才发现的错误为 -1
+	Token    *Param   // 出错的 token，Index 为 -1 时为 nil
+	Units    int      // 出错时栈上 Unit 的个数
+	Literals []*Param // 出错时栈上的操作数
+}
+
+func (e *CompileError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("%v, units(%d), literals(%v)", e.Err, e.Units, e.Literals)
+	}
+	return e.Err.Error()
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// 日志接口，*log.Logger 实现了这个接口
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// 把 Lexer 生成的逆波兰表达式编译成 Unit，编译过程不会修改 Lexer 中的 token；
+// 一个 Compiler 只能在一个 goroutine 中使用，编译出的 Unit 可以在多个 goroutine 中同时执行
 type Compiler struct {
 	lex      *Lexer
+	params   []*Param // 编译的 token 序列，调整执行顺序时是 Lexer.Params 调整后的副本
 	units    []Unit   // 子表达式生成的 Unit
+	nodes    []*Node  // 和 units 一一对应的表达式树节点，用于识别相同的子表达式
 	literals []*Param // 操作数
+	cse      *cseTable
+
+	Clock  Clock  // now() 使用的时钟，为 nil 时使用 SystemClock
+	Logger Logger // 编写规则时用于输出调试信息，为 nil 时不输出
+
+	// 比较运算符的实现，没有的运算符使用 LookupOperator 返回的全局实现，
+	// 某个运算符的函数集中为 nil 的函数也使用全局实现中的对应函数
+	Operators OperatorSet
+
+	// 不为 nil 时按代价调整 &&/|| 操作数的执行顺序并短路求值，
+	// 求值前会按原来的顺序查找所有变量，保证出错时返回的错误和原表达式相同；
+	// 表达式中的运算符使用了自定义的实现时，叶子节点可能因为变量查找以外的原因出错，这时不调整顺序
+	Reorder *CostModel
+
+	// 不为 nil 时求值前先按顺序查找这些变量，第一个失败的查找就是返回的错误，
+	// 用于化简去掉了部分变量查找的表达式，由 OptimizeParams 返回
+	Preflight []Lookup
+
+	lookups   []Lookup
+	prepared  bool
+	reordered bool // 是否调整了执行顺序
 }
 
 func NewCompiler(l *Lexer) *Compiler {
@@ -18,111 +72,257 @@ func NewCompiler(l *Lexer) *Compiler {
 }
 
 func (c *Compiler) Compile() (Unit, error) {
-	for _, t := range c.lex.Params {
+	c.prepare()
+	if c.cse == nil {
+		c.cse = newCSETable()
+		c.countCSE(c.cse)
+	}
+
+	for i, t := range c.params {
 		switch t.Typ {
-		case IDENT, INT, STRING, BOOLEAN, INT_SLICE, STR_SLICE: // 操作数直接入栈供操作符使用
+		case IDENT, INT, STRING, BOOLEAN, INT_SLICE, STR_SLICE, TIME, DURATION: // 操作数直接入栈供操作符使用
 			c.literals = append(c.literals, t)
 
-		case SUB: // 出现减号说明有负数，取栈顶的一个 literal 做处理
+		case SUB: // 出现减号说明有负数，复制栈顶的 literal 再取反，不修改 Lexer 中的 token
 			lastIdx := len(c.literals) - 1
-			lastVal := c.literals[lastIdx]
-			if lastVal.Typ != INT {
-				return nil, errors.New("invalid `-` token")
+			if lastIdx < 0 || c.literals[lastIdx].Typ != INT {
+				return nil, c.fail(i, t, errors.New("invalid `-` token"))
 			}
-			lastVal.IntVal = -lastVal.IntVal
+			neg := *c.literals[lastIdx]
+			neg.IntVal = -neg.IntVal
+			neg.Val = strconv.Itoa(neg.IntVal)
+			c.literals[lastIdx] = &neg
 
 		case NOT: // not 逻辑，取栈顶的一个 unit 做处理
 			lastIdx := len(c.units) - 1
 			if len(c.units) == 0 {
-				return nil, errors.New("invalid `!` token")
+				return nil, c.fail(i, t, errors.New("invalid `!` token"))
 			}
-			c.units[lastIdx] = Not(c.units[lastIdx])
+			n := &Node{Typ: NOT, Children: []*Node{c.nodes[lastIdx]}}
+			c.units[lastIdx], c.nodes[lastIdx] = c.cse.share(n, Not(c.units[lastIdx])), n
 
 		case LAND: // and 逻辑，取栈顶的两个 unit 做处理
 			lastIdx := len(c.units) - 1
 			if len(c.units) < 2 {
-				return nil, errors.New("invalid `&&` token")
+				return nil, c.fail(i, t, errors.New("invalid `&&` token"))
 			}
-			c.units[lastIdx-1] = And(c.units[lastIdx-1], c.units[lastIdx])
-			c.units = c.units[:lastIdx]
+			n := &Node{Typ: LAND, Children: []*Node{c.nodes[lastIdx-1], c.nodes[lastIdx]}}
+			c.units[lastIdx-1], c.nodes[lastIdx-1] = c.cse.share(n, c.and(c.units[lastIdx-1], c.units[lastIdx])), n
+			c.units, c.nodes = c.units[:lastIdx], c.nodes[:lastIdx]
 
 		case LOR: // or 逻辑，取栈顶的两个 unit 做处理
 			lastIdx := len(c.units) - 1
 			if len(c.units) < 2 {
-				return nil, errors.New("invalid `||` token")
+				return nil, c.fail(i, t, errors.New("invalid `||` token"))
 			}
-			c.units[lastIdx-1] = Or(c.units[lastIdx-1], c.units[lastIdx])
-			c.units = c.units[:lastIdx]
+			n := &Node{Typ: LOR, Children: []*Node{c.nodes[lastIdx-1], c.nodes[lastIdx]}}
+			c.units[lastIdx-1], c.nodes[lastIdx-1] = c.cse.share(n, c.or(c.units[lastIdx-1], c.units[lastIdx])), n
+			c.units, c.nodes = c.units[:lastIdx], c.nodes[:lastIdx]
 
 		case EQL, NEQ, LSS, LEQ, GTR, GEQ:
-			u, err := c.handleOperator(t.Typ)
+			u, n, err := c.handleOperator(t.Typ)
 			if err != nil {
-				return nil, err
+				return nil, c.fail(i, t, err)
 			}
-			c.units = append(c.units, u)
+			c.push(u, n)
 
 		case FUNC:
-			u, err := c.handleFuncCall(t.Val)
+			u, n, err := c.handleFuncCall(t.Val)
 			if err != nil {
-				return nil, err
+				return nil, c.fail(i, t, err)
 			}
-			c.units = append(c.units, u)
+			c.push(u, n)
+
+		case CONST: // 优化后的常量，直接生成 Unit
+			c.push(Const(t.BoolVal), &Node{Typ: CONST, BoolVal: t.BoolVal})
 
 		case DOT:
 			lastIdx := len(c.literals) - 1
 			if len(c.literals) < 2 {
-				return nil, errors.New("invalid `.` token")
+				return nil, c.fail(i, t, errors.New("invalid `.` token"))
 			}
 			x, y := c.literals[lastIdx-1], c.literals[lastIdx]
 			c.literals = c.literals[:lastIdx-1]
 			c.literals = append(c.literals, &Param{Typ: IDENT, Val: x.Val + "." + y.Val})
 
-		default: // 剩下的 token 被认为是无效的
-			return nil, fmt.Errorf("invalid `%s` token", t.Typ)
+		default:
+			if !isInfixOp(t.Typ) { // 剩下的 token 被认为是无效的
+				return nil, c.fail(i, t, fmt.Errorf("invalid `%s` token", t.Typ))
+			}
+			u, n, err := c.handleOperator(t.Typ) // 具名中缀运算符和比较运算符的处理方式相同
+			if err != nil {
+				return nil, c.fail(i, t, err)
+			}
+			c.push(u, n)
 		}
+
+		c.logf("token(%d): %v, units: %d, literals: %v", i, t, len(c.units), c.literals)
 	}
 
 	if len(c.units) != 1 || len(c.literals) != 0 { // 最终应该只剩一个 unit，没有多余的 literal
-		fmt.Printf("units: %v\nliterals: %v\n", c.units, c.literals)
-		return nil, errors.New("invalid token sequence")
+		return nil, c.fail(-1, nil, ErrInvalidTokenSequence)
+	}
+	switch {
+	case c.reordered || c.lookups != nil:
+		return Preflight(c.lookups, c.units[0]), nil
+	case c.cse != nil && c.cse.shareVars:
+		return CacheLookups(c.units[0]), nil
+	default:
+		return c.units[0], nil
 	}
-	return c.units[0], nil
 }
 
-// 处理二元运算符
-func (c *Compiler) handleOperator(t Token) (Unit, error) {
+// 生成 &&，调整了执行顺序时短路求值
+func (c *Compiler) and(x, y Unit) Unit {
+	if c.reordered {
+		return ShortAnd(x, y)
+	}
+	return And(x, y)
+}
+
+// 生成 ||，调整了执行顺序时短路求值
+func (c *Compiler) or(x, y Unit) Unit {
+	if c.reordered {
+		return ShortOr(x, y)
+	}
+	return Or(x, y)
+}
+
+// 新的子表达式入栈，相同的子表达式共享同一个 Unit
+func (c *Compiler) push(u Unit, n *Node) {
+	c.units = append(c.units, c.cse.share(n, u))
+	c.nodes = append(c.nodes, n)
+}
+
+// 编译前调整 &&/|| 操作数的执行顺序，只会执行一次；调整后的 token 保存在 c.params 中，不修改 Lexer
+func (c *Compiler) prepare() {
+	if c.prepared {
+		return
+	}
+	c.prepared = true
+	c.params = c.lex.Params
+	c.lookups = c.Preflight
+
+	if c.Reorder != nil && BuiltinOperators(c.params, c.Operators) {
+		if root, err := BuildTree(c.params); err == nil { // 无法还原时保持原样，错误由编译流程报告
+			if c.lookups == nil {
+				c.lookups = lookupsOf(root)
+			}
+			c.params = c.Reorder.Reorder(root).Params()
+			c.reordered = true
+		}
+	}
+}
+
+// 实际编译的 token 序列，没有调整执行顺序时就是 Lexer.Params，CompileError.Index 是其中的下标
+func (c *Compiler) Params() []*Param {
+	c.prepare()
+	return c.params
+}
+
+// 统计子表达式出现的次数，多个 Compiler 使用同一个 cseTable 时，相同的子表达式在它们之间共享；
+// 返回还原出的表达式树，无法还原时返回 nil
+func (c *Compiler) countCSE(t *cseTable) *Node {
+	c.prepare()
+	root, err := BuildTree(c.params)
+	if err != nil { // 无法还原时不做消除，错误由编译流程报告
+		return nil
+	}
+	t.count(root)
+	return root
+}
+
+// 生成附带编译器状态的错误，设置了 Logger 时同时输出
+func (c *Compiler) fail(idx int, t *Param, err error) error {
+	literals := make([]*Param, len(c.literals))
+	copy(literals, c.literals)
+
+	ce := &CompileError{Err: err, Index: idx, Token: t, Units: len(c.units), Literals: literals}
+	c.logf("compile failed: %v", ce)
+	return ce
+}
+
+// 设置了 Logger 时输出调试信息
+func (c *Compiler) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	}
+}
+
+// 处理二元运算符，同时返回对应的表达式树节点
+func (c *Compiler) handleOperator(t Token) (Unit, *Node, error) {
 	if len(c.literals) < 2 {
-		return nil, fmt.Errorf("invalid `%s` token", t)
+		return nil, nil, fmt.Errorf("invalid `%s` token", t)
 	}
 
 	lastIdx := len(c.literals) - 1
 	x, y := c.literals[lastIdx-1], c.literals[lastIdx]
 	c.literals = c.literals[:lastIdx-1]
+	u, err := c.compareUnit(t, x, y)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, compareNode(t, x, y), nil
+}
+
+// 获取比较运算符 t 的实现
+func (c *Compiler) operator(t Token) OperatorFuncs {
+	def, _ := LookupOperator(t)
+	if funcs, ok := c.Operators[t]; ok {
+		return funcs.merge(def)
+	}
+	return def
+}
+
+// t 是否使用内置的实现，只有这时虚拟机才能直接比较
+func (c *Compiler) builtinOperator(t Token) bool {
+	return BuiltinOperators([]*Param{{Typ: t}}, c.Operators)
+}
+
+// 生成变量和常量比较的 Unit
+func (c *Compiler) compareUnit(t Token, x, y *Param) (Unit, error) {
+	// 具名中缀运算符可能只支持部分类型，不支持的类型对应的函数为 nil
+	unsupported := func(c *Param) error {
+		return fmt.Errorf("`%s` does not support %s operand `%s`", t, c.Typ, ParamSource(c))
+	}
+
 	if x.Typ == IDENT { // x 是变量
-		opFuncs := DefaultOperatorSet[t]
+		opFuncs := c.operator(t)
 
-		switch y.Typ {
-		case BOOLEAN: // y 是布尔值
+		switch {
+		case y.Typ == BOOLEAN && opFuncs.VarToBool != nil: // y 是布尔值
 			return opFuncs.VarToBool(x.Val, y.BoolVal), nil
-		case INT: // y 是数字
+		case y.Typ == INT && opFuncs.VarToInt != nil: // y 是数字
 			return opFuncs.VarToInt(x.Val, y.IntVal), nil
-		case STRING: // y 是字符串
+		case y.Typ == STRING && opFuncs.VarToStr != nil: // y 是字符串
 			return opFuncs.VarToStr(x.Val, y.Val), nil
+		case y.Typ == TIME && opFuncs.VarToTime != nil: // y 是时间
+			return opFuncs.VarToTime(x.Val, timeFuncOf(y, c.clock())), nil
+		case y.Typ == DURATION && opFuncs.VarToDuration != nil: // y 是时长
+			return opFuncs.VarToDuration(x.Val, y.DurationVal), nil
+		case isInfixOp(t):
+			return nil, unsupported(y)
 		default:
 			return nil, fmt.Errorf("invalid `%s` token", t)
 		}
 	}
 
 	if y.Typ == IDENT { // y 是变量
-		opFuncs := DefaultOperatorSet[t]
+		opFuncs := c.operator(t)
 
-		switch x.Typ {
-		case BOOLEAN: // x 是布尔值
+		switch {
+		case x.Typ == BOOLEAN && opFuncs.BoolToVar != nil: // x 是布尔值
 			return opFuncs.BoolToVar(x.BoolVal, y.Val), nil
-		case INT: // x 是数字
+		case x.Typ == INT && opFuncs.IntToVar != nil: // x 是数字
 			return opFuncs.IntToVar(x.IntVal, y.Val), nil
-		case STRING: // x 是字符串
+		case x.Typ == STRING && opFuncs.StrToVar != nil: // x 是字符串
 			return opFuncs.StrToVar(x.Val, y.Val), nil
+		case x.Typ == TIME && opFuncs.TimeToVar != nil: // x 是时间
+			return opFuncs.TimeToVar(timeFuncOf(x, c.clock()), y.Val), nil
+		case x.Typ == DURATION && opFuncs.DurationToVar != nil: // x 是时长
+			return opFuncs.DurationToVar(x.DurationVal, y.Val), nil
+		case isInfixOp(t):
+			return nil, unsupported(x)
 		default:
 			return nil, fmt.Errorf("invalid `%s` token", t)
 		}
@@ -132,16 +332,32 @@ func (c *Compiler) handleOperator(t Token) (Unit, error) {
 	return nil, fmt.Errorf("invalid `%s` token", t)
 }
 
-// 处理函数调用
-func (c *Compiler) handleFuncCall(name string) (Unit, error) {
+// 获取 now() 使用的时钟
+func (c *Compiler) clock() Clock {
+	if c.Clock == nil {
+		return SystemClock
+	}
+	return c.Clock
+}
+
+// 处理函数调用，同时返回对应的表达式树节点
+func (c *Compiler) handleFuncCall(name string) (Unit, *Node, error) {
 	if len(c.literals) < 2 {
-		return nil, fmt.Errorf("invalid `%s` func call", name)
+		return nil, nil, fmt.Errorf("invalid `%s` func call", name)
 	}
 
 	lastIdx := len(c.literals) - 1
 	x, y := c.literals[lastIdx-1], c.literals[lastIdx]
 	c.literals = c.literals[:lastIdx-1]
+	u, err := c.funcUnit(name, x, y)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, &Node{Typ: FUNC, Val: name, Args: []*Param{x, y}}, nil
+}
 
+// 生成函数调用的 Unit
+func (c *Compiler) funcUnit(name string, x, y *Param) (Unit, error) {
 	switch name {
 	case "in":
 		if x.Typ == IDENT && y.Typ == STR_SLICE { // in(a, []string{})
@@ -1,6 +1,15 @@
 package internal
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+)
 
 func TestNotAndOr(t *testing.T) {
 	lex := NewLexer(`
@@ -58,7 +67,7 @@ func TestNotAndOr(t *testing.T) {
 	}
 
 	for idx, c := range cases {
-		fnRet, err := fn(c.arg)
+		fnRet, err := fn.Eval(c.arg)
 		if err != nil {
 			t.Fatalf("failed to call fn, err: %v", err)
 		}
@@ -132,7 +141,7 @@ func TestIn(t *testing.T) {
 		}
 
 		for _, pair := range c.SubCases {
-			ret, err := fn(pair.Vars)
+			ret, err := fn.Eval(pair.Vars)
 			if err != nil {
 				t.Fatalf("failed to call fn for expr(%v) with kv(%v), err: %v", c.Expr, pair.Vars, err)
 			}
@@ -195,7 +204,7 @@ func TestDot(t *testing.T) {
 		}
 
 		for _, pair := range c.SubCases {
-			ret, err := fn(pair.Vars)
+			ret, err := fn.Eval(pair.Vars)
 			if err != nil {
 				t.Fatalf("failed to call fn for expr(%v) with kv(%v), err: %v", c.Expr, pair.Vars, err)
 			}
@@ -208,3 +217,372 @@ func TestDot(t *testing.T) {
 		t.Logf("test case %d pass", idx)
 	}
 }
+
+func TestNestedNot(t *testing.T) {
+	type Pair struct {
+		Vars Kv
+		Ret  bool
+	}
+
+	cases := []struct {
+		Expr     string
+		SubCases []Pair
+	}{
+		{"!((a == 1))", []Pair{
+			{Kv{"a": 0}, true},
+			{Kv{"a": 1}, false},
+		}},
+
+		{"!!in(a, []int{1,2})", []Pair{
+			{Kv{"a": 0}, false},
+			{Kv{"a": 2}, true},
+		}},
+
+		{"!(!(a == 1) && !!((b == 2)))", []Pair{
+			{Kv{"a": 1, "b": 2}, true},
+			{Kv{"a": 0, "b": 2}, false},
+			{Kv{"a": 0, "b": 3}, true},
+		}},
+	}
+
+	for idx, c := range cases {
+		lex := NewLexer(c.Expr)
+		if err := lex.Parse(); err != nil {
+			t.Fatalf("faild to parse %q, err: %v", c.Expr, err)
+		}
+
+		fn, err := NewCompiler(lex).Compile()
+		if err != nil {
+			t.Fatalf("failed to compile %q, err: %v", c.Expr, err)
+		}
+
+		for _, pair := range c.SubCases {
+			ret, err := fn.Eval(pair.Vars)
+			if err != nil {
+				t.Fatalf("failed to call fn for expr(%v) with kv(%v), err: %v", c.Expr, pair.Vars, err)
+			}
+
+			if ret != pair.Ret {
+				t.Fatalf("failed to call fn for expr(%v) with kv(%v), shouldRet: %v", c.Expr, pair.Vars, pair.Ret)
+			}
+		}
+
+		t.Logf("test case %d pass", idx)
+	}
+}
+
+func TestTime(t *testing.T) {
+	type Pair struct {
+		Vars Kv
+		Ret  bool
+	}
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return now })
+
+	cases := []struct {
+		Expr     string
+		SubCases []Pair
+	}{
+		{`created_at > now() - duration("24h")`, []Pair{
+			{Kv{"created_at": now.Add(-time.Hour)}, true},
+			{Kv{"created_at": now.Add(-25 * time.Hour)}, false},
+			{Kv{"created_at": "2024-06-01T00:00:00Z"}, true},
+			{Kv{"created_at": "2024-05-01T00:00:00Z"}, false},
+			{Kv{"created_at": int(now.Unix())}, true},
+			{Kv{"created_at": now.Unix() - 86401}, false},
+		}},
+
+		{`time("2024-01-01T00:00:00Z") <= expired_at && !(expired_at == time("2024-01-01T00:00:00Z"))`, []Pair{
+			{Kv{"expired_at": "2024-01-01T00:00:00Z"}, false},
+			{Kv{"expired_at": "2024-01-01T00:00:01Z"}, true},
+			{Kv{"expired_at": "2023-12-31T23:59:59Z"}, false},
+		}},
+
+		{`ttl >= duration("15m") + duration("15m")`, []Pair{
+			{Kv{"ttl": 30 * time.Minute}, true},
+			{Kv{"ttl": "29m59s"}, false},
+			{Kv{"ttl": "1h"}, true},
+		}},
+	}
+
+	for idx, c := range cases {
+		lex := NewLexer(c.Expr)
+		if err := lex.Parse(); err != nil {
+			t.Fatalf("faild to parse %q, err: %v", c.Expr, err)
+		}
+
+		compiler := NewCompiler(lex)
+		compiler.Clock = clock
+		fn, err := compiler.Compile()
+		if err != nil {
+			t.Fatalf("failed to compile %q, err: %v", c.Expr, err)
+		}
+
+		for _, pair := range c.SubCases {
+			ret, err := fn.Eval(pair.Vars)
+			if err != nil {
+				t.Fatalf("failed to call fn for expr(%v) with kv(%v), err: %v", c.Expr, pair.Vars, err)
+			}
+
+			if ret != pair.Ret {
+				t.Fatalf("failed to call fn for expr(%v) with kv(%v), shouldRet: %v", c.Expr, pair.Vars, pair.Ret)
+			}
+		}
+
+		t.Logf("test case %d pass", idx)
+	}
+
+	// 变量类型不对时应该返回错误
+	lex := NewLexer(`a > now()`)
+	if err := lex.Parse(); err != nil {
+		t.Fatal("faild to call Parse, err:", err)
+	}
+	fn, err := NewCompiler(lex).Compile()
+	if err != nil {
+		t.Fatal("failed to call Compile, err:", err)
+	}
+	if _, err := fn.Eval(Kv{"a": true}); err == nil {
+		t.Fatal("should fail with bool value")
+	}
+}
+
+func TestEnv(t *testing.T) {
+	lex := NewLexer(`created_at > now() - duration("1h")`)
+	if err := lex.Parse(); err != nil {
+		t.Fatal("faild to call Parse, err:", err)
+	}
+
+	fn, err := NewCompiler(lex).Compile()
+	if err != nil {
+		t.Fatal("failed to call Compile, err:", err)
+	}
+
+	// 使用 Env 中的时钟回放历史数据，结果不受系统时间影响
+	history := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	env := &Env{
+		Vars:  Kv{"created_at": history.Add(-time.Minute)},
+		Clock: ClockFunc(func() time.Time { return history }),
+	}
+	for i := 0; i < 3; i++ {
+		ret, err := fn.EvalEnv(env)
+		if err != nil {
+			t.Fatal("failed to call EvalEnv, err:", err)
+		}
+		if !ret {
+			t.Fatal("should be true with the clock in env")
+		}
+	}
+
+	// 没有指定时钟时使用系统时钟
+	if fn.GetBool(env.Vars) {
+		t.Fatal("should be false with the system clock")
+	}
+
+	// 求值前已经被取消
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	env.Ctx = ctx
+	if _, err := fn.EvalEnv(env); err != context.Canceled {
+		t.Fatalf("should return context.Canceled, got %v", err)
+	}
+}
+
+func TestEnvRandLocale(t *testing.T) {
+	// 自定义的 == 按 Env 中的区域设置比较，tr 中 i 的大写是 İ
+	localeEqual := func(varname string, val string) Unit {
+		return func(env *Env) (bool, error) {
+			s, err := env.GetString(varname)
+			if err != nil {
+				return false, err
+			}
+			if env.Locale == "tr" {
+				return strings.ToUpperSpecial(unicode.TurkishCase, s) == strings.ToUpperSpecial(unicode.TurkishCase, val), nil
+			}
+			return strings.EqualFold(s, val), nil
+		}
+	}
+	// 自定义的 < 把变量当作百分比抽样，结果取决于 Env 中的随机数来源
+	sample := func(varname string, val int) Unit {
+		return func(env *Env) (bool, error) {
+			return env.Float64()*100 < float64(val), nil
+		}
+	}
+
+	lex := NewLexer(`city == "istanbul" && sample < 50`)
+	if err := lex.Parse(); err != nil {
+		t.Fatal("faild to call Parse, err:", err)
+	}
+	c := NewCompiler(lex)
+	c.Operators = OperatorSet{EQL: {VarToStr: localeEqual}, LSS: {VarToInt: sample}}
+	fn, err := c.Compile()
+	if err != nil {
+		t.Fatal("failed to call Compile, err:", err)
+	}
+
+	vars := Kv{"city": "İSTANBUL", "sample": 0}
+	run := func(locale string, seed int64) []bool {
+		env := &Env{Vars: vars, Locale: locale, Rand: rand.New(rand.NewSource(seed))}
+		rets := make([]bool, 0, 20)
+		for i := 0; i < 20; i++ {
+			ret, err := fn.EvalEnv(env)
+			if err != nil {
+				t.Fatal("failed to call EvalEnv, err:", err)
+			}
+			rets = append(rets, ret)
+		}
+		return rets
+	}
+
+	// 相同的随机数来源得到相同的结果，其中有成立的也有不成立的
+	first := run("tr", 1)
+	if fmt.Sprint(first) != fmt.Sprint(run("tr", 1)) {
+		t.Fatal("results should be reproducible with the same rand source")
+	}
+	if !strings.Contains(fmt.Sprint(first), "true") || !strings.Contains(fmt.Sprint(first), "false") {
+		t.Fatalf("should be sampled, got %v", first)
+	}
+	// 不区分区域设置时 İ 和 i 不相等
+	if got := fmt.Sprint(run("", 1)); strings.Contains(got, "true") {
+		t.Fatalf("should be false without locale, got %v", got)
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	lex := NewLexer(`a == 1 && b == 2 && c == 3 && d == 4`)
+	if err := lex.Parse(); err != nil {
+		t.Fatal("faild to call Parse, err:", err)
+	}
+
+	fn, err := NewCompiler(lex).Compile()
+	if err != nil {
+		t.Fatal("failed to call Compile, err:", err)
+	}
+	vars := Kv{"a": 1, "b": 2, "c": 3, "d": 4}
+
+	ret, err := fn.EvalContext(context.Background(), vars)
+	if err != nil || !ret {
+		t.Fatalf("should return true without budget, got %v, %v", ret, err)
+	}
+
+	ret, err = fn.EvalContext(WithStepBudget(context.Background(), 100), vars)
+	if err != nil || !ret {
+		t.Fatalf("should return true within budget, got %v, %v", ret, err)
+	}
+
+	if _, err := fn.EvalContext(WithStepBudget(context.Background(), 3), vars); err != ErrStepBudgetExceeded {
+		t.Fatalf("should return ErrStepBudgetExceeded, got %v", err)
+	}
+
+	// 求值过程中被取消，后续的子表达式不再执行
+	ctx, cancel := context.WithCancel(context.Background())
+	executed := false
+	cancelFn := Unit(func(*Env) (bool, error) {
+		cancel()
+		return true, nil
+	})
+	afterFn := Unit(func(*Env) (bool, error) {
+		executed = true
+		return true, nil
+	})
+	if _, err := And(cancelFn, afterFn).EvalContext(ctx, vars); err != context.Canceled {
+		t.Fatalf("should return context.Canceled, got %v", err)
+	}
+	if executed {
+		t.Fatal("subExpr should not be executed after cancel")
+	}
+}
+
+type testLogger []string
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	*l = append(*l, fmt.Sprintf(format, v...))
+}
+
+func TestCompileError(t *testing.T) {
+	// `a.b.c` 不是布尔表达式，无法通过 Parse，直接构造它的 token 序列
+	lex := &Lexer{Params: []*Param{
+		{Typ: IDENT, Val: "a"}, {Typ: IDENT, Val: "b"}, {Typ: DOT, Val: "."}, {Typ: IDENT, Val: "c"}, {Typ: DOT, Val: "."},
+	}}
+
+	logger := &testLogger{}
+	compiler := NewCompiler(lex)
+	compiler.Logger = logger
+	_, err := compiler.Compile()
+
+	var ce *CompileError
+	if !errors.As(err, &ce) || !errors.Is(err, ErrInvalidTokenSequence) {
+		t.Fatalf("should return CompileError with ErrInvalidTokenSequence, got %v", err)
+	}
+	if ce.Index != -1 || ce.Units != 0 || len(ce.Literals) != 1 || ce.Literals[0].Val != "a.b.c" {
+		t.Fatalf("unexpected diagnostic: %+v", ce)
+	}
+	if len(*logger) != len(lex.Params)+1 {
+		t.Fatalf("should log every token and the failure, got %q", *logger)
+	}
+
+	// 出错的 token 也会记录在错误中
+	lex = &Lexer{Params: []*Param{{Typ: IDENT, Val: "a"}, {Typ: NOT, Val: "!"}}}
+	_, err = NewCompiler(lex).Compile()
+	if !errors.As(err, &ce) || ce.Index != 1 || ce.Token.Typ != NOT {
+		t.Fatalf("unexpected diagnostic: %v", err)
+	}
+}
+
+func TestCSE(t *testing.T) {
+	// 统计 region == "EU" 的执行次数
+	count := 0
+	origin, _ := LookupOperator(EQL)
+	defer restoreOperator(EQL, origin)
+	opFuncs := origin
+	opFuncs.VarToStr = func(varname string, val string) Unit {
+		u := origin.VarToStr(varname, val)
+		return func(env *Env) (bool, error) {
+			count++
+			return u(env)
+		}
+	}
+	if err := RegisterOperator(EQL, opFuncs); err != nil {
+		t.Fatal("failed to call RegisterOperator, err:", err)
+	}
+
+	lex := NewLexer(`(region == "EU" && a > 1) || (region == "EU" && b > 1) || ("EU" == region && a < 5)`)
+	if err := lex.Parse(); err != nil {
+		t.Fatal("faild to call Parse, err:", err)
+	}
+	fn, err := NewCompiler(lex).Compile()
+	if err != nil {
+		t.Fatal("faild to call Compile, err:", err)
+	}
+
+	env := NewEnv(Kv{"region": "EU", "a": 0, "b": 2})
+	for i := 1; i <= 2; i++ { // 同一个 Env 多次求值时不会复用上一次的结果
+		if ret, err := fn.EvalEnv(env); !ret || err != nil {
+			t.Fatalf("should be true, got %v, %v", ret, err)
+		}
+		if count != i {
+			t.Fatalf("region == \"EU\" should run once per evaluation, got %d", count)
+		}
+	}
+
+	// a 被多个不同的子表达式使用，查找结果会被缓存
+	if e, ok := env.lookups["a"]; !ok || e.gen != env.gen || e.val.(int) != 0 {
+		t.Fatalf("lookup of `a` should be cached, got %+v", env.lookups)
+	}
+
+	// 错误同样会被缓存
+	count = 0
+	if _, err := fn.Eval(Kv{"a": 0, "b": 2}); err == nil || count != 1 {
+		t.Fatalf("should return error once, got %v, count %d", err, count)
+	}
+
+	// 没有重复的子表达式时不做缓存
+	lex = NewLexer(`region == "EU" && a > 1`)
+	if err := lex.Parse(); err != nil {
+		t.Fatal("faild to call Parse, err:", err)
+	}
+	compiler := NewCompiler(lex)
+	if _, err := compiler.Compile(); err != nil || compiler.cse.hasDup || compiler.cse.shareVars {
+		t.Fatalf("should not share anything, got %v, %+v", err, compiler.cse)
+	}
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+)
+
+// 决策列表，按顺序执行规则，返回第一条成立的规则对应的结果，都不成立时返回默认值
+type DecisionList struct {
+	units    []Unit
+	trees    []*Node // 用于检查被遮蔽的规则，无法还原的规则为 nil
+	payloads []interface{}
+	def      interface{}
+}
+
+// 创建决策列表，units、trees 和 payloads 一一对应
+func NewDecisionList(units []Unit, trees []*Node, payloads []interface{}, def interface{}) *DecisionList {
+	return &DecisionList{units: units, trees: trees, payloads: payloads, def: def}
+}
+
+// 使用默认的求值上下文执行规则，返回第一条成立的规则对应的结果
+func (dl *DecisionList) Eval(vars Kv) (interface{}, error) {
+	return dl.EvalEnv(NewEnv(vars))
+}
+
+// 执行规则，每个子表达式执行前都会检查 ctx 是否已经被取消，WithStepBudget 设置的步数上限对每条规则分别生效
+func (dl *DecisionList) EvalContext(ctx context.Context, vars Kv) (interface{}, error) {
+	return dl.EvalEnv(&Env{Vars: vars, Ctx: ctx, MaxSteps: StepBudget(ctx)})
+}
+
+// 执行规则，返回第一条成立的规则对应的结果，都不成立时返回默认值；
+// 规则出错时直接返回错误，后面的规则不再执行
+func (dl *DecisionList) EvalEnv(env *Env) (interface{}, error) {
+	i, err := dl.Match(env)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("rule %d: %w", i, err)
+	case i < 0:
+		return dl.def, nil
+	default:
+		return dl.payloads[i], nil
+	}
+}
+
+// 返回第一条成立的规则的下标，都不成立时返回 -1，出错时返回出错的规则的下标和错误
+func (dl *DecisionList) Match(env *Env) (int, error) {
+	for i, u := range dl.units {
+		ok, err := u.EvalEnv(env)
+		if err != nil {
+			return i, err
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// 被前面的规则遮蔽的规则：它成立时前面的某条规则一定成立，所以永远不会被选中
+type Shadow struct {
+	Index  int    // 被遮蔽的规则的下标
+	By     int    // 遮蔽它的规则的下标
+	Expr   string // 被遮蔽的规则
+	ByExpr string // 遮蔽它的规则
+}
+
+func (s Shadow) String() string {
+	return fmt.Sprintf("rule %d `%s` is shadowed by rule %d `%s`", s.Index, s.Expr, s.By, s.ByExpr)
+}
+
+// 检查被前面更宽泛的规则遮蔽的规则，每条规则只报告第一条遮蔽它的规则，
+// 推导基于同一个变量上的区间和等值关系，返回的结果为空时不代表没有被遮蔽的规则
+func (dl *DecisionList) Shadowed() []Shadow {
+	var ret []Shadow
+	for i, x := range dl.trees {
+		if x == nil {
+			continue
+		}
+		for j, y := range dl.trees[:i] {
+			if y != nil && Implies(x, y) {
+				ret = append(ret, Shadow{Index: i, By: j, Expr: x.String(), ByExpr: y.String()})
+				break
+			}
+		}
+	}
+	return ret
+}
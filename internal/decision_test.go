@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// 编译决策列表，rules 中依次为表达式和结果
+func mustDecisionList(t *testing.T, def interface{}, rules ...string) *DecisionList {
+	t.Helper()
+
+	var units []Unit
+	var trees []*Node
+	var payloads []interface{}
+	for i := 0; i < len(rules); i += 2 {
+		fn, err := NewCompiler(mustLexer(t, rules[i])).Compile()
+		if err != nil {
+			t.Fatal("failed to call Compile, err:", err)
+		}
+		units = append(units, fn)
+		trees = append(trees, mustTree(t, rules[i]))
+		payloads = append(payloads, rules[i+1])
+	}
+	return NewDecisionList(units, trees, payloads, def)
+}
+
+func TestDecisionList(t *testing.T) {
+	dl := mustDecisionList(t, "standard",
+		`country == "US" && spend > 1000`, "gold",
+		`spend > 1000`, "silver",
+		`in(country, []string{"US", "CA"})`, "bronze",
+	)
+
+	cases := []struct {
+		arg Kv
+		ret interface{}
+	}{
+		{arg: Kv{"country": "US", "spend": 2000}, ret: "gold"},
+		{arg: Kv{"country": "CN", "spend": 2000}, ret: "silver"},
+		{arg: Kv{"country": "CA", "spend": 10}, ret: "bronze"},
+		{arg: Kv{"country": "CN", "spend": 10}, ret: "standard"},
+	}
+	for _, c := range cases {
+		if ret, err := dl.Eval(c.arg); err != nil || ret != c.ret {
+			t.Fatalf("arg: %v, should be %v, got %v, %v", c.arg, c.ret, ret, err)
+		}
+	}
+
+	// 出错时不再执行后面的规则
+	if _, err := dl.Eval(Kv{"country": "US"}); err == nil || err.Error() != "rule 0: failed to get int by key(spend)" {
+		t.Fatalf("should return error of rule 0, got %v", err)
+	}
+	ctx := WithStepBudget(context.Background(), 1)
+	if _, err := dl.EvalContext(ctx, Kv{"country": "US", "spend": 2000}); !errors.Is(err, ErrStepBudgetExceeded) {
+		t.Fatalf("should return ErrStepBudgetExceeded, got %v", err)
+	}
+
+	if i, err := dl.Match(NewEnv(Kv{"country": "CN", "spend": 10})); i != -1 || err != nil {
+		t.Fatalf("should match nothing, got %v, %v", i, err)
+	}
+	if i, err := dl.Match(NewEnv(Kv{"country": "CN"})); i != 0 || err == nil {
+		t.Fatalf("should return error of rule 0, got %v, %v", i, err)
+	}
+}
+
+func TestShadowed(t *testing.T) {
+	dl := mustDecisionList(t, nil,
+		`age > 18`, "adult",
+		`age > 21 && country == "US"`, "us drinker", // 被 0 遮蔽
+		`in(country, []string{"US", "CA"})`, "na",
+		`country == "CA" && age <= 18`, "ca minor", // 被 2 遮蔽
+		`age > 10 || vip == true`, "teen",
+		`age > 5 && age < 3`, "never", // 永远不成立
+		`t > now()`, "future",
+	)
+
+	var got [][2]int
+	for _, s := range dl.Shadowed() {
+		got = append(got, [2]int{s.Index, s.By})
+	}
+	if want := [][2]int{{1, 0}, {3, 2}, {5, 0}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("should be %v, got %v", want, got)
+	}
+
+	s := dl.Shadowed()[0].String()
+	if s != "rule 1 `age > 21 && country == \"US\"` is shadowed by rule 0 `age > 18`" {
+		t.Fatalf("unexpected message: %s", s)
+	}
+}
@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// 求值步数超过上限时返回的错误
+var ErrStepBudgetExceeded = errors.New("evaluation step budget exceeded")
+
+// 求值上下文，和 Kv 一起传给编译出的函数，
+// 其中的时钟、随机数来源和区域设置都可以固定下来，保证在测试或回放历史数据时结果可以复现；
+// 同一个 Env 可以依次用于多次求值，每次 Unit.EvalEnv 开始时都会清零步数并让上一次缓存的结果过期，
+// 所以修改 Vars 后可以直接再次求值，但不能在多个 goroutine 中同时使用
+type Env struct {
+	Vars   Kv              // 变量
+	Clock  Clock           // now() 使用的时钟，为 nil 时使用编译时指定的时钟
+	Rand   *rand.Rand      // 随机数来源，自定义的运算符通过 Float64 获取随机数，为 nil 时使用全局的随机数
+	Locale string          // 区域设置，供自定义的运算符做和语言相关的比较，为空时表示不区分
+	Ctx    context.Context // 用于取消求值，为 nil 时不会被取消
+
+	// 求值的步数上限，每执行一个子表达式算一步，为 0 时不限制
+	MaxSteps int
+	steps    int // 本次求值已经执行的步数
+
+	gen          uint64                 // 当前是第几次求值，缓存中 gen 不同的结果已经过期
+	memo         []memoEntry            // 公共子表达式的结果，下标由 Compiler 分配
+	lookups      map[string]lookupEntry // 变量查找的结果
+	cacheLookups bool                   // 是否缓存变量查找的结果，同一个变量被多个子表达式使用时才会开启
+}
+
+// 缓存的子表达式结果
+type memoEntry struct {
+	gen uint64
+	val bool
+	err error
+}
+
+// 缓存的变量查找结果，同一个变量可能被当作不同的类型使用，所以要记录类型
+type lookupEntry struct {
+	gen uint64
+	typ Token
+	val interface{}
+	err error
+}
+
+// 使用默认设置创建求值上下文
+func NewEnv(vars Kv) *Env {
+	return &Env{Vars: vars}
+}
+
+// 开始一次新的求值，之前缓存的结果全部过期
+func (env *Env) reset() {
+	env.steps = 0
+	env.gen++
+}
+
+// 获取缓存的子表达式结果
+func (env *Env) memoized(slot int) (val bool, err error, ok bool) {
+	if slot >= len(env.memo) || env.memo[slot].gen != env.gen {
+		return false, nil, false
+	}
+	e := env.memo[slot]
+	return e.val, e.err, true
+}
+
+// 缓存子表达式的结果
+func (env *Env) memoize(slot int, val bool, err error) {
+	if slot >= len(env.memo) {
+		if slot < cap(env.memo) {
+			env.memo = env.memo[:slot+1]
+		} else { // 按倍数扩容，slot 逐个增长时避免反复复制
+			memo := make([]memoEntry, slot+1, 2*(slot+1))
+			copy(memo, env.memo)
+			env.memo = memo
+		}
+	}
+	env.memo[slot] = memoEntry{gen: env.gen, val: val, err: err}
+}
+
+// 获取缓存的变量查找结果
+func (env *Env) cachedLookup(key string, typ Token) (lookupEntry, bool) {
+	e, ok := env.lookups[key]
+	return e, ok && e.gen == env.gen && e.typ == typ
+}
+
+// 缓存变量查找的结果
+func (env *Env) storeLookup(key string, typ Token, val interface{}, err error) {
+	if env.lookups == nil {
+		env.lookups = make(map[string]lookupEntry)
+	}
+	env.lookups[key] = lookupEntry{gen: env.gen, typ: typ, val: val, err: err}
+}
+
+// 获取整数类型的变量，开启缓存时一次求值中同一个变量只会查找一次
+func (env *Env) GetInt(key string) (int, error) {
+	if !env.cacheLookups { // 不缓存时直接查找，避免把结果转换成 interface{} 带来的内存分配
+		return env.Vars.GetInt(key)
+	}
+	if e, ok := env.cachedLookup(key, INT); ok {
+		return e.val.(int), e.err
+	}
+	val, err := env.Vars.GetInt(key)
+	env.storeLookup(key, INT, val, err)
+	return val, err
+}
+
+// 获取字符串类型的变量，开启缓存时一次求值中同一个变量只会查找一次
+func (env *Env) GetString(key string) (string, error) {
+	if !env.cacheLookups { // 不缓存时直接查找，避免把结果转换成 interface{} 带来的内存分配
+		return env.Vars.GetString(key)
+	}
+	if e, ok := env.cachedLookup(key, STRING); ok {
+		return e.val.(string), e.err
+	}
+	val, err := env.Vars.GetString(key)
+	env.storeLookup(key, STRING, val, err)
+	return val, err
+}
+
+// 获取布尔类型的变量，开启缓存时一次求值中同一个变量只会查找一次
+func (env *Env) GetBool(key string) (bool, error) {
+	if !env.cacheLookups { // 不缓存时直接查找，避免把结果转换成 interface{} 带来的内存分配
+		return env.Vars.GetBool(key)
+	}
+	if e, ok := env.cachedLookup(key, BOOLEAN); ok {
+		return e.val.(bool), e.err
+	}
+	val, err := env.Vars.GetBool(key)
+	env.storeLookup(key, BOOLEAN, val, err)
+	return val, err
+}
+
+// 获取时间类型的变量，开启缓存时一次求值中同一个变量只会查找一次
+func (env *Env) GetTime(key string) (time.Time, error) {
+	if !env.cacheLookups { // 不缓存时直接查找，避免把结果转换成 interface{} 带来的内存分配
+		return env.Vars.GetTime(key)
+	}
+	if e, ok := env.cachedLookup(key, TIME); ok {
+		return e.val.(time.Time), e.err
+	}
+	val, err := env.Vars.GetTime(key)
+	env.storeLookup(key, TIME, val, err)
+	return val, err
+}
+
+// 获取时长类型的变量，开启缓存时一次求值中同一个变量只会查找一次
+func (env *Env) GetDuration(key string) (time.Duration, error) {
+	if !env.cacheLookups { // 不缓存时直接查找，避免把结果转换成 interface{} 带来的内存分配
+		return env.Vars.GetDuration(key)
+	}
+	if e, ok := env.cachedLookup(key, DURATION); ok {
+		return e.val.(time.Duration), e.err
+	}
+	val, err := env.Vars.GetDuration(key)
+	env.storeLookup(key, DURATION, val, err)
+	return val, err
+}
+
+// 获取 now() 使用的时钟，Env 中没有指定时使用 defaultClock
+func (env *Env) clock(defaultClock Clock) Clock {
+	if env.Clock != nil {
+		return env.Clock
+	}
+	return defaultClock
+}
+
+// 获取一个 [0, 1) 之间的随机数，Env 中没有指定随机数来源时使用全局的随机数
+func (env *Env) Float64() float64 {
+	if env.Rand != nil {
+		return env.Rand.Float64()
+	}
+	return rand.Float64()
+}
+
+// 检查求值是否已经被取消
+func (env *Env) Err() error {
+	if env.Ctx == nil {
+		return nil
+	}
+	return env.Ctx.Err()
+}
+
+// 执行一个子表达式前调用，检查是否已经被取消或超过了步数上限
+func (env *Env) step() error {
+	env.steps++
+	if env.MaxSteps > 0 && env.steps > env.MaxSteps {
+		return ErrStepBudgetExceeded
+	}
+
+	if env.Ctx != nil {
+		select {
+		case <-env.Ctx.Done():
+			return env.Ctx.Err()
+		default:
+		}
+	}
+	return nil
+}
+
+type stepBudgetKey struct{}
+
+// 在 ctx 中设置求值的步数上限，供 Unit.EvalContext 使用
+func WithStepBudget(ctx context.Context, maxSteps int) context.Context {
+	return context.WithValue(ctx, stepBudgetKey{}, maxSteps)
+}
+
+// 获取 ctx 中设置的步数上限，没有设置时返回 0
+func StepBudget(ctx context.Context) int {
+	maxSteps, _ := ctx.Value(stepBudgetKey{}).(int)
+	return maxSteps
+}
@@ -2,8 +2,8 @@ package internal
 
 // x 在 s 代表的整数切片中
 func InIntSlice(x string, s []int) Unit {
-	return func(vals Kv) (bool, error) {
-		xVal, err := vals.GetInt(x)
+	return func(env *Env) (bool, error) {
+		xVal, err := env.GetInt(x)
 		if err != nil {
 			return false, err
 		}
@@ -19,8 +19,8 @@ func InIntSlice(x string, s []int) Unit {
 
 // x 在 s 代表的字符串切片中
 func InStrSlice(x string, s []string) Unit {
-	return func(vals Kv) (bool, error) {
-		xVal, err := vals.GetString(x)
+	return func(env *Env) (bool, error) {
+		xVal, err := env.GetString(x)
 		if err != nil {
 			return false, err
 		}
@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 生成 Go 代码时的配置
+type GenConfig struct {
+	Package  string            // 生成的代码所在的包，默认为 rules
+	FuncName string            // 生成的函数名，默认为 Match
+	TypeName string            // 输入的结构体名，默认为 Input
+	Schema   map[string]string // 变量名到 Go 类型的映射，支持 int、string、bool、time.Time、time.Duration，未指定的变量根据比较的常量推导
+}
+
+// 变量类型和 Go 类型的对应关系
+var goTypes = map[Token]string{
+	INT:      "int",
+	STRING:   "string",
+	BOOLEAN:  "bool",
+	TIME:     "time.Time",
+	DURATION: "time.Duration",
+}
+
+// 生成 Go 代码时的状态
+type generator struct {
+	cfg     GenConfig
+	types   map[string]Token  // 变量的类型
+	fields  map[string]string // 变量对应的字段名
+	useTime bool              // 是否需要 import "time"
+}
+
+// 根据表达式树生成一个 Go 源文件，其中包含输入的结构体和 func(in *Input) bool 形式的函数，
+// 生成的代码已经过 gofmt 格式化，src 为原表达式，会作为注释写在函数前面
+func Generate(src string, n *Node, cfg GenConfig) ([]byte, error) {
+	if cfg.Package == "" {
+		cfg.Package = "rules"
+	}
+	if cfg.FuncName == "" {
+		cfg.FuncName = "Match"
+	}
+	if cfg.TypeName == "" {
+		cfg.TypeName = "Input"
+	}
+	for _, name := range []string{cfg.Package, cfg.FuncName, cfg.TypeName} {
+		if !token.IsIdentifier(name) {
+			return nil, fmt.Errorf("invalid identifier %q", name)
+		}
+	}
+
+	g := &generator{cfg: cfg, types: map[string]Token{}, fields: map[string]string{}}
+	if err := g.collect(n); err != nil {
+		return nil, err
+	}
+	body, err := g.expr(n)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(g.types))
+	for name := range g.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by be2fn gen. DO NOT EDIT.\n\npackage %s\n\n", cfg.Package)
+	if g.useTime {
+		buf.WriteString("import \"time\"\n\n")
+	}
+	fmt.Fprintf(&buf, "// %s 是 %s 的输入\ntype %s struct {\n", cfg.TypeName, cfg.FuncName, cfg.TypeName)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s %s // %s\n", g.fields[name], goTypes[g.types[name]], name)
+	}
+	buf.WriteString("}\n\n")
+	for _, line := range strings.Split(strings.TrimSpace(src), "\n") {
+		fmt.Fprintf(&buf, "// %s\n", strings.TrimSpace(line))
+	}
+	fmt.Fprintf(&buf, "func %s(in *%s) bool {\nreturn %s\n}\n", cfg.FuncName, cfg.TypeName, body)
+
+	return format.Source(buf.Bytes())
+}
+
+// 收集变量的类型并生成字段名，Schema 中指定的类型需要和比较的常量一致
+func (g *generator) collect(n *Node) error {
+	if !n.IsLeaf() {
+		for _, child := range n.Children {
+			if err := g.collect(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if isInfixOp(n.Typ) {
+		return fmt.Errorf("unsupported operator `%s`", n.Typ)
+	}
+
+	name, typ := n.Args[0].Val, n.Args[1].Typ
+	switch typ {
+	case INT_SLICE:
+		typ = INT
+	case STR_SLICE:
+		typ = STRING
+	}
+	if typ == TIME || typ == DURATION {
+		g.useTime = true
+	}
+
+	if old, ok := g.types[name]; ok {
+		if old != typ {
+			return fmt.Errorf("variable `%s` is used as both %s and %s", name, goTypes[old], goTypes[typ])
+		}
+		return nil
+	}
+	if want, ok := g.cfg.Schema[name]; ok && want != goTypes[typ] {
+		return fmt.Errorf("variable `%s` is %s in schema but compared with %s", name, want, goTypes[typ])
+	}
+
+	field := fieldName(name)
+	for other, f := range g.fields {
+		if f == field {
+			return fmt.Errorf("variables `%s` and `%s` have the same field name %s", other, name, field)
+		}
+	}
+	g.types[name], g.fields[name] = typ, field
+	return nil
+}
+
+// 变量名转换成导出的字段名，user.first_name 转换成 UserFirstName
+func fieldName(name string) string {
+	var sb strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '.' || r == '_' }) {
+		r := []rune(part)
+		r[0] = unicode.ToUpper(r[0])
+		sb.WriteString(string(r))
+	}
+	return sb.String()
+}
+
+// 生成表达式
+func (g *generator) expr(n *Node) (string, error) {
+	switch n.Typ {
+	case CONST:
+		return strconv.FormatBool(n.BoolVal), nil
+
+	case NOT:
+		child, err := g.expr(n.Children[0])
+		if err != nil {
+			return "", err
+		}
+		if n.Children[0].Typ == NOT || n.Children[0].Typ == CONST {
+			return "!" + child, nil
+		}
+		return "!(" + child + ")", nil
+
+	case LAND, LOR:
+		subs := make([]string, 0, len(n.Children))
+		for _, child := range n.Children {
+			sub, err := g.expr(child)
+			if err != nil {
+				return "", err
+			}
+			if (child.Typ == LAND || child.Typ == LOR) && child.Typ != n.Typ {
+				sub = "(" + sub + ")"
+			}
+			subs = append(subs, sub)
+		}
+		return strings.Join(subs, " "+n.Typ.String()+" "), nil
+
+	case FUNC:
+		return g.in(n)
+
+	default:
+		return g.compare(n)
+	}
+}
+
+// 生成 in 函数调用，展开成多个 == 用 || 连接
+func (g *generator) in(n *Node) (string, error) {
+	if n.Val != "in" {
+		return "", fmt.Errorf("unsupported func `%s`", n.Val)
+	}
+
+	field := "in." + g.fields[n.Args[0].Val]
+	var elems []string
+	for _, v := range n.Args[1].IntSliceVal {
+		elems = append(elems, field+" == "+strconv.Itoa(v))
+	}
+	for _, v := range n.Args[1].StrSliceVal {
+		elems = append(elems, field+" == "+strconv.Quote(v))
+	}
+
+	switch len(elems) {
+	case 0:
+		return "false", nil
+	case 1:
+		return elems[0], nil
+	default:
+		return "(" + strings.Join(elems, " || ") + ")", nil
+	}
+}
+
+// 生成变量和常量的比较，变量总在左边
+func (g *generator) compare(n *Node) (string, error) {
+	field, c := "in."+g.fields[n.Args[0].Val], n.Args[1]
+
+	switch c.Typ {
+	case INT:
+		return field + " " + n.Typ.String() + " " + strconv.Itoa(c.IntVal), nil
+
+	case STRING: // Lexer 不处理转义，所以按原样生成字符串常量
+		return field + " " + n.Typ.String() + " " + strconv.Quote(c.Val), nil
+
+	case BOOLEAN:
+		if n.Typ != EQL && n.Typ != NEQ {
+			return "", errBooleanSize
+		}
+		return field + " " + n.Typ.String() + " " + strconv.FormatBool(c.BoolVal), nil
+
+	case DURATION:
+		return field + " " + n.Typ.String() + " " + durationSource(c.DurationVal), nil
+
+	case TIME:
+		val := timeSource(c)
+		switch n.Typ {
+		case EQL:
+			return field + ".Equal(" + val + ")", nil
+		case NEQ:
+			return "!" + field + ".Equal(" + val + ")", nil
+		case LSS:
+			return field + ".Before(" + val + ")", nil
+		case LEQ:
+			return "!" + field + ".After(" + val + ")", nil
+		case GTR:
+			return field + ".After(" + val + ")", nil
+		default:
+			return "!" + field + ".Before(" + val + ")", nil
+		}
+
+	default:
+		return "", fmt.Errorf("unsupported operand `%s`", ParamSource(c))
+	}
+}
+
+// 生成时长常量，尽量使用 time.Hour 等单位
+func durationSource(d time.Duration) string {
+	units := []struct {
+		d    time.Duration
+		name string
+	}{
+		{time.Hour, "time.Hour"},
+		{time.Minute, "time.Minute"},
+		{time.Second, "time.Second"},
+		{time.Millisecond, "time.Millisecond"},
+		{time.Microsecond, "time.Microsecond"},
+	}
+	for _, u := range units {
+		if d%u.d == 0 {
+			return "(" + strconv.FormatInt(int64(d/u.d), 10) + " * " + u.name + ")"
+		}
+	}
+	return "time.Duration(" + strconv.FormatInt(int64(d), 10) + ")"
+}
+
+// 生成时间常量，now() 生成 time.Now()
+func timeSource(p *Param) string {
+	if !p.IsNow {
+		t := p.TimeVal.UTC()
+		return fmt.Sprintf("time.Date(%d, %d, %d, %d, %d, %d, %d, time.UTC)",
+			t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond())
+	}
+	if p.DurationVal == 0 {
+		return "time.Now()"
+	}
+	return "time.Now().Add(" + durationSource(p.DurationVal) + ")"
+}
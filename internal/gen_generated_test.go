@@ -0,0 +1,25 @@
+// Code generated by be2fn gen. DO NOT EDIT.
+
+package internal
+
+import "time"
+
+// genInput 是 genMatch 的输入
+type genInput struct {
+	Age         int           // age
+	Level       int           // level
+	Region      string        // region
+	Score       int           // score
+	Tag         string        // tag
+	Ttl         time.Duration // ttl
+	UserCreated time.Time     // user.created
+	Vip         bool          // vip
+}
+
+// (age >= 18 && age < 65 && !(region == "CN")) ||
+// in(level, []int{1, 2, 3}) && vip == true ||
+// user.created < time("2024-01-02T03:04:05+08:00") && ttl >= duration("1h30m") ||
+// in(tag, []string{"a", "b\"c"}) && -5 < score
+func genMatch(in *genInput) bool {
+	return (in.Age >= 18 && in.Age < 65 && !(in.Region == "CN")) || ((in.Level == 1 || in.Level == 2 || in.Level == 3) && in.Vip == true) || (in.UserCreated.Before(time.Date(2024, 1, 1, 19, 4, 5, 0, time.UTC)) && in.Ttl >= (90*time.Minute)) || ((in.Tag == "a" || in.Tag == "b\\\"c") && in.Score > -5)
+}
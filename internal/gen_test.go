@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGen = flag.Bool("update", false, "regenerate gen_generated_test.go")
+
+// gen_generated_test.go 由这个表达式生成
+const genTestExpr = `(age >= 18 && age < 65 && !(region == "CN")) ||
+	in(level, []int{1, 2, 3}) && vip == true ||
+	user.created < time("2024-01-02T03:04:05+08:00") && ttl >= duration("1h30m") ||
+	in(tag, []string{"a", "b\"c"}) && -5 < score`
+
+func genTestSource(t *testing.T) []byte {
+	src, err := Generate(genTestExpr, mustTree(t, genTestExpr), GenConfig{
+		Package:  "internal",
+		FuncName: "genMatch",
+		TypeName: "genInput",
+		Schema:   map[string]string{"age": "int", "user.created": "time.Time"},
+	})
+	if err != nil {
+		t.Fatal("failed to call Generate, err:", err)
+	}
+	return src
+}
+
+func TestGenerateUpToDate(t *testing.T) {
+	src := genTestSource(t)
+	if *updateGen {
+		if err := os.WriteFile("gen_generated_test.go", src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old, err := os.ReadFile("gen_generated_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(old, src) {
+		t.Fatalf("gen_generated_test.go is out of date, run `go test -run TestGenerateUpToDate -update`, got:\n%s", src)
+	}
+}
+
+func TestGenerateEquivalence(t *testing.T) {
+	fn, err := NewCompiler(mustLexer(t, genTestExpr)).Compile()
+	if err != nil {
+		t.Fatal("faild to call Compile, err:", err)
+	}
+
+	created := time.Date(2024, 1, 1, 19, 4, 5, 0, time.UTC) // 2024-01-02T03:04:05+08:00
+	inputs := []genInput{
+		{Age: 20, Region: "US"},
+		{Age: 20, Region: "CN"},
+		{Age: 70, Level: 2, Vip: true},
+		{Age: 70, Level: 4, Vip: true},
+		{UserCreated: created.Add(-time.Second), Ttl: 2 * time.Hour},
+		{UserCreated: created, Ttl: 2 * time.Hour},
+		{UserCreated: created.Add(-time.Second), Ttl: time.Hour},
+		{Tag: `b\"c`, Score: -4},
+		{Tag: `b\"c`, Score: -5},
+		{Tag: "c", Score: 10},
+	}
+
+	for _, in := range inputs {
+		vars := Kv{
+			"age": in.Age, "region": in.Region, "level": in.Level, "vip": in.Vip,
+			"user.created": in.UserCreated, "ttl": in.Ttl, "tag": in.Tag, "score": in.Score,
+		}
+		ret, err := fn.Eval(vars)
+		if err != nil {
+			t.Fatalf("faild to eval %+v, err: %v", in, err)
+		}
+		if genMatch(&in) != ret {
+			t.Fatalf("generated func returns %v for %+v, expect %v", !ret, in, ret)
+		}
+	}
+}
+
+func TestGenerateError(t *testing.T) {
+	cases := []struct {
+		Expr   string
+		Schema map[string]string
+		Err    string
+	}{
+		{"a == 1 || a == \"x\"", nil, "used as both int and string"},
+		{"a == 1", map[string]string{"a": "string"}, "string in schema"},
+		{"a.b == 1 || a_b == 2", nil, "same field name AB"},
+		{"a > true", nil, "boolean values cannot compare"},
+	}
+
+	for _, c := range cases {
+		_, err := Generate(c.Expr, mustTree(t, c.Expr), GenConfig{Schema: c.Schema})
+		if err == nil || !strings.Contains(err.Error(), c.Err) {
+			t.Fatalf("%q should fail with %q, got %v", c.Expr, c.Err, err)
+		}
+	}
+}
+
+// 解析表达式
+func mustLexer(t *testing.T, expr string) *Lexer {
+	lex := NewLexer(expr)
+	if err := lex.Parse(); err != nil {
+		t.Fatalf("faild to parse %q, err: %v", expr, err)
+	}
+	return lex
+}
@@ -0,0 +1,97 @@
+package internal
+
+// 展开成析取范式时最多保留的合取项个数，超过时放弃推导
+const maxConjunctions = 256
+
+// 叶子节点或它的否定
+type literal struct {
+	n   *Node
+	neg bool
+}
+
+// 判断 x 成立时 y 是否一定成立，即 x && !y 是否无解，
+// 推导基于同一个变量上的区间和等值关系，无法确定时返回 false
+func Implies(x, y *Node) bool {
+	conjs, ok := dnf(&Node{Typ: LAND, Children: []*Node{x, {Typ: NOT, Children: []*Node{y}}}}, false)
+	if !ok {
+		return false
+	}
+	for _, conj := range conjs {
+		if satisfiable(conj) {
+			return false
+		}
+	}
+	return true
+}
+
+// 把 n（neg 为 true 时为 !n）展开成析取范式，返回所有合取项，合取项个数超过上限时返回 false
+func dnf(n *Node, neg bool) ([][]literal, bool) {
+	switch {
+	case n.Typ == CONST:
+		if n.BoolVal != neg {
+			return [][]literal{nil}, true
+		}
+		return nil, true
+
+	case n.Typ == NOT:
+		return dnf(n.Children[0], !neg)
+
+	case n.Typ == LAND && !neg, n.Typ == LOR && neg: // 合取，按乘法展开
+		conjs := [][]literal{nil}
+		for _, child := range n.Children {
+			sub, ok := dnf(child, neg)
+			if !ok || len(conjs)*len(sub) > maxConjunctions {
+				return nil, false
+			}
+			product := make([][]literal, 0, len(conjs)*len(sub))
+			for _, c := range conjs {
+				for _, s := range sub {
+					conj := make([]literal, 0, len(c)+len(s))
+					product = append(product, append(append(conj, c...), s...))
+				}
+			}
+			conjs = product
+		}
+		return conjs, true
+
+	case n.Typ == LAND || n.Typ == LOR: // 析取，直接合并
+		var conjs [][]literal
+		for _, child := range n.Children {
+			sub, ok := dnf(child, neg)
+			if !ok || len(conjs)+len(sub) > maxConjunctions {
+				return nil, false
+			}
+			conjs = append(conjs, sub...)
+		}
+		return conjs, true
+
+	default:
+		return [][]literal{{{n: n, neg: neg}}}, true
+	}
+}
+
+// 判断合取项是否可能成立，无法转换成约束的叶子节点只检查是否同时出现了它和它的否定
+func satisfiable(conj []literal) bool {
+	opaque := map[string]bool{}
+	groups := map[string][]atom{}
+	for _, l := range conj {
+		if a, ok := atomOf(l.n, l.neg); ok {
+			key := a.ident + "/" + a.typ.String()
+			groups[key] = append(groups[key], a)
+			continue
+		}
+
+		key := l.n.String()
+		if seen, ok := opaque[key]; ok && seen != l.neg {
+			return false
+		}
+		opaque[key] = l.neg
+	}
+
+	for _, group := range groups {
+		if !feasible(group) {
+			return false
+		}
+	}
+	return true
+}
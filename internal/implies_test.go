@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestImplies(t *testing.T) {
+	cases := []struct {
+		X, Y string
+		Ret  bool
+	}{
+		{"a > 10", "a > 5", true},
+		{"a > 5", "a > 10", false},
+		{`country == "US" && plan == "pro"`, `country == "US"`, true},
+		{`country == "US"`, `in(country, []string{"US", "CA"})`, true},
+		{`in(country, []string{"US", "CA"})`, `country == "US"`, false},
+		{"a >= 1 && a <= 3", "a != 0 && a < 4", true},
+		{"a == 1 || a == 2", "in(a, []int{1, 2, 3})", true},
+		{"a == 1 || b == 2", "a == 1", false},
+		{"a > 10 && b == true", "a > 5 || c == 1", true},
+		{"flag == true", "flag != false", true},
+		{`a == 1`, `a == "1"`, false},
+		{"a > 10 && a < 5", "b == 1", true}, // x 永远不成立
+		{"b == 1", "a > 0 || a <= 0", true}, // y 永远成立
+
+		// 无法转换成约束的叶子节点只按字面比较
+		{`t > time("2024-01-01T00:00:00Z") && a == 1`, `t > time("2024-01-01T00:00:00Z")`, true},
+		{`t > time("2024-01-01T00:00:00Z")`, `t > time("2023-01-01T00:00:00Z")`, false},
+		{`!(s < "m") && a == 1`, `!(s < "m")`, true},
+	}
+
+	for i, c := range cases {
+		if ret := Implies(mustTree(t, c.X), mustTree(t, c.Y)); ret != c.Ret {
+			t.Fatalf("case %d: `%s` implies `%s` should be %v, got %v", i, c.X, c.Y, c.Ret, ret)
+		}
+	}
+
+	// 展开后合取项太多时放弃推导
+	var clauses []string
+	for i := 0; i < 10; i++ {
+		clauses = append(clauses, fmt.Sprintf("(a%d == 1 || b%d == 1)", i, i))
+	}
+	x := mustTree(t, strings.Join(clauses, " && "))
+	if Implies(x, x) {
+		t.Fatal("should give up when there are too many conjunctions")
+	}
+}
@@ -0,0 +1,139 @@
+package internal
+
+import "sort"
+
+// RuleSet 的索引，每条规则最多选出一个顶层的 `变量 == 常量` 或 `in(变量, 切片)` 条件建立索引，
+// 求值时根据变量的值找到条件可能成立的规则，其他规则的这个条件一定不成立，不需要执行
+type ruleIndex struct {
+	vars []*varIndex // 按建立索引的变量和类型分组
+	rest []int       // 没有可以建立索引的条件的规则，每次都要执行
+}
+
+// 同一个变量、同一种类型上的索引
+type varIndex struct {
+	varKey
+	buckets map[interface{}][]int // 变量的值 -> 条件成立的规则
+	all     []int                 // 这个变量上建立了索引的所有规则，变量不存在或类型不对时全部执行，以便返回和不使用索引时相同的错误
+}
+
+// 建立索引的变量和类型
+type varKey struct {
+	key string
+	typ Token // INT、STRING 或 BOOLEAN
+}
+
+// 一条规则上可以建立索引的条件
+type indexCond struct {
+	varKey
+	vals []interface{} // 条件成立时变量可能的值
+}
+
+// 根据每条规则的表达式树建立索引，trees[i] 为 nil 表示无法还原或使用了自定义的运算符，这样的规则每次都要执行
+func buildRuleIndex(trees []*Node) *ruleIndex {
+	idx := &ruleIndex{}
+	byVar := map[varKey]*varIndex{}
+	for i, root := range trees {
+		cond, ok := indexCondOf(root)
+		if !ok {
+			idx.rest = append(idx.rest, i)
+			continue
+		}
+
+		vi := byVar[cond.varKey]
+		if vi == nil {
+			vi = &varIndex{varKey: cond.varKey, buckets: map[interface{}][]int{}}
+			byVar[cond.varKey] = vi
+			idx.vars = append(idx.vars, vi)
+		}
+		vi.all = append(vi.all, i)
+		for _, val := range cond.vals {
+			bucket := vi.buckets[val]
+			if len(bucket) == 0 || bucket[len(bucket)-1] != i { // in 的切片中可能有重复的值
+				vi.buckets[val] = append(bucket, i)
+			}
+		}
+	}
+	return idx
+}
+
+// 从顶层的 && 中选出一个可以建立索引的条件，可能的值越少越好
+func indexCondOf(root *Node) (indexCond, bool) {
+	var best indexCond
+	found := false
+
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		if n.Typ == LAND {
+			for _, child := range n.Children {
+				visit(child)
+			}
+			return
+		}
+		if cond, ok := leafIndexCond(n); ok && (!found || len(cond.vals) < len(best.vals)) {
+			best, found = cond, true
+		}
+	}
+	if root != nil {
+		visit(root)
+	}
+	return best, found
+}
+
+// 叶子节点是 `变量 == 常量` 或 `in(变量, 切片)` 时返回对应的条件
+func leafIndexCond(n *Node) (indexCond, bool) {
+	if !n.IsLeaf() || n.Args[0].Typ != IDENT {
+		return indexCond{}, false
+	}
+	x, y := n.Args[0], n.Args[1]
+
+	switch {
+	case n.Typ == EQL && y.Typ == INT:
+		return indexCond{varKey: varKey{x.Val, INT}, vals: []interface{}{y.IntVal}}, true
+	case n.Typ == EQL && y.Typ == STRING:
+		return indexCond{varKey: varKey{x.Val, STRING}, vals: []interface{}{y.Val}}, true
+	case n.Typ == EQL && y.Typ == BOOLEAN:
+		return indexCond{varKey: varKey{x.Val, BOOLEAN}, vals: []interface{}{y.BoolVal}}, true
+
+	case n.Typ == FUNC && n.Val == "in" && y.Typ == INT_SLICE:
+		vals := make([]interface{}, 0, len(y.IntSliceVal))
+		for _, v := range y.IntSliceVal {
+			vals = append(vals, v)
+		}
+		return indexCond{varKey: varKey{x.Val, INT}, vals: vals}, true
+	case n.Typ == FUNC && n.Val == "in" && y.Typ == STR_SLICE:
+		vals := make([]interface{}, 0, len(y.StrSliceVal))
+		for _, v := range y.StrSliceVal {
+			vals = append(vals, v)
+		}
+		return indexCond{varKey: varKey{x.Val, STRING}, vals: vals}, true
+	}
+	return indexCond{}, false
+}
+
+// 返回需要执行的规则，按编译时的顺序排列
+func (idx *ruleIndex) candidates(vars Kv) []int {
+	ids := append([]int(nil), idx.rest...)
+	for _, vi := range idx.vars {
+		val, ok := vars[vi.key]
+		switch {
+		case !ok || !vi.matchType(val): // 和 Kv.GetXXX 一样只接受对应的类型，否则这些规则会出错
+			ids = append(ids, vi.all...)
+		default:
+			ids = append(ids, vi.buckets[val]...)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (vi *varIndex) matchType(val interface{}) bool {
+	switch val.(type) {
+	case int:
+		return vi.typ == INT
+	case string:
+		return vi.typ == STRING
+	case bool:
+		return vi.typ == BOOLEAN
+	}
+	return false
+}
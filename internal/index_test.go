@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestRuleIndex(t *testing.T) {
+	rules := []string{
+		"us_pro", `country == "US" && plan == "pro"`,
+		"na", `in(country, []string{"US", "CA", "US"}) && age > 18`,
+		"level", `level == 3 || level == 4`, // 顶层不是 &&，不建立索引
+		"vip", `vip == true && level >= 2`,
+		"lv", `in(level, []int{1, 2}) && country != "CN"`,
+		"adult", `age >= 18`,
+	}
+	linear := mustRuleSet(t, false, rules...)
+	indexed := mustRuleSet(t, true, rules...)
+
+	idx := indexed.index
+	if len(idx.rest) != 2 || len(idx.vars) != 3 {
+		t.Fatalf("unexpected index: rest %v, vars %d", idx.rest, len(idx.vars))
+	}
+
+	cases := []struct {
+		arg Kv
+		ret []string
+	}{
+		{arg: Kv{"country": "US", "plan": "pro", "age": 20, "level": 1, "vip": true}, ret: []string{"us_pro", "na", "lv", "adult"}},
+		{arg: Kv{"country": "CA", "plan": "pro", "age": 20, "level": 3, "vip": true}, ret: []string{"na", "level", "vip", "adult"}},
+		{arg: Kv{"country": "CN", "plan": "free", "age": 10, "level": 2, "vip": false}, ret: nil},
+	}
+	for _, c := range cases {
+		want, err := linear.Eval(c.arg)
+		if err != nil || !reflect.DeepEqual(want, c.ret) {
+			t.Fatalf("arg: %v, should be %v, got %v, %v", c.arg, c.ret, want, err)
+		}
+		if ret, err := indexed.Eval(c.arg); err != nil || !reflect.DeepEqual(ret, want) {
+			t.Fatalf("arg: %v, indexed should be %v, got %v, %v", c.arg, want, ret, err)
+		}
+	}
+
+	// 建立索引的变量不存在或类型不对时，和不使用索引时返回相同的错误
+	for _, arg := range []Kv{
+		{"plan": "pro", "age": 20, "level": 1, "vip": true},
+		{"country": 1, "plan": "pro", "age": 20, "level": 1, "vip": true},
+	} {
+		want, wantErr := linear.Eval(arg)
+		ret, err := indexed.Eval(arg)
+		if !reflect.DeepEqual(ret, want) || err == nil || err.Error() != wantErr.Error() {
+			t.Fatalf("arg: %v, should be %v, %v, got %v, %v", arg, want, wantErr, ret, err)
+		}
+	}
+
+	// 被跳过的规则中其他条件出的错不会返回
+	if _, err := linear.Eval(Kv{"country": "CN", "age": 20, "level": 3, "vip": true}); err == nil {
+		t.Fatal("should return error of missing plan")
+	}
+	ret, err := indexed.Eval(Kv{"country": "CN", "age": 20, "level": 3, "vip": true})
+	var errs RuleErrors
+	if errors.As(err, &errs) || !reflect.DeepEqual(ret, []string{"level", "vip", "adult"}) {
+		t.Fatalf("should skip us_pro, got %v, %v", ret, err)
+	}
+}
+
+// 随机生成由等值和 in 条件组成的规则
+func genIndexRules(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	rules := make([]string, 0, 2*n)
+	for i := 0; i < n; i++ {
+		var expr string
+		switch i % 3 {
+		case 0:
+			expr = fmt.Sprintf(`country == "C%d" && plan == "P%d"`, r.Intn(200), r.Intn(5))
+		case 1:
+			expr = fmt.Sprintf(`in(country, []string{"C%d", "C%d"}) && age > %d`, r.Intn(200), r.Intn(200), r.Intn(60))
+		default:
+			expr = fmt.Sprintf(`segment == %d && plan != "P%d"`, r.Intn(1000), r.Intn(5))
+		}
+		rules = append(rules, fmt.Sprintf("r%d", i), expr)
+	}
+	return rules
+}
+
+func TestRuleIndexRandom(t *testing.T) {
+	rules := genIndexRules(3000)
+	linear := mustRuleSet(t, false, rules...)
+	indexed := mustRuleSet(t, true, rules...)
+
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		arg := Kv{
+			"country": fmt.Sprintf("C%d", r.Intn(200)),
+			"plan":    fmt.Sprintf("P%d", r.Intn(5)),
+			"age":     r.Intn(80),
+			"segment": r.Intn(1000),
+		}
+		want, err := linear.Eval(arg)
+		if err != nil {
+			t.Fatal("failed to call Eval, err:", err)
+		}
+		if ret, err := indexed.Eval(arg); err != nil || !reflect.DeepEqual(ret, want) {
+			t.Fatalf("arg: %v, indexed should be %v, got %v, %v", arg, want, ret, err)
+		}
+	}
+}
+
+func benchmarkRuleSet(b *testing.B, n int, index bool) {
+	rules := genIndexRules(n)
+	var names []string
+	var compilers []*Compiler
+	for i := 0; i < len(rules); i += 2 {
+		lex := NewLexer(rules[i+1])
+		if err := lex.Parse(); err != nil {
+			b.Fatal("faild to call Parse, err:", err)
+		}
+		names = append(names, rules[i])
+		compilers = append(compilers, NewCompiler(lex))
+	}
+	rs, err := CompileRuleSet(names, compilers, index)
+	if err != nil {
+		b.Fatal("failed to call CompileRuleSet, err:", err)
+	}
+
+	arg := Kv{"country": "C7", "plan": "P1", "age": 30, "segment": 42}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := rs.Eval(arg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRuleSet10k(b *testing.B)         { benchmarkRuleSet(b, 10000, false) }
+func BenchmarkRuleSet10kIndexed(b *testing.B)  { benchmarkRuleSet(b, 10000, true) }
+func BenchmarkRuleSet100k(b *testing.B)        { benchmarkRuleSet(b, 100000, false) }
+func BenchmarkRuleSet100kIndexed(b *testing.B) { benchmarkRuleSet(b, 100000, true) }
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// 注册过的具名中缀运算符，和 defaultOperatorSet 一样由 operatorMu 保护，infixNames[i] 对应 token INFIX+i
+var (
+	infixNames  []string
+	infixTokens = map[string]Token{}
+)
+
+// 判断 t 是否是具名中缀运算符
+func isInfixOp(t Token) bool {
+	return t >= INFIX
+}
+
+// 具名中缀运算符的名字
+func infixName(t Token) string {
+	operatorMu.RLock()
+	defer operatorMu.RUnlock()
+	if i := int(t - INFIX); i < len(infixNames) {
+		return infixNames[i]
+	}
+	return "<invalid>"
+}
+
+// 根据名字获取具名中缀运算符对应的 token
+func infixToken(name string) (Token, bool) {
+	operatorMu.RLock()
+	defer operatorMu.RUnlock()
+	t, ok := infixTokens[name]
+	return t, ok
+}
+
+// 注册具名中缀运算符，之后的表达式中可以使用 `a matches "x"` 这样的写法，优先级和 == 等比较运算符相同，
+// 变量和常量可以在任意一边，分别使用 funcs 中的 VarToXxx 和 XxxToVar；funcs 中为 nil 的函数表示不支持和对应类型的常量比较，
+// 编译到这样的比较时返回错误。name 需要是至少两个字符的标识符，不能是关键字、布尔值或内置函数名；
+// 已经注册过时替换它的实现，返回的 token 不变。可以和 Compile 并发调用，只影响之后编译的表达式
+func RegisterInfixOperator(name string, funcs OperatorFuncs) (Token, error) {
+	switch {
+	case len(name) < 2 || !token.IsIdentifier(name):
+		return INVALID, fmt.Errorf("invalid infix operator name %q", name)
+	case name == "true" || name == "false" || name == "in" || name == "time" || name == "duration" || name == "now":
+		return INVALID, fmt.Errorf("infix operator name %q is reserved", name)
+	}
+
+	operatorMu.Lock()
+	defer operatorMu.Unlock()
+	t, ok := infixTokens[name]
+	if !ok {
+		t = INFIX + Token(len(infixNames))
+		infixNames = append(infixNames, name)
+		infixTokens[name] = t
+	}
+	defaultOperatorSet[t] = funcs
+	operatorGen++
+	return t, nil
+}
+
+// 把 src 中作为中缀运算符出现（前面是一个操作数）的具名中缀运算符替换成同样长度的 `==`，不足的部分用空格补齐，
+// 使 go/parser 可以按比较运算符的优先级解析，返回替换后的表达式以及每个被替换的位置对应的 token；
+// 替换不改变 token 的位置，所以报错时的位置和原表达式一致
+func translateInfix(src string) (string, map[token.Pos]Token) {
+	operatorMu.RLock()
+	registered := len(infixNames)
+	operatorMu.RUnlock()
+	if registered == 0 {
+		return src, nil
+	}
+
+	var s scanner.Scanner
+	fset := token.NewFileSet() // 和 parser.ParseExpr 一样从 base 开始计算位置
+	file := fset.AddFile("", fset.Base(), len(src))
+	s.Init(file, []byte(src), nil, 0)
+
+	var buf []byte
+	var ops map[token.Pos]Token
+	prev := token.ILLEGAL
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.SEMICOLON && lit == "\n" { // 换行处自动插入的分号，不影响前一个 token 是不是操作数
+			continue
+		}
+		if tok == token.IDENT && isOperandEnd(prev) {
+			if t, ok := infixToken(lit); ok {
+				if buf == nil {
+					buf, ops = []byte(src), map[token.Pos]Token{}
+				}
+				copy(buf[file.Offset(pos):], "=="+strings.Repeat(" ", len(lit)-2))
+				ops[pos] = t
+			}
+		}
+		prev = tok
+	}
+
+	if buf == nil {
+		return src, nil
+	}
+	return string(buf), ops
+}
+
+// 判断 tok 是否可以是一个操作数的最后一个 token
+func isOperandEnd(tok token.Token) bool {
+	switch tok {
+	case token.IDENT, token.INT, token.STRING, token.RPAREN, token.RBRACK, token.RBRACE:
+		return true
+	}
+	return false
+}
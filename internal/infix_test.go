@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// 注册测试用的具名中缀运算符：matches 做正则匹配，只支持字符串，变量和常量可以在任意一边
+func registerMatches(t *testing.T) Token {
+	t.Helper()
+	match := func(varname string, pattern string) Unit {
+		re, err := regexp.Compile(pattern)
+		return func(env *Env) (bool, error) {
+			if err != nil {
+				return false, err
+			}
+			s, err := env.GetString(varname)
+			if err != nil {
+				return false, err
+			}
+			return re.MatchString(s), nil
+		}
+	}
+	tok, err := RegisterInfixOperator("matches", OperatorFuncs{
+		VarToStr: match,
+		StrToVar: func(pattern string, varname string) Unit { return match(varname, pattern) },
+	})
+	if err != nil {
+		t.Fatal("failed to call RegisterInfixOperator, err:", err)
+	}
+	return tok
+}
+
+func TestTranslateInfix(t *testing.T) {
+	registerMatches(t)
+
+	cases := []struct {
+		Expr string
+		Ret  string
+	}{
+		{`a matches "x"`, `a ==      "x"`},
+		{`a.b matches "x" && !(c matches "y")`, `a.b ==      "x" && !(c ==      "y")`},
+		{`"x" matches a`, `"x" ==      a`},
+		{`matches == "x"`, `matches == "x"`},           // 作为变量名
+		{`a.matches == "x"`, `a.matches == "x"`},       // 作为字段名
+		{`matches matches "x"`, `matches ==      "x"`}, // 只替换操作数后面的
+		{`a == "matches"`, `a == "matches"`},
+		{"a matches\n\"x\"", "a ==     \n\"x\""}, // 跨行
+		{"a == 1 &&\nb matches \"x\"", "a == 1 &&\nb ==      \"x\""},
+		{"a\nmatches \"x\"", "a\n==      \"x\""}, // 换行处自动插入的分号不影响判断
+	}
+	for _, c := range cases {
+		if ret, _ := translateInfix(c.Expr); ret != c.Ret {
+			t.Fatalf("%q should be %q, got %q", c.Expr, c.Ret, ret)
+		}
+	}
+}
+
+func TestInfixOperator(t *testing.T) {
+	tok := registerMatches(t)
+	if tok.String() != "matches" || !isInfixOp(tok) {
+		t.Fatalf("unexpected token %v", tok)
+	}
+	if got, ok := OperatorToken("matches"); !ok || got != tok {
+		t.Fatalf("should find token of matches, got %v, %v", got, ok)
+	}
+
+	cases := []struct {
+		Expr string
+		Vars Kv
+		Ret  bool
+	}{
+		{`name matches "^ab"`, Kv{"name": "abc"}, true},
+		{`name matches "^ab"`, Kv{"name": "cab"}, false},
+		{`"^ab" matches name`, Kv{"name": "abc"}, true},
+		{`!(name matches "^ab") || a > 1`, Kv{"name": "abc", "a": 2}, true},
+		{`a > 1 && user.name matches "c$"`, Kv{"user.name": "abc", "a": 2}, true},
+		{`matches == "x" || matches matches "y"`, Kv{"matches": "y"}, true},
+		{"a > 1 &&\n\tname matches\n\t\"^ab\"", Kv{"name": "abc", "a": 2}, true},
+	}
+	for _, c := range cases {
+		fn, p := compileBoth(t, c.Expr)
+		if ret, err := fn.Eval(c.Vars); err != nil || ret != c.Ret {
+			t.Fatalf("%q should be %v, got %v, %v", c.Expr, c.Ret, ret, err)
+		}
+		if ret, err := p.Unit().Eval(c.Vars); err != nil || ret != c.Ret {
+			t.Fatalf("program: %q should be %v, got %v, %v", c.Expr, c.Ret, ret, err)
+		}
+	}
+
+	// 还原出的表达式树保持操作数的顺序，重新生成的写法可以再次解析
+	lex := NewLexer(`!("^ab" matches name) && a > 1`)
+	if err := lex.Parse(); err != nil {
+		t.Fatal("faild to call Parse, err:", err)
+	}
+	tree, err := BuildTree(lex.Params)
+	if err != nil {
+		t.Fatal("faild to call BuildTree, err:", err)
+	}
+	if s := tree.String(); s != `!("^ab" matches name) && a > 1` {
+		t.Fatalf("unexpected tree: %s", s)
+	}
+	if BuiltinOperators(lex.Params, nil) {
+		t.Fatal("matches is not a builtin operator")
+	}
+}
+
+func TestInfixOperatorError(t *testing.T) {
+	registerMatches(t)
+
+	cases := []struct {
+		Expr string
+		Err  string
+	}{
+		{`a matches 1`, "`matches` does not support int operand `1`"},
+		{`a matches b`, "both subExpr of `matches` is Ident"},
+		{`"a" matches "b"`, "both subExpr of `matches` is const"},
+		{`a matches`, "expected operand"},
+	}
+	for _, c := range cases {
+		lex := NewLexer(c.Expr)
+		err := lex.Parse()
+		if err == nil {
+			_, err = NewCompiler(lex).Compile()
+		}
+		if err == nil || !strings.Contains(err.Error(), c.Err) {
+			t.Fatalf("%q should return error %q, got %v", c.Expr, c.Err, err)
+		}
+	}
+
+	// 求值时的错误
+	fn, _ := compileBoth(t, `a matches "("`)
+	if _, err := fn.Eval(Kv{"a": "x"}); err == nil {
+		t.Fatal("invalid pattern should return error")
+	}
+
+	for _, name := range []string{"m", "in", "true", "func", "a.b", "1a"} {
+		if _, err := RegisterInfixOperator(name, OperatorFuncs{}); err == nil {
+			t.Fatalf("%q should not be registered", name)
+		}
+	}
+}
+
+func TestInfixOperatorOverride(t *testing.T) {
+	tok := registerMatches(t)
+
+	// Compiler 中的实现优先，没有提供的函数使用注册时的实现
+	lex := NewLexer(`a matches "X" && b matches "y"`)
+	if err := lex.Parse(); err != nil {
+		t.Fatal("faild to call Parse, err:", err)
+	}
+	c := NewCompiler(lex)
+	c.Operators = OperatorSet{tok: {VarToStr: foldEqual}}
+	fn, err := c.Compile()
+	if err != nil {
+		t.Fatal("faild to call Compile, err:", err)
+	}
+	if ret, err := fn.Eval(Kv{"a": "x", "b": "y"}); !ret || err != nil {
+		t.Fatalf("should be true, got %v, %v", ret, err)
+	}
+
+	// 重复注册时替换实现，token 不变
+	origin, _ := LookupOperator(tok)
+	defer func() {
+		if _, err := RegisterInfixOperator("matches", origin); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	again, err := RegisterInfixOperator("matches", OperatorFuncs{VarToStr: foldEqual})
+	if err != nil || again != tok {
+		t.Fatalf("should keep token %v, got %v, %v", tok, again, err)
+	}
+	fn, _ = compileBoth(t, `a matches "X"`)
+	if ret, err := fn.Eval(Kv{"a": "x"}); !ret || err != nil {
+		t.Fatalf("should use new implementation, got %v, %v", ret, err)
+	}
+}
@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"math"
+	"strconv"
+)
+
+// 整数区间，用于推导同一个变量上多个比较之间的关系
+type intRange struct {
+	lo, hi       int
+	hasLo, hasHi bool // 为 false 时表示对应方向没有边界
+}
+
+// 空区间，任何值都不满足
+var emptyRange = intRange{lo: 1, hi: 0, hasLo: true, hasHi: true}
+
+// 比较运算符对应的区间，NEQ 无法表示成一个区间
+func rangeOf(op Token, v int) (intRange, bool) {
+	switch op {
+	case EQL:
+		return intRange{lo: v, hi: v, hasLo: true, hasHi: true}, true
+	case LSS:
+		if v == math.MinInt {
+			return emptyRange, true
+		}
+		return intRange{hi: v - 1, hasHi: true}, true
+	case LEQ:
+		return intRange{hi: v, hasHi: true}, true
+	case GTR:
+		if v == math.MaxInt {
+			return emptyRange, true
+		}
+		return intRange{lo: v + 1, hasLo: true}, true
+	case GEQ:
+		return intRange{lo: v, hasLo: true}, true
+	default:
+		return intRange{}, false
+	}
+}
+
+// 是否没有任何值满足
+func (r intRange) isEmpty() bool {
+	return r.hasLo && r.hasHi && r.lo > r.hi
+}
+
+// 是否所有值都满足
+func (r intRange) isFull() bool {
+	return !r.hasLo && !r.hasHi
+}
+
+// 是否只有一个值满足
+func (r intRange) isPoint() bool {
+	return r.hasLo && r.hasHi && r.lo == r.hi
+}
+
+// 是否包含 v
+func (r intRange) contains(v int) bool {
+	return (!r.hasLo || v >= r.lo) && (!r.hasHi || v <= r.hi)
+}
+
+// 交集
+func (r intRange) intersect(o intRange) intRange {
+	ret := r
+	if o.hasLo && (!ret.hasLo || o.lo > ret.lo) {
+		ret.lo, ret.hasLo = o.lo, true
+	}
+	if o.hasHi && (!ret.hasHi || o.hi < ret.hi) {
+		ret.hi, ret.hasHi = o.hi, true
+	}
+	return ret
+}
+
+// 并集，只有两个区间相交或相邻，并集仍是一个区间时才返回 true
+func (r intRange) union(o intRange) (intRange, bool) {
+	if r.isEmpty() {
+		return o, true
+	}
+	if o.isEmpty() {
+		return r, true
+	}
+
+	// r 在 o 的左边且不相邻，或者 o 在 r 的左边且不相邻
+	if r.hasHi && o.hasLo && r.hi < o.lo && r.hi+1 != o.lo {
+		return r, false
+	}
+	if o.hasHi && r.hasLo && o.hi < r.lo && o.hi+1 != r.lo {
+		return r, false
+	}
+
+	ret := intRange{
+		hasLo: r.hasLo && o.hasLo,
+		hasHi: r.hasHi && o.hasHi,
+	}
+	if ret.hasLo {
+		ret.lo = r.lo
+		if o.lo < ret.lo {
+			ret.lo = o.lo
+		}
+	}
+	if ret.hasHi {
+		ret.hi = r.hi
+		if o.hi > ret.hi {
+			ret.hi = o.hi
+		}
+	}
+	return ret, true
+}
+
+// 区间的下界部分，即只保留下界后得到的区间
+func (r intRange) loPart() intRange {
+	return intRange{lo: r.lo, hasLo: r.hasLo}
+}
+
+// 区间的上界部分，即只保留上界后得到的区间
+func (r intRange) hiPart() intRange {
+	return intRange{hi: r.hi, hasHi: r.hasHi}
+}
+
+// 变量和整数常量的比较，并且可以表示成区间
+type rangeLeaf struct {
+	node  *Node
+	ident string
+	r     intRange
+}
+
+// 如果 n 是变量和整数常量的比较，返回它对应的区间
+func rangeLeafOf(n *Node) (rangeLeaf, bool) {
+	if !isCompareOp(n.Typ) || n.Args[0].Typ != IDENT || n.Args[1].Typ != INT {
+		return rangeLeaf{}, false
+	}
+
+	r, ok := rangeOf(n.Typ, n.Args[1].IntVal)
+	if !ok {
+		return rangeLeaf{}, false
+	}
+	return rangeLeaf{node: n, ident: n.Args[0].Val, r: r}, true
+}
+
+// 生成区间对应的节点，originals 中有和区间的某一部分完全相同的节点时直接复用，
+// 空区间返回 false 常量，全集返回 true 常量，有上下界时返回两个节点，调用方负责用 && 组合
+func (r intRange) nodes(ident string, originals []rangeLeaf) []*Node {
+	switch {
+	case r.isEmpty():
+		return []*Node{{Typ: CONST, BoolVal: false}}
+	case r.isFull():
+		return []*Node{{Typ: CONST, BoolVal: true}}
+	}
+
+	reuse := func(part intRange) *Node {
+		for _, o := range originals {
+			if o.r == part {
+				return o.node
+			}
+		}
+		return nil
+	}
+	compare := func(op Token, v int) *Node {
+		return &Node{Typ: op, Args: []*Param{
+			{Typ: IDENT, Val: ident},
+			{Typ: INT, IntVal: v, Val: strconv.Itoa(v)},
+		}}
+	}
+
+	if n := reuse(r); n != nil {
+		return []*Node{n}
+	}
+	if r.isPoint() {
+		return []*Node{compare(EQL, r.lo)}
+	}
+
+	var ret []*Node
+	if r.hasLo {
+		n := reuse(r.loPart())
+		if n == nil {
+			n = compare(GEQ, r.lo)
+		}
+		ret = append(ret, n)
+	}
+	if r.hasHi {
+		n := reuse(r.hiPart())
+		if n == nil {
+			n = compare(LEQ, r.hi)
+		}
+		ret = append(ret, n)
+	}
+	return ret
+}
@@ -1,7 +1,12 @@
 package internal
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"time"
 )
 
 // 所有的入参
@@ -60,3 +65,97 @@ func (vars Kv) GetBoolOrDefault(key string, defaultVal bool) bool {
 	}
 	return val
 }
+
+// 尝试获取时间类型，值可以是 time.Time、RFC3339 格式的字符串或 unix 秒数
+func (vars Kv) GetTime(key string) (time.Time, error) {
+	switch val := vars[key].(type) {
+	case time.Time:
+		return val, nil
+	case string:
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return t, fmt.Errorf("failed to get time by key(%s), err: %v", key, err)
+		}
+		return t, nil
+	case int:
+		return time.Unix(int64(val), 0), nil
+	case int64:
+		return time.Unix(val, 0), nil
+	case float64:
+		sec := int64(val)
+		return time.Unix(sec, int64((val-float64(sec))*float64(time.Second))), nil
+	default:
+		return time.Time{}, fmt.Errorf("failed to get time by key(%s)", key)
+	}
+}
+
+// 尝试获取时间类型，如果失败返回 defaultVal
+func (vars Kv) GetTimeOrDefault(key string, defaultVal time.Time) time.Time {
+	val, err := vars.GetTime(key)
+	if err != nil {
+		return defaultVal
+	}
+	return val
+}
+
+// 尝试获取时长类型，值可以是 time.Duration 或 time.ParseDuration 能解析的字符串
+func (vars Kv) GetDuration(key string) (time.Duration, error) {
+	switch val := vars[key].(type) {
+	case time.Duration:
+		return val, nil
+	case string:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return d, fmt.Errorf("failed to get duration by key(%s), err: %v", key, err)
+		}
+		return d, nil
+	default:
+		return 0, fmt.Errorf("failed to get duration by key(%s)", key)
+	}
+}
+
+// 尝试获取时长类型，如果失败返回 defaultVal
+func (vars Kv) GetDurationOrDefault(key string, defaultVal time.Duration) time.Duration {
+	val, err := vars.GetDuration(key)
+	if err != nil {
+		return defaultVal
+	}
+	return val
+}
+
+// 把一个 JSON 对象转换成 Kv，嵌套的对象展开成用 `.` 连接的 key，比如 {"user": {"age": 18}} 转换成 {"user.age": 18}，
+// 整数转换成 int，其他数字转换成 float64，数组等其他值保持 encoding/json 解析的结果
+func KvFromJSON(data []byte) (Kv, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("failed to parse vars, err: %v", err)
+	}
+	if dec.More() {
+		return nil, errors.New("failed to parse vars, err: unexpected data after JSON object")
+	}
+
+	vars := Kv{}
+	flattenJSON(vars, "", obj)
+	return vars, nil
+}
+
+func flattenJSON(vars Kv, prefix string, obj map[string]interface{}) {
+	for k, v := range obj {
+		key := prefix + k
+		switch val := v.(type) {
+		case map[string]interface{}:
+			flattenJSON(vars, key+".", val)
+		case json.Number:
+			if i, err := strconv.Atoi(val.String()); err == nil {
+				vars[key] = i
+			} else {
+				vars[key], _ = val.Float64()
+			}
+		default:
+			vars[key] = val
+		}
+	}
+}
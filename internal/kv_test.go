@@ -0,0 +1,24 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKvFromJSON(t *testing.T) {
+	vars, err := KvFromJSON([]byte(`{"a": 1, "b": 1.5, "c": "x", "d": true, "user": {"age": 18, "tags": {"vip": false}}, "e": null}`))
+	if err != nil {
+		t.Fatal("failed to call KvFromJSON, err:", err)
+	}
+
+	expect := Kv{"a": 1, "b": 1.5, "c": "x", "d": true, "user.age": 18, "user.tags.vip": false, "e": nil}
+	if !reflect.DeepEqual(vars, expect) {
+		t.Fatalf("expect %v, got %v", expect, vars)
+	}
+
+	for _, data := range []string{``, `[1]`, `{"a": 1} {}`, `{"a": }`} {
+		if _, err := KvFromJSON([]byte(data)); err == nil {
+			t.Fatalf("%q should fail", data)
+		}
+	}
+}
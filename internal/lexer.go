@@ -1,12 +1,15 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
+	"go/scanner"
 	"go/token"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Param struct {
@@ -17,6 +20,10 @@ type Param struct {
 	IntVal      int      // 当前 token 是数字时，这里保存实际的值
 	IntSliceVal []int    // 当前 token 是数字切片时，这里保存实际的值
 	StrSliceVal []string // 当前 token 是字符串切片时，这里保存实际的值
+
+	TimeVal     time.Time     // 当前 token 是时间时，这里保存实际的值
+	DurationVal time.Duration // 当前 token 是时长时，这里保存实际的值；是 now() 相关的时间时，这里保存相对 now() 的偏移
+	IsNow       bool          // 当前 token 是时间时，表示它是否是相对 now() 的时间，此时 TimeVal 无意义
 }
 
 func (t *Param) String() string {
@@ -31,6 +38,13 @@ func (t *Param) String() string {
 		return fmt.Sprintf(format, t.Typ, t.IntSliceVal)
 	case STR_SLICE:
 		return fmt.Sprintf(format, t.Typ, t.StrSliceVal)
+	case TIME:
+		if t.IsNow {
+			return fmt.Sprintf(format, t.Typ, "now()+"+t.DurationVal.String())
+		}
+		return fmt.Sprintf(format, t.Typ, t.TimeVal.Format(time.RFC3339))
+	case DURATION:
+		return fmt.Sprintf(format, t.Typ, t.DurationVal)
 	default:
 		return fmt.Sprintf(format, t.Typ, t.Val)
 	}
@@ -43,6 +57,9 @@ type Lexer struct {
 	Params     []*Param // 解析的结果，是一个合法的逆波兰表达式的参数序列
 
 	ExecWhenWalk func(node ast.Node) // 可以自定义的函数，针对 AST 上的每个节点都会执行
+	Limits       Limits              // 解析时的限制，默认不做限制
+
+	infix map[token.Pos]Token // 具名中缀运算符被替换成 `==` 的位置，见 translateInfix
 }
 
 func NewLexer(sourceCode string) *Lexer {
@@ -59,15 +76,57 @@ func NewLexerWithTokenSize(sourceCode string, tokenSize int) *Lexer {
 	}
 }
 
-// 解析 SourceCode 为 Tokens
+// 解析表达式失败时的错误，可以通过 errors.As 获取出错的位置
+type ParseError struct {
+	Offset int   // 出错的位置在表达式中的字节偏移，从 0 开始
+	Err    error // 错误原因，ParseError 的错误信息和它相同
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// 解析 SourceCode 为 Tokens，能定位到出错位置的错误是 *ParseError
 func (l *Lexer) Parse() error {
 	if l.HasParsed {
 		return l.Err
 	}
 	defer func() { l.HasParsed = true }()
 
-	expr, err := parser.ParseExpr(l.SourceCode)
+	if err := l.Limits.checkSource(l.SourceCode); err != nil {
+		l.Err = err
+		return err
+	}
+
+	src, infix := translateInfix(l.SourceCode)
+	l.infix = infix
+
+	expr, err := parser.ParseExpr(src)
 	if err != nil {
+		var list scanner.ErrorList
+		if errors.As(err, &list) && len(list) > 0 {
+			err = &ParseError{Offset: list[0].Pos.Offset, Err: err}
+		}
+		l.Err = err
+		return err
+	}
+
+	if err := l.Limits.checkDepth(expr); err != nil {
+		l.Err = err
+		return err
+	}
+
+	// 先做类型检查，保证后续生成的逆波兰表达式在结构上是合法的，整个表达式必须是布尔表达式
+	t, err := typeCheck(expr, l.infix)
+	if err == nil && t != typBool {
+		err = errorAt(expr.Pos(), "expr must be bool expr, got %v", t)
+	}
+	if err != nil {
+		l.Err = err
 		return err
 	}
 
@@ -151,38 +210,21 @@ func (l *Lexer) handleOneNode(node ast.Node) (shouldStop bool) {
 
 // 处理二元表达式
 func (l *Lexer) handleBinaryExpr(be *ast.BinaryExpr) (isValid bool) {
-	if golangToken2Token[be.Op] == INVALID {
-		l.Err = invalidTokenError(be.Op, be.OpPos)
-		return false
-	}
-
-	if be.Op == token.SUB { // 二元表达式的操作符不能为减号，减号只能被用于表示负数
-		return l.WithErr("`-` can only be used for negative numbers")
-	}
-
-	if be.Op == token.LAND || be.Op == token.LOR { // and/or 的子表达式必须为二元表达式或括号包裹的二元表达式
-		_, xIsBE := be.X.(*ast.BinaryExpr)
-		if !xIsBE && !isParenExprwithBinaryExpr(be.X) && !isUnaryExprWithNotOp(be.X) && !isCallExpr(be.X) {
-			return l.WithErr("`%s`'s subExpr must be BinaryExpr or ParenExpr(with BinaryExpr) or UnaryExpr(with `not` op) or CallExpr, err at %v", be.Op, be.OpPos)
-		}
-
-		_, yIsBE := be.Y.(*ast.BinaryExpr)
-		if !yIsBE && !isParenExprwithBinaryExpr(be.Y) && !isUnaryExprWithNotOp(be.Y) && !isCallExpr(be.Y) {
-			return l.WithErr("`%s`'s subExpr must be BinaryExpr or ParenExpr(with BinaryExpr) or UnaryExpr(with `not` op) or CallExpr, err at %v", be.Op, be.OpPos)
-		}
+	if be.Op == token.ADD || be.Op == token.SUB { // 时间和时长的加减法，直接计算出结果
+		return l.handleTimeArith(be)
 	}
 
-	if isBasicLit(be.X) && isBasicLit(be.Y) { // 不支持操作数均为常量的判断
-		return l.WithErr("both subExpr of `%s` is BasicLit, err at %v", be.Op, be.OpPos)
+	if t, ok := l.infix[be.OpPos]; ok { // 具名中缀运算符
+		l.Params = append(l.Params, &Param{Typ: t, Val: t.String()})
+		return true
 	}
 
-	if isIdent(be.X) && isIdent(be.Y) { // 不支持操作数均为变量或布尔值的判断
-		xIsBool, yIsBool := isBoolIdent(be.X.(*ast.Ident)), isBoolIdent(be.Y.(*ast.Ident))
-		if (xIsBool && yIsBool) || (!xIsBool && !yIsBool) {
-			return l.WithErr("both subExpr of `%s` is BoolValue or Ident, err at %v", be.Op, be.OpPos)
-		}
+	if golangToken2Token[be.Op] == INVALID {
+		l.Err = invalidTokenError(be.Op, be.OpPos)
+		return false
 	}
 
+	// 操作数的类型已经在 typeCheck 中检查过了，这里只需要生成 token
 	l.Params = append(l.Params, &Param{Typ: golangToken2Token[be.Op], Val: be.Op.String()})
 	return true
 }
@@ -194,19 +236,6 @@ func (l *Lexer) handleUnaryExpr(ue *ast.UnaryExpr) (isValid bool) {
 		return false
 	}
 
-	switch ue.Op {
-	case token.NOT:
-		if !isParenExprwithBinaryExpr(ue.X) && !isCallExpr(ue.X) { // not 的子表达式必须是括号包裹的二元表达式或函数调用
-			return l.WithErr("`not`'s subExpr must be ParenExpr(with BinaryExpr) or CallExpr, err at %v", ue.OpPos)
-		}
-
-	case token.SUB:
-		basicLit, ok := ue.X.(*ast.BasicLit)
-		if !ok || basicLit.Kind != token.INT { // 负号后面必须跟着一个数字常量
-			l.Err = fmt.Errorf("`-`'s subExpr must be number, err at %v", ue.OpPos)
-		}
-	}
-
 	l.Params = append(l.Params, &Param{Typ: golangToken2Token[ue.Op], Val: ue.Op.String()})
 	return true
 }
@@ -224,6 +253,10 @@ func (l *Lexer) handleBasicLit(lt *ast.BasicLit) (isValid bool) {
 		l.Params = append(l.Params, &Param{Typ: INT, Val: lt.Value, IntVal: int(intVal)})
 
 	case token.STRING:
+		if err := l.Limits.checkStringLen(lt.Value, lt.Pos()); err != nil {
+			l.Err = err
+			return false
+		}
 		l.Params = append(l.Params, &Param{Typ: STRING, Val: strings.Trim(lt.Value, `"`)})
 
 	default:
@@ -249,7 +282,12 @@ func (l *Lexer) handleIdent(it *ast.Ident) (isValid bool) {
 func (l *Lexer) handleCompositeLit(cl *ast.CompositeLit) (isValid bool) {
 	typ, ok := cl.Type.(*ast.ArrayType)
 	if !ok {
-		return l.WithErr("invalid CompositeLit, err at %v", cl.Type.Pos())
+		return l.errAt(cl.Type.Pos(), "invalid CompositeLit")
+	}
+
+	if err := l.Limits.checkListLen(len(cl.Elts), cl.Pos()); err != nil {
+		l.Err = err
+		return false
 	}
 
 	arrayTyp := typ.Elt.(*ast.Ident).Name
@@ -259,7 +297,7 @@ func (l *Lexer) handleCompositeLit(cl *ast.CompositeLit) (isValid bool) {
 		for _, elem := range cl.Elts {
 			bl, ok := elem.(*ast.BasicLit)
 			if !ok || bl.Kind != token.INT {
-				return l.WithErr("invalid array elem(%v), err at %v", bl.Value, elem.Pos())
+				return l.errAt(elem.Pos(), "invalid array elem(%v)", bl.Value)
 			}
 			intVal, _ := strconv.ParseInt(bl.Value, 10, 64) // 相信 golang 的 AST，不会有解析失败的现象
 			s = append(s, int(intVal))
@@ -272,7 +310,11 @@ func (l *Lexer) handleCompositeLit(cl *ast.CompositeLit) (isValid bool) {
 		for _, elem := range cl.Elts {
 			bl, ok := elem.(*ast.BasicLit)
 			if !ok || bl.Kind != token.STRING {
-				return l.WithErr("invalid array elem(%v), err at %v", bl.Value, elem.Pos())
+				return l.errAt(elem.Pos(), "invalid array elem(%v)", bl.Value)
+			}
+			if err := l.Limits.checkStringLen(bl.Value, bl.Pos()); err != nil {
+				l.Err = err
+				return false
 			}
 			s = append(s, strings.Trim(bl.Value, `"`))
 		}
@@ -280,7 +322,7 @@ func (l *Lexer) handleCompositeLit(cl *ast.CompositeLit) (isValid bool) {
 		return true
 
 	default: // 不支持其他类型
-		return l.WithErr("invalid array type(%v), err at %v", arrayTyp, cl.Type.Pos())
+		return l.errAt(cl.Type.Pos(), "invalid array type(%v)", arrayTyp)
 	}
 }
 
@@ -288,33 +330,58 @@ func (l *Lexer) handleCompositeLit(cl *ast.CompositeLit) (isValid bool) {
 func (l *Lexer) handleCallExpr(ce *ast.CallExpr) (isValid bool) {
 	fnName, ok := ce.Fun.(*ast.Ident)
 	if !ok {
-		return l.WithErr("invalid func call, err at %v", ce.Pos())
+		return l.errAt(ce.Pos(), "invalid func call")
 	}
 
 	switch fnName.Name {
-	case "in":
-		if len(ce.Args) != 2 {
-			return l.WithErr("`in` func must have 2 args, err at %v", ce.Pos())
+	case "in": // 参数已经在 typeCheck 中检查过了
+		l.Params = append(l.Params, &Param{Typ: FUNC, Val: fnName.Name})
+
+	case "time": // 参数已经作为字符串入栈，替换成时间常量
+		lastIdx := len(l.Params) - 1
+		t, err := parseTimeLit(l.Params[lastIdx].Val)
+		if err != nil {
+			return l.errAt(ce.Pos(), "%v", err)
 		}
+		l.Params[lastIdx] = &Param{Typ: TIME, TimeVal: t}
 
-		// in 函数的第一个参数必须是标识符，第二个参数必须是一个切片
-		if !isIdent(ce.Args[0]) || !isCompositeLit(ce.Args[1]) {
-			return l.WithErr("`in` func's signature is in(ident, []int) or in(ident, []string), err at %v", ce.Pos())
+	case "duration": // 参数已经作为字符串入栈，替换成时长常量
+		lastIdx := len(l.Params) - 1
+		d, err := parseDurationLit(l.Params[lastIdx].Val)
+		if err != nil {
+			return l.errAt(ce.Pos(), "%v", err)
 		}
+		l.Params[lastIdx] = &Param{Typ: DURATION, DurationVal: d}
 
-		l.Params = append(l.Params, &Param{Typ: FUNC, Val: fnName.Name})
+	case "now": // 没有参数，执行时才通过 Clock 获取时间
+		l.Params = append(l.Params, &Param{Typ: TIME, IsNow: true})
 
 	default:
-		return l.WithErr("invalid builtin func(%v), err at %v", fnName.Name, ce.Pos())
+		return l.errAt(ce.Pos(), "invalid builtin func(%v)", fnName.Name)
+	}
+
+	return true
+}
+
+// 处理时间和时长的加减法，两个操作数都是常量，所以此时栈顶就是它们的值
+func (l *Lexer) handleTimeArith(be *ast.BinaryExpr) (isValid bool) {
+	lastIdx := len(l.Params) - 1
+	if lastIdx < 1 {
+		return l.errAt(be.OpPos, "invalid `%s`", be.Op)
 	}
 
+	ret, err := foldTimeArith(l.Params[lastIdx-1], l.Params[lastIdx], be.Op == token.SUB)
+	if err != nil {
+		return l.errAt(be.OpPos, "%v", err)
+	}
+	l.Params = append(l.Params[:lastIdx-1], ret)
 	return true
 }
 
 // 处理选择表达式，其中的 X 已经在 walk 里提前处理了，这里只需要在 Params 里处理 Sel 和表达式本身即可
 func (l *Lexer) handleSelectorExpr(se *ast.SelectorExpr) (isValid bool) {
 	if !isSelectorExpr(se.X) && !isIdent(se.X) {
-		return l.WithErr("SelectorExpr.X must be SelectorExpr or Ident, err at %v", se.Pos())
+		return l.errAt(se.Pos(), "SelectorExpr.X must be SelectorExpr or Ident")
 	}
 
 	l.Params = append(l.Params, &Param{Typ: IDENT, Val: se.Sel.Name})
@@ -328,9 +395,21 @@ func (l *Lexer) WithErr(format string, vars ...interface{}) bool {
 	return false
 }
 
+// 设置指向 pos 处的 Err 并返回的 shortcut
+func (l *Lexer) errAt(pos token.Pos, format string, vars ...interface{}) bool {
+	l.Err = errorAt(pos, format, vars...)
+	return false
+}
+
+// 生成指向 pos 处的错误，错误信息以 `err at pos` 结尾；
+// pos 是 parser.ParseExpr 中的 token.Pos，从 1 开始
+func errorAt(pos token.Pos, format string, vars ...interface{}) error {
+	return &ParseError{Offset: int(pos) - 1, Err: fmt.Errorf(format+", err at %v", append(vars, pos)...)}
+}
+
 // 生成无效 token 的错误信息
 func invalidTokenError(t token.Token, pos token.Pos) error {
-	return fmt.Errorf("invalid token(%q) at position(%v)", t, pos)
+	return &ParseError{Offset: int(pos) - 1, Err: fmt.Errorf("invalid token(%q) at position(%v)", t, pos)}
 }
 
 // 判断 expr 是否是标识符
@@ -339,49 +418,11 @@ func isIdent(expr ast.Expr) bool {
 	return isIdent
 }
 
-// 判断 expr 是否是字符串或数字
-func isBasicLit(expr ast.Expr) bool {
-	_, isBasicLit := expr.(*ast.BasicLit)
-	return isBasicLit
-}
-
-// 判断 expr 是否是复合类型
-func isCompositeLit(expr ast.Expr) bool {
-	_, isCompositeLit := expr.(*ast.CompositeLit)
-	return isCompositeLit
-}
-
+// 判断 ident 是否是布尔值
 func isBoolIdent(ident *ast.Ident) bool {
 	return ident.Name == "true" || ident.Name == "false"
 }
 
-// 判断 expr 是否为包含 BinaryExpr 的 ParenExpr，给 not/and/or 用，
-// 因为目前 not 操作符只能处理这种表达式，and/or 只能处理这种以及 BinaryExpr
-func isParenExprwithBinaryExpr(expr ast.Expr) bool {
-	paranExpr, ok := expr.(*ast.ParenExpr)
-	if !ok {
-		return false
-	}
-
-	_, ok = paranExpr.X.(*ast.BinaryExpr)
-	return ok
-}
-
-// 判断 expr 是否为 not 的一元表达式
-func isUnaryExprWithNotOp(expr ast.Expr) bool {
-	be, ok := expr.(*ast.UnaryExpr)
-	if !ok {
-		return false
-	}
-	return be.Op == token.NOT
-}
-
-// 判断 expr 是否为函数调用表达式
-func isCallExpr(expr ast.Expr) bool {
-	_, ok := expr.(*ast.CallExpr)
-	return ok
-}
-
 // 判断 expr 是否为字段选择表达式
 func isSelectorExpr(expr ast.Expr) bool {
 	_, ok := expr.(*ast.SelectorExpr)
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -31,6 +32,15 @@ func TestNotExpr(t *testing.T) {
 		{"!a && b", true},
 		{"!(a==1 && b==1)", false},
 		{"!!(a && b)", true},
+		{"!((a==1))", false},
+		{"!!(a==1)", false},
+		{"!!in(a, []int{1})", false},
+		{"!(!(a==1) && !in(b, []string{}))", false},
+		{"((a==1)) && !!((b==2))", false},
+		{"!(a==1) || (((c==true)))", false},
+		{"!1", true},
+		{"!(a) == 1", true},
+		{"(a==1) == true", true},
 	}
 
 	for i, c := range cases {
@@ -47,11 +57,12 @@ func TestSubExpr(t *testing.T) {
 		Expr        string
 		ShouldError bool
 	}{
-		{"-a", true},
-		{"-1", false},
-		{"-+1", true},
-		{"--1", true},
-		{"-(1)", true},
+		{"b == -a", true},
+		{"b == -1", false},
+		{"b == -+1", true},
+		{"b == --1", true},
+		{"b == -(1)", true},
+		{"-1", true}, // 整个表达式必须是布尔表达式
 	}
 
 	for i, c := range cases {
@@ -88,16 +99,17 @@ func TestSlice(t *testing.T) {
 		Expr        string
 		ShouldError bool
 	}{
-		{"[]int{}", false},
-		{"[]int", false},
-		{"[1]int{}", false},
-		{"[]int{1}", false},
-		{"[]int{1, 2}", false},
-		{"[]int{1, 2, \"3\"}", true},
-		{"[]uint{1}", true},
-		{"[]string{}", false},
-		{"[]string{\"1\"}", false},
-		{"[]string{\"1\", 2}", true},
+		{"in(a, []int{})", false},
+		{"in(a, []int)", true}, // 没有 {} 时是类型而不是切片
+		{"in(a, [1]int{})", false},
+		{"in(a, []int{1})", false},
+		{"in(a, []int{1, 2})", false},
+		{"in(a, []int{1, 2, \"3\"})", true},
+		{"in(a, []uint{1})", true},
+		{"in(a, []string{})", false},
+		{"in(a, []string{\"1\"})", false},
+		{"in(a, []string{\"1\", 2})", true},
+		{"[]int{}", true}, // 整个表达式必须是布尔表达式
 	}
 
 	for i, c := range cases {
@@ -138,7 +150,7 @@ func TestFunc(t *testing.T) {
 }
 
 func TestSelectorExpr(t *testing.T) {
-	expr := `a.b.c.d`
+	expr := `a.b.c.d == 1`
 
 	lex := NewLexer(expr)
 	if err := lex.Parse(); err != nil {
@@ -149,3 +161,121 @@ func TestSelectorExpr(t *testing.T) {
 		t.Log(p)
 	}
 }
+
+func TestTimeExpr(t *testing.T) {
+	cases := []struct {
+		Expr        string
+		ShouldError bool
+	}{
+		{`a > time("2024-01-01T00:00:00Z")`, false},
+		{`a > now()`, false},
+		{`a > now() - duration("24h")`, false},
+		{`duration("1h") + now() < a`, false},
+		{`a < duration("1h") + duration("30m")`, false},
+		{`a > time("2024-01-01")`, true},
+		{`a > time(1)`, true},
+		{`a > time()`, true},
+		{`a > duration("1x")`, true},
+		{`a > now(1)`, true},
+		{`a > now() - 1`, true},
+		{`a > now() + now()`, true},
+		{`a > duration("1h") - now()`, true},
+		{`a - 1 > 0`, true},
+		{`now() > time("2024-01-01T00:00:00Z")`, true},
+	}
+
+	for i, c := range cases {
+		hasError := (NewLexer(c.Expr).Parse() != nil)
+
+		if c.ShouldError && !hasError || !c.ShouldError && hasError {
+			t.Fatalf("failed to test %d, expr: %q, shoudError: %v", i, c.Expr, c.ShouldError)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	cases := []struct {
+		Expr        string
+		Limits      Limits
+		ShouldError bool
+	}{
+		{`a == 1`, Limits{MaxSourceLen: 6}, false},
+		{`a == 10`, Limits{MaxSourceLen: 6}, true},
+		{`a == 1 && b == 2`, Limits{MaxTokens: 7}, false},
+		{`a == 1 && b == 2 && c == 3`, Limits{MaxTokens: 7}, true},
+		{`((a == 1))`, Limits{MaxDepth: 4}, false},
+		{`(((a == 1)))`, Limits{MaxDepth: 4}, true},
+		{`!!!!!!!!(a == 1)`, Limits{MaxDepth: 4}, true},
+		{`in(a, []int{1, 2, 3})`, Limits{MaxListLen: 3}, false},
+		{`in(a, []int{1, 2, 3, 4})`, Limits{MaxListLen: 3}, true},
+		{`a == "abc"`, Limits{MaxStringLen: 5}, false},
+		{`a == "abcd"`, Limits{MaxStringLen: 5}, true},
+		{`in(a, []string{"a", "abcd"})`, Limits{MaxStringLen: 5}, true},
+	}
+
+	for i, c := range cases {
+		lex := NewLexer(c.Expr)
+		lex.Limits = c.Limits
+		err := lex.Parse()
+
+		if c.ShouldError && !errors.Is(err, ErrLimitExceeded) || !c.ShouldError && err != nil {
+			t.Fatalf("failed to test %d, expr: %q, shoudError: %v, err: %v", i, c.Expr, c.ShouldError, err)
+		}
+	}
+}
+
+func TestRootExprType(t *testing.T) {
+	cases := []struct {
+		Expr string
+		Err  string
+	}{
+		{"a", "expr must be bool expr, got ident, err at 1"},
+		{"1", "expr must be bool expr, got int, err at 1"},
+		{" (a.b)", "expr must be bool expr, got ident, err at 2"},
+		{`now() - duration("1h")`, "expr must be bool expr, got time, err at 1"},
+		{"true", "expr must be bool expr, got boolean, err at 1"},
+	}
+	for _, c := range cases {
+		if err := NewLexer(c.Expr).Parse(); err == nil || err.Error() != c.Err {
+			t.Fatalf("%q should return error %q, got %v", c.Expr, c.Err, err)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	registerMatches(t)
+
+	cases := []struct {
+		Expr   string
+		Limits Limits
+		Offset int
+	}{
+		{"a >", Limits{}, 3},                                   // go/parser 的错误
+		{"a == 1 && b", Limits{}, 7},                           // 类型检查的错误
+		{`a == 1 && in(b, []int{1, "x"})`, Limits{}, 25},       // 切片中的元素
+		{"a == 1 &&\n  b == c", Limits{}, 14},                  // 多行
+		{`a matches "x" && b > time("x")`, Limits{}, 21},       // 时间常量
+		{`a matches "x" || b > 1 || 1 > 2`, Limits{}, 28},      // 具名中缀运算符不改变位置
+		{`a == "abcd"`, Limits{MaxStringLen: 5}, 5},            // 超过限制
+		{`in(a, []int{1, 2, 3, 4})`, Limits{MaxListLen: 3}, 6}, // 超过限制
+	}
+	for _, c := range cases {
+		lex := NewLexer(c.Expr)
+		lex.Limits = c.Limits
+		err := lex.Parse()
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Offset != c.Offset {
+			t.Fatalf("%q should return ParseError at %d, got %#v", c.Expr, c.Offset, err)
+		}
+		if pe.Error() != pe.Err.Error() {
+			t.Fatalf("unexpected message: %v", pe)
+		}
+	}
+
+	// 超过限制的错误仍然可以通过 errors.Is 判断
+	lex := NewLexer(`a == "abcd"`)
+	lex.Limits = Limits{MaxStringLen: 5}
+	if err := lex.Parse(); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("should return ErrLimitExceeded, got %v", err)
+	}
+}
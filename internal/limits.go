@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/scanner"
+	"go/token"
+)
+
+// 超过 Limits 中的限制时返回的错误，具体原因会包装在错误信息里
+var ErrLimitExceeded = errors.New("compile limit exceeded")
+
+// 词法解析时的限制，用于处理不可信的表达式，防止恶意的表达式耗尽内存或栈，值为 0 的项不做限制
+type Limits struct {
+	MaxSourceLen int // 表达式的最大字节数
+	MaxDepth     int // AST 的最大深度
+	MaxTokens    int // 表达式的最大 token 数
+	MaxListLen   int // 切片常量的最大元素个数
+	MaxStringLen int // 字符串常量的最大字节数（包含引号）
+}
+
+// 生成超过限制时的错误
+func limitError(format string, vars ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrLimitExceeded, fmt.Sprintf(format, vars...))
+}
+
+// 生成在 pos 处超过限制时的错误，错误信息以 `err at pos` 结尾
+func limitErrorAt(pos token.Pos, format string, vars ...interface{}) error {
+	return &ParseError{Offset: int(pos) - 1, Err: limitError(format+", err at %v", append(vars, pos)...)}
+}
+
+// 在调用 parser.ParseExpr 之前检查原表达式，
+// 括号的嵌套深度和 token 数在这里就可以确定，不需要等 parser 递归完
+func (lm Limits) checkSource(src string) error {
+	if lm.MaxSourceLen > 0 && len(src) > lm.MaxSourceLen {
+		return limitError("source length %d > %d", len(src), lm.MaxSourceLen)
+	}
+	if lm.MaxTokens <= 0 && lm.MaxDepth <= 0 {
+		return nil
+	}
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+
+	var s scanner.Scanner
+	s.Init(file, []byte(src), nil, 0)
+
+	tokens, depth := 0, 0
+	for {
+		pos, tok, _ := s.Scan()
+		if tok == token.EOF {
+			return nil
+		}
+		if tok == token.SEMICOLON { // scanner 会在行尾自动插入分号，不算在内
+			continue
+		}
+
+		tokens++
+		if lm.MaxTokens > 0 && tokens > lm.MaxTokens {
+			return limitErrorAt(pos, "token count > %d", lm.MaxTokens)
+		}
+
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+			if lm.MaxDepth > 0 && depth > lm.MaxDepth {
+				return limitErrorAt(pos, "nesting depth > %d", lm.MaxDepth)
+			}
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		}
+	}
+}
+
+// 检查 AST 的深度
+func (lm Limits) checkDepth(expr ast.Expr) error {
+	if lm.MaxDepth <= 0 {
+		return nil
+	}
+
+	var err error
+	depth := 0
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if n == nil { // 离开一个节点
+			depth--
+			return false
+		}
+		if err != nil {
+			return false
+		}
+
+		depth++
+		if depth > lm.MaxDepth {
+			err = limitErrorAt(n.Pos(), "AST depth > %d", lm.MaxDepth)
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+// 检查切片常量的元素个数
+func (lm Limits) checkListLen(n int, pos token.Pos) error {
+	if lm.MaxListLen > 0 && n > lm.MaxListLen {
+		return limitErrorAt(pos, "list length %d > %d", n, lm.MaxListLen)
+	}
+	return nil
+}
+
+// 检查字符串常量的长度
+func (lm Limits) checkStringLen(lit string, pos token.Pos) error {
+	if lm.MaxStringLen > 0 && len(lit) > lm.MaxStringLen {
+		return limitErrorAt(pos, "string length %d > %d", len(lit), lm.MaxStringLen)
+	}
+	return nil
+}
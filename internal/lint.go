@@ -0,0 +1,289 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Lint 检查出的问题，即永远不会成立或永远成立的子表达式
+type Issue struct {
+	Expr   string // 有问题的子表达式
+	Always bool   // 子表达式恒为 true 时为 true，恒为 false 时为 false
+	Reason string // 原因
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("`%s` is always %v: %s", i.Expr, i.Always, i.Reason)
+}
+
+// 根据同一个变量上的区间和等值关系，检查永远不会成立或永远成立的子表达式，
+// 只报告问题的根源，比如 `(a > 10 && a < 5) || b == 1` 只会报告 `a > 10 && a < 5`
+func Lint(n *Node) []Issue {
+	var issues []Issue
+	lintNode(n, &issues)
+	return issues
+}
+
+// 检查 n 及其子节点，返回 n 是否恒为某个值
+func lintNode(n *Node, issues *[]Issue) (val, known bool) {
+	switch n.Typ {
+	case CONST:
+		return n.BoolVal, true
+
+	case NOT:
+		val, known = lintNode(n.Children[0], issues)
+		return !val, known
+
+	case LAND, LOR:
+		return lintAndOr(n, issues)
+
+	default:
+		a, ok := atomOf(n, false)
+		if !ok {
+			return false, false
+		}
+		if !feasible([]atom{a}) {
+			*issues = append(*issues, Issue{Expr: n.String(), Always: false, Reason: "no value of `" + a.ident + "` satisfies it"})
+			return false, true
+		}
+		if !feasible([]atom{a.negate()}) {
+			*issues = append(*issues, Issue{Expr: n.String(), Always: true, Reason: "every value of `" + a.ident + "` satisfies it"})
+			return true, true
+		}
+		return false, false
+	}
+}
+
+// 检查 &&/|| 节点，&& 检查矛盾，|| 检查恒真，|| 取反后就是 &&，所以用同一套逻辑处理
+func lintAndOr(n *Node, issues *[]Issue) (val, known bool) {
+	isAnd := n.Typ == LAND
+
+	var operands []*Node
+	collectOperands(n, n.Typ, &operands)
+
+	allKnown := true
+	var atoms []atom
+	keys := map[string]bool{}
+	for _, op := range operands {
+		opVal, opKnown := lintNode(op, issues)
+		if opKnown && opVal != isAnd { // && 中有恒为 false 的子表达式，|| 中有恒为 true 的子表达式
+			return !isAnd, true
+		}
+		if opKnown {
+			continue
+		}
+		allKnown = false
+
+		keys[op.String()] = true
+		if a, ok := atomOf(op, !isAnd); ok { // || 的子表达式取反后按照 && 处理
+			atoms = append(atoms, a)
+		}
+	}
+	if allKnown {
+		return isAnd, true
+	}
+
+	report := func(reason string) (bool, bool) {
+		*issues = append(*issues, Issue{Expr: n.String(), Always: !isAnd, Reason: reason})
+		return !isAnd, true
+	}
+
+	for _, op := range operands {
+		if key, ok := negatedKey(op); ok && keys[key] {
+			return report("both `" + key + "` and its negation appear")
+		}
+	}
+
+	// 按变量和类型分组，每组的约束同时成立时才有解
+	groups := map[string][]atom{}
+	var order []string
+	for _, a := range atoms {
+		key := a.ident + "/" + a.typ.String()
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], a)
+	}
+	for _, key := range order {
+		group := groups[key]
+		if len(group) < 2 || feasible(group) {
+			continue
+		}
+		if isAnd {
+			return report("no value of `" + group[0].ident + "` satisfies all conditions")
+		}
+		return report("every value of `" + group[0].ident + "` satisfies one of the conditions")
+	}
+
+	return false, false
+}
+
+// 收集连续的同类节点的操作数，a && (b && c) 的操作数为 a、b、c
+func collectOperands(n *Node, typ Token, operands *[]*Node) {
+	if n.Typ != typ {
+		*operands = append(*operands, n)
+		return
+	}
+	for _, child := range n.Children {
+		collectOperands(child, typ, operands)
+	}
+}
+
+// 变量上的一个约束，值都转换成字符串，方便统一处理整数、字符串和布尔值
+type atom struct {
+	ident string
+	typ   Token           // 常量的类型，INT、STRING 或 BOOLEAN
+	in    map[string]bool // 变量必须在这个集合中，为 nil 时不限制
+	notIn map[string]bool // 变量不能在这个集合中
+	r     intRange        // 变量必须在这个区间内，只对整数有效
+	isIn  bool            // 是否是集合约束（== 或 in），否则是 != 或区间约束
+}
+
+// 把叶子节点转换成约束，negate 为 true 时转换成它取反后的约束，无法转换时返回 false
+func atomOf(n *Node, negate bool) (atom, bool) {
+	if n.Typ == NOT {
+		return atomOf(n.Children[0], !negate)
+	}
+
+	var a atom
+	switch {
+	case n.Typ == FUNC && n.Val == "in":
+		a = atom{ident: n.Args[0].Val, isIn: true, in: map[string]bool{}}
+		if n.Args[1].Typ == INT_SLICE {
+			a.typ = INT
+			for _, v := range n.Args[1].IntSliceVal {
+				a.in[strconv.Itoa(v)] = true
+			}
+		} else {
+			a.typ = STRING
+			for _, v := range n.Args[1].StrSliceVal {
+				a.in[v] = true
+			}
+		}
+
+	case isCompareOp(n.Typ) && n.Args[0].Typ == IDENT:
+		c := n.Args[1]
+		a = atom{ident: n.Args[0].Val, typ: c.Typ}
+		switch {
+		case c.Typ != INT && c.Typ != STRING && c.Typ != BOOLEAN:
+			return a, false
+		case n.Typ == EQL:
+			a.isIn, a.in = true, map[string]bool{constKey(c): true}
+		case n.Typ == NEQ:
+			a.notIn = map[string]bool{constKey(c): true}
+		case c.Typ == INT:
+			a.r, _ = rangeOf(n.Typ, c.IntVal)
+		default: // 字符串和布尔值的大小比较不做推导
+			return a, false
+		}
+
+	default:
+		return a, false
+	}
+
+	if negate {
+		a = a.negate()
+	}
+	return a, true
+}
+
+// 整数、字符串、布尔常量转换成字符串
+func constKey(c *Param) string {
+	switch c.Typ {
+	case INT:
+		return strconv.Itoa(c.IntVal)
+	case BOOLEAN:
+		return strconv.FormatBool(c.BoolVal)
+	default:
+		return c.Val
+	}
+}
+
+// 取反后的约束
+func (a atom) negate() atom {
+	ret := atom{ident: a.ident, typ: a.typ}
+	switch {
+	case a.isIn: // 在集合中取反是不在集合中
+		ret.notIn = a.in
+	case a.notIn != nil: // 不在集合中取反是在集合中
+		ret.isIn, ret.in = true, a.notIn
+	case a.r.isFull():
+		ret.r = emptyRange
+	case a.r.isEmpty():
+		ret.r = intRange{}
+	case a.r.hasLo: // [lo, +inf) 取反是 (-inf, lo-1]
+		ret.r, _ = rangeOf(LSS, a.r.lo)
+	default: // (-inf, hi] 取反是 [hi+1, +inf)
+		ret.r, _ = rangeOf(GTR, a.r.hi)
+	}
+	return ret
+}
+
+// 判断同一个变量上的多个约束能否同时成立，无法确定时认为可以成立
+func feasible(atoms []atom) bool {
+	r := intRange{}
+	var in map[string]bool
+	notIn := map[string]bool{}
+
+	for _, a := range atoms {
+		r = r.intersect(a.r)
+		for v := range a.notIn {
+			notIn[v] = true
+		}
+		if !a.isIn {
+			continue
+		}
+		if in == nil {
+			in = a.in
+			continue
+		}
+		both := map[string]bool{}
+		for v := range a.in {
+			if in[v] {
+				both[v] = true
+			}
+		}
+		in = both
+	}
+
+	typ := atoms[0].typ
+	if r.isEmpty() {
+		return false
+	}
+	if in == nil && typ == BOOLEAN {
+		in = map[string]bool{"true": true, "false": true}
+	}
+
+	if in != nil { // 有限的候选值，逐个检查
+		for v := range in {
+			if notIn[v] {
+				continue
+			}
+			if typ != INT {
+				return true
+			}
+			if n, _ := strconv.Atoi(v); r.contains(n) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if typ != INT || !r.hasLo || !r.hasHi {
+		return true
+	}
+
+	// 有界的整数区间，检查是否所有值都被排除了，最多只需要检查 len(notIn)+1 个值
+	count := 0
+	for v := r.lo; ; v++ {
+		if !notIn[strconv.Itoa(v)] {
+			return true
+		}
+		if v == r.hi {
+			return false
+		}
+		if count++; count > len(notIn) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package internal
+
+import "testing"
+
+func TestLint(t *testing.T) {
+	type Want struct {
+		Expr   string
+		Always bool
+	}
+
+	cases := []struct {
+		Expr   string
+		Issues []Want
+	}{
+		{"a > 10 && a < 5", []Want{{"a > 10 && a < 5", false}}},
+		{`a == "x" && a == "y"`, []Want{{`a == "x" && a == "y"`, false}}},
+		{"a > 0 || a <= 0", []Want{{"a > 0 || a <= 0", true}}},
+		{"(a > 10 && a < 5) && b == 1", []Want{{"a > 10 && a < 5 && b == 1", false}}},
+		{"(a > 10 && a < 5) || b == 1", []Want{{"a > 10 && a < 5", false}}},
+		{"a >= 1 && a <= 2 && a != 1 && a != 2", []Want{{"a >= 1 && a <= 2 && a != 1 && a != 2", false}}},
+		{"a == 5 && in(a, []int{1, 2})", []Want{{"a == 5 && in(a, []int{1, 2})", false}}},
+		{`in(a, []string{"x"}) && !in(a, []string{"x", "y"})`, []Want{{`in(a, []string{"x"}) && !in(a, []string{"x", "y"})`, false}}},
+		{"a != true && a != false", []Want{{"a != true && a != false", false}}},
+		{"a != 1 || a != 2", []Want{{"a != 1 || a != 2", true}}},
+		{"a > 0 || a != 3", []Want{{"a > 0 || a != 3", true}}},
+		{"b == 1 && !(b == 1)", []Want{{"b == 1 && !(b == 1)", false}}},
+		{"in(a, []int{})", []Want{{"in(a, []int{})", false}}},
+		{"!in(a, []int{}) || b == 1", []Want{{"in(a, []int{})", false}}},
+		{"a > 10 && a < 5 || a > 0 || a <= 0", []Want{{"a > 10 && a < 5", false}, {"(a > 10 && a < 5) || a > 0 || a <= 0", true}}},
+
+		// 没有问题的表达式
+		{"a > 5 && a < 10", nil},
+		{"a > 5 || a < 3", nil},
+		{`a == 1 && a == "1"`, nil},
+		{`a < "x" && a > "y"`, nil},
+		{"a == 1 || b == 2", nil},
+		{"a >= 1 && a <= 3 && a != 1 && a != 2", nil},
+	}
+
+	for i, c := range cases {
+		lex := NewLexer(c.Expr)
+		if err := lex.Parse(); err != nil {
+			t.Fatalf("faild to parse %q, err: %v", c.Expr, err)
+		}
+		tree, err := BuildTree(lex.Params)
+		if err != nil {
+			t.Fatalf("failed to build tree of %q, err: %v", c.Expr, err)
+		}
+
+		issues := Lint(tree)
+		if len(issues) != len(c.Issues) {
+			t.Fatalf("failed to test %d, expr: %q, want: %v, got: %v", i, c.Expr, c.Issues, issues)
+		}
+		for j, issue := range issues {
+			if issue.Expr != c.Issues[j].Expr || issue.Always != c.Issues[j].Always {
+				t.Fatalf("failed to test %d, expr: %q, want: %v, got: %v", i, c.Expr, c.Issues[j], issue)
+			}
+		}
+	}
+}
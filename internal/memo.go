@@ -0,0 +1,128 @@
+package internal
+
+import "strconv"
+
+// 公共子表达式消除：编译前先统计每个子表达式出现的次数，出现多次的子表达式只生成一个 Unit，
+// 并在一次求值中缓存它的结果；同一个变量被多个不同的子表达式使用时，同时缓存变量查找的结果
+type cseTable struct {
+	ids       map[string]int          // 子表达式的编号，相同的子表达式编号相同
+	nodeIDs   map[*Node]int           // 已经计算过编号的节点
+	counts    map[int]int             // 每个子表达式出现的次数
+	shared    map[int]Unit            // 已经生成的公共子表达式
+	slots     int                     // 已经分配的缓存下标个数
+	hasDup    bool                    // 是否有出现多次的子表达式，没有时编译过程不需要计算编号
+	shareVars bool                    // 是否有被多个不同的子表达式使用的变量
+	varLeaves map[string]map[int]bool // 每个变量被哪些叶子节点使用
+}
+
+func newCSETable() *cseTable {
+	return &cseTable{
+		ids:       map[string]int{},
+		nodeIDs:   map[*Node]int{},
+		counts:    map[int]int{},
+		shared:    map[int]Unit{},
+		varLeaves: map[string]map[int]bool{},
+	}
+}
+
+// 计算子表达式的编号，叶子节点根据 Node.String() 编号，其他节点根据运算符和子节点的编号编号，
+// 这样每个节点只需要计算一次，不会因为对每个子树都调用 Node.String() 导致编译时间和规则长度的平方成正比
+func (t *cseTable) id(n *Node) int {
+	if id, ok := t.nodeIDs[n]; ok {
+		return id
+	}
+
+	var key string
+	if n.IsLeaf() || n.Typ == CONST {
+		key = n.String()
+	} else {
+		key = n.Typ.String()
+		for _, child := range n.Children {
+			key += " " + strconv.Itoa(t.id(child))
+		}
+	}
+
+	id, ok := t.ids[key]
+	if !ok {
+		id = len(t.ids)
+		t.ids[key] = id
+	}
+	t.nodeIDs[n] = id
+	return id
+}
+
+// 统计 n 及其子节点出现的次数
+func (t *cseTable) count(n *Node) {
+	id := t.id(n)
+	t.counts[id]++
+	if t.counts[id] > 1 && n.Typ != CONST { // 常量本身没有计算，不需要缓存
+		t.hasDup = true
+	}
+
+	if !n.IsLeaf() {
+		for _, child := range n.Children {
+			t.count(child)
+		}
+		return
+	}
+
+	for _, arg := range n.Args {
+		if arg.Typ != IDENT {
+			continue
+		}
+		leaves := t.varLeaves[arg.Val]
+		if leaves == nil {
+			leaves = map[int]bool{}
+			t.varLeaves[arg.Val] = leaves
+		}
+		if leaves[id] = true; len(leaves) > 1 {
+			t.shareVars = true
+		}
+	}
+}
+
+// 如果 n 出现了多次，返回它的编号
+func (t *cseTable) sharedID(n *Node) (int, bool) {
+	if t == nil || !t.hasDup || n.Typ == CONST {
+		return 0, false
+	}
+	id := t.id(n)
+	return id, t.counts[id] > 1
+}
+
+// 如果 n 出现了多次，返回所有出现的地方共享的 Unit，否则原样返回 u
+func (t *cseTable) share(n *Node, u Unit) Unit {
+	id, ok := t.sharedID(n)
+	if !ok {
+		return u
+	}
+	if shared, ok := t.shared[id]; ok {
+		return shared
+	}
+
+	u = Memo(t.slots, u)
+	t.slots++
+	t.shared[id] = u
+	return u
+}
+
+// 在一次求值中缓存 u 的结果，slot 为结果在 Env 中的下标，
+// 缓存依赖 EvalEnv 在每次求值前让之前的结果过期，所以只能通过 Eval 等方法执行
+func Memo(slot int, u Unit) Unit {
+	return func(env *Env) (bool, error) {
+		if val, err, ok := env.memoized(slot); ok {
+			return val, err
+		}
+		val, err := u(env)
+		env.memoize(slot, val, err)
+		return val, err
+	}
+}
+
+// 在一次求值中缓存变量查找的结果，同一个变量只会查找一次
+func CacheLookups(u Unit) Unit {
+	return func(env *Env) (bool, error) {
+		env.cacheLookups = true
+		return u(env)
+	}
+}
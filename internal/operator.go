@@ -1,15 +1,39 @@
 package internal
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"time"
 )
 
 // 一个可以被执行并获取结果的函数
-type Unit func(Kv) (bool, error)
+type Unit func(*Env) (bool, error)
+
+// 使用默认的求值上下文执行 Unit
+func (u Unit) Eval(vars Kv) (bool, error) {
+	return u.EvalEnv(NewEnv(vars))
+}
+
+// 使用指定的求值上下文执行 Unit
+func (u Unit) EvalEnv(env *Env) (bool, error) {
+	env.reset()
+	if err := env.step(); err != nil {
+		return false, err
+	}
+	return u(env)
+}
+
+// 执行 Unit，每个子表达式执行前都会检查 ctx 是否已经被取消，
+// 超过 WithStepBudget 设置的步数上限时返回 ErrStepBudgetExceeded
+func (u Unit) EvalContext(ctx context.Context, vars Kv) (bool, error) {
+	return u.EvalEnv(&Env{Vars: vars, Ctx: ctx, MaxSteps: StepBudget(ctx)})
+}
 
 // shortcut，如果执行 Unit 时遇到错误，那么返回 false，否则直接返回 Unit 的返回值
 func (u Unit) GetBool(vars Kv) bool {
-	ret, err := u(vars)
+	ret, err := u.Eval(vars)
 	if err != nil {
 		return false
 	}
@@ -18,13 +42,19 @@ func (u Unit) GetBool(vars Kv) bool {
 
 // &&
 func And(x, y Unit) Unit {
-	return func(vals Kv) (bool, error) {
-		xVal, xErr := x(vals)
+	return func(env *Env) (bool, error) {
+		if err := env.step(); err != nil {
+			return false, err
+		}
+		xVal, xErr := x(env)
 		if xErr != nil {
 			return false, xErr
 		}
 
-		yVal, yErr := y(vals)
+		if err := env.step(); err != nil {
+			return false, err
+		}
+		yVal, yErr := y(env)
 		if yErr != nil {
 			return false, yErr
 		}
@@ -34,13 +64,19 @@ func And(x, y Unit) Unit {
 
 // ||
 func Or(x, y Unit) Unit {
-	return func(vals Kv) (bool, error) {
-		xVal, xErr := x(vals)
+	return func(env *Env) (bool, error) {
+		if err := env.step(); err != nil {
+			return false, err
+		}
+		xVal, xErr := x(env)
 		if xErr != nil {
 			return false, xErr
 		}
 
-		yVal, yErr := y(vals)
+		if err := env.step(); err != nil {
+			return false, err
+		}
+		yVal, yErr := y(env)
 		if yErr != nil {
 			return false, yErr
 		}
@@ -50,8 +86,11 @@ func Or(x, y Unit) Unit {
 
 // !
 func Not(x Unit) Unit {
-	return func(vals Kv) (bool, error) {
-		xVal, xErr := x(vals)
+	return func(env *Env) (bool, error) {
+		if err := env.step(); err != nil {
+			return false, err
+		}
+		xVal, xErr := x(env)
 		if xErr != nil {
 			return false, xErr
 		}
@@ -59,6 +98,13 @@ func Not(x Unit) Unit {
 	}
 }
 
+// 常量，优化后的表达式中才会出现
+func Const(val bool) Unit {
+	return func(*Env) (bool, error) {
+		return val, nil
+	}
+}
+
 // 二元运算符函数集，每个运算符都包含这些函数
 type OperatorFuncs struct {
 	// 变量比较整数
@@ -75,15 +121,25 @@ type OperatorFuncs struct {
 	VarToBool func(varname string, val bool) Unit
 	// 布尔值比较变量
 	BoolToVar func(val bool, varname string) Unit
+
+	// 变量比较时间
+	VarToTime func(varname string, val TimeFunc) Unit
+	// 时间比较变量
+	TimeToVar func(val TimeFunc, varname string) Unit
+
+	// 变量比较时长
+	VarToDuration func(varname string, val time.Duration) Unit
+	// 时长比较变量
+	DurationToVar func(val time.Duration, varname string) Unit
 }
 
-// 运算符函数集的默认实现
-var DefaultOperatorSet = map[Token]OperatorFuncs{
+// 运算符函数集的当前实现，由 operatorMu 保护，只能通过 LookupOperator 读取、通过 RegisterOperator 替换
+var defaultOperatorSet = map[Token]OperatorFuncs{
 	// ==
 	EQL: {
 		VarToInt: func(varname string, val int) Unit {
-			return func(vars Kv) (bool, error) {
-				intVal, err := vars.GetInt(varname)
+			return func(env *Env) (bool, error) {
+				intVal, err := env.GetInt(varname)
 				if err != nil {
 					return false, err
 				}
@@ -92,8 +148,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		IntToVar: func(val int, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				intVal, err := vars.GetInt(varname)
+			return func(env *Env) (bool, error) {
+				intVal, err := env.GetInt(varname)
 				if err != nil {
 					return false, err
 				}
@@ -102,8 +158,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		VarToStr: func(varname string, val string) Unit {
-			return func(vars Kv) (bool, error) {
-				strVal, err := vars.GetString(varname)
+			return func(env *Env) (bool, error) {
+				strVal, err := env.GetString(varname)
 				if err != nil {
 					return false, err
 				}
@@ -112,8 +168,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		StrToVar: func(val string, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				strVal, err := vars.GetString(varname)
+			return func(env *Env) (bool, error) {
+				strVal, err := env.GetString(varname)
 				if err != nil {
 					return false, err
 				}
@@ -122,8 +178,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		VarToBool: func(varname string, val bool) Unit {
-			return func(vars Kv) (bool, error) {
-				boolVal, err := vars.GetBool(varname)
+			return func(env *Env) (bool, error) {
+				boolVal, err := env.GetBool(varname)
 				if err != nil {
 					return false, err
 				}
@@ -132,21 +188,61 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		BoolToVar: func(val bool, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				boolVal, err := vars.GetBool(varname)
+			return func(env *Env) (bool, error) {
+				boolVal, err := env.GetBool(varname)
 				if err != nil {
 					return false, err
 				}
 				return (val == boolVal), nil
 			}
 		},
+
+		VarToTime: func(varname string, val TimeFunc) Unit {
+			return func(env *Env) (bool, error) {
+				timeVal, err := env.GetTime(varname)
+				if err != nil {
+					return false, err
+				}
+				return timeVal.Equal(val(env)), nil
+			}
+		},
+
+		TimeToVar: func(val TimeFunc, varname string) Unit {
+			return func(env *Env) (bool, error) {
+				timeVal, err := env.GetTime(varname)
+				if err != nil {
+					return false, err
+				}
+				return val(env).Equal(timeVal), nil
+			}
+		},
+
+		VarToDuration: func(varname string, val time.Duration) Unit {
+			return func(env *Env) (bool, error) {
+				durationVal, err := env.GetDuration(varname)
+				if err != nil {
+					return false, err
+				}
+				return (durationVal == val), nil
+			}
+		},
+
+		DurationToVar: func(val time.Duration, varname string) Unit {
+			return func(env *Env) (bool, error) {
+				durationVal, err := env.GetDuration(varname)
+				if err != nil {
+					return false, err
+				}
+				return (val == durationVal), nil
+			}
+		},
 	},
 
 	// !=
 	NEQ: {
 		VarToInt: func(varname string, val int) Unit {
-			return func(vars Kv) (bool, error) {
-				intVal, err := vars.GetInt(varname)
+			return func(env *Env) (bool, error) {
+				intVal, err := env.GetInt(varname)
 				if err != nil {
 					return false, err
 				}
@@ -155,8 +251,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		IntToVar: func(val int, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				intVal, err := vars.GetInt(varname)
+			return func(env *Env) (bool, error) {
+				intVal, err := env.GetInt(varname)
 				if err != nil {
 					return false, err
 				}
@@ -165,8 +261,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		VarToStr: func(varname string, val string) Unit {
-			return func(vars Kv) (bool, error) {
-				strVal, err := vars.GetString(varname)
+			return func(env *Env) (bool, error) {
+				strVal, err := env.GetString(varname)
 				if err != nil {
 					return false, err
 				}
@@ -175,8 +271,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		StrToVar: func(val string, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				strVal, err := vars.GetString(varname)
+			return func(env *Env) (bool, error) {
+				strVal, err := env.GetString(varname)
 				if err != nil {
 					return false, err
 				}
@@ -185,8 +281,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		VarToBool: func(varname string, val bool) Unit {
-			return func(vars Kv) (bool, error) {
-				boolVal, err := vars.GetBool(varname)
+			return func(env *Env) (bool, error) {
+				boolVal, err := env.GetBool(varname)
 				if err != nil {
 					return false, err
 				}
@@ -195,21 +291,61 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		BoolToVar: func(val bool, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				boolVal, err := vars.GetBool(varname)
+			return func(env *Env) (bool, error) {
+				boolVal, err := env.GetBool(varname)
 				if err != nil {
 					return false, err
 				}
 				return (val != boolVal), nil
 			}
 		},
+
+		VarToTime: func(varname string, val TimeFunc) Unit {
+			return func(env *Env) (bool, error) {
+				timeVal, err := env.GetTime(varname)
+				if err != nil {
+					return false, err
+				}
+				return !timeVal.Equal(val(env)), nil
+			}
+		},
+
+		TimeToVar: func(val TimeFunc, varname string) Unit {
+			return func(env *Env) (bool, error) {
+				timeVal, err := env.GetTime(varname)
+				if err != nil {
+					return false, err
+				}
+				return !val(env).Equal(timeVal), nil
+			}
+		},
+
+		VarToDuration: func(varname string, val time.Duration) Unit {
+			return func(env *Env) (bool, error) {
+				durationVal, err := env.GetDuration(varname)
+				if err != nil {
+					return false, err
+				}
+				return (durationVal != val), nil
+			}
+		},
+
+		DurationToVar: func(val time.Duration, varname string) Unit {
+			return func(env *Env) (bool, error) {
+				durationVal, err := env.GetDuration(varname)
+				if err != nil {
+					return false, err
+				}
+				return (val != durationVal), nil
+			}
+		},
 	},
 
 	// <
 	LSS: {
 		VarToInt: func(varname string, val int) Unit {
-			return func(vars Kv) (bool, error) {
-				intVal, err := vars.GetInt(varname)
+			return func(env *Env) (bool, error) {
+				intVal, err := env.GetInt(varname)
 				if err != nil {
 					return false, err
 				}
@@ -218,8 +354,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		IntToVar: func(val int, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				intVal, err := vars.GetInt(varname)
+			return func(env *Env) (bool, error) {
+				intVal, err := env.GetInt(varname)
 				if err != nil {
 					return false, err
 				}
@@ -228,8 +364,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		VarToStr: func(varname string, val string) Unit {
-			return func(vars Kv) (bool, error) {
-				strVal, err := vars.GetString(varname)
+			return func(env *Env) (bool, error) {
+				strVal, err := env.GetString(varname)
 				if err != nil {
 					return false, err
 				}
@@ -238,8 +374,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		StrToVar: func(val string, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				strVal, err := vars.GetString(varname)
+			return func(env *Env) (bool, error) {
+				strVal, err := env.GetString(varname)
 				if err != nil {
 					return false, err
 				}
@@ -250,13 +386,53 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		VarToBool: CompareBooleanLeft,
 
 		BoolToVar: CompareBooleanRight,
+
+		VarToTime: func(varname string, val TimeFunc) Unit {
+			return func(env *Env) (bool, error) {
+				timeVal, err := env.GetTime(varname)
+				if err != nil {
+					return false, err
+				}
+				return timeVal.Before(val(env)), nil
+			}
+		},
+
+		TimeToVar: func(val TimeFunc, varname string) Unit {
+			return func(env *Env) (bool, error) {
+				timeVal, err := env.GetTime(varname)
+				if err != nil {
+					return false, err
+				}
+				return val(env).Before(timeVal), nil
+			}
+		},
+
+		VarToDuration: func(varname string, val time.Duration) Unit {
+			return func(env *Env) (bool, error) {
+				durationVal, err := env.GetDuration(varname)
+				if err != nil {
+					return false, err
+				}
+				return (durationVal < val), nil
+			}
+		},
+
+		DurationToVar: func(val time.Duration, varname string) Unit {
+			return func(env *Env) (bool, error) {
+				durationVal, err := env.GetDuration(varname)
+				if err != nil {
+					return false, err
+				}
+				return (val < durationVal), nil
+			}
+		},
 	},
 
 	// <=
 	LEQ: {
 		VarToInt: func(varname string, val int) Unit {
-			return func(vars Kv) (bool, error) {
-				intVal, err := vars.GetInt(varname)
+			return func(env *Env) (bool, error) {
+				intVal, err := env.GetInt(varname)
 				if err != nil {
 					return false, err
 				}
@@ -265,8 +441,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		IntToVar: func(val int, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				intVal, err := vars.GetInt(varname)
+			return func(env *Env) (bool, error) {
+				intVal, err := env.GetInt(varname)
 				if err != nil {
 					return false, err
 				}
@@ -275,8 +451,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		VarToStr: func(varname string, val string) Unit {
-			return func(vars Kv) (bool, error) {
-				strVal, err := vars.GetString(varname)
+			return func(env *Env) (bool, error) {
+				strVal, err := env.GetString(varname)
 				if err != nil {
 					return false, err
 				}
@@ -285,8 +461,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		StrToVar: func(val string, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				strVal, err := vars.GetString(varname)
+			return func(env *Env) (bool, error) {
+				strVal, err := env.GetString(varname)
 				if err != nil {
 					return false, err
 				}
@@ -297,13 +473,53 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		VarToBool: CompareBooleanLeft,
 
 		BoolToVar: CompareBooleanRight,
+
+		VarToTime: func(varname string, val TimeFunc) Unit {
+			return func(env *Env) (bool, error) {
+				timeVal, err := env.GetTime(varname)
+				if err != nil {
+					return false, err
+				}
+				return !timeVal.After(val(env)), nil
+			}
+		},
+
+		TimeToVar: func(val TimeFunc, varname string) Unit {
+			return func(env *Env) (bool, error) {
+				timeVal, err := env.GetTime(varname)
+				if err != nil {
+					return false, err
+				}
+				return !val(env).After(timeVal), nil
+			}
+		},
+
+		VarToDuration: func(varname string, val time.Duration) Unit {
+			return func(env *Env) (bool, error) {
+				durationVal, err := env.GetDuration(varname)
+				if err != nil {
+					return false, err
+				}
+				return (durationVal <= val), nil
+			}
+		},
+
+		DurationToVar: func(val time.Duration, varname string) Unit {
+			return func(env *Env) (bool, error) {
+				durationVal, err := env.GetDuration(varname)
+				if err != nil {
+					return false, err
+				}
+				return (val <= durationVal), nil
+			}
+		},
 	},
 
 	// >
 	GTR: {
 		VarToInt: func(varname string, val int) Unit {
-			return func(vars Kv) (bool, error) {
-				intVal, err := vars.GetInt(varname)
+			return func(env *Env) (bool, error) {
+				intVal, err := env.GetInt(varname)
 				if err != nil {
 					return false, err
 				}
@@ -312,8 +528,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		IntToVar: func(val int, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				intVal, err := vars.GetInt(varname)
+			return func(env *Env) (bool, error) {
+				intVal, err := env.GetInt(varname)
 				if err != nil {
 					return false, err
 				}
@@ -322,8 +538,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		VarToStr: func(varname string, val string) Unit {
-			return func(vars Kv) (bool, error) {
-				strVal, err := vars.GetString(varname)
+			return func(env *Env) (bool, error) {
+				strVal, err := env.GetString(varname)
 				if err != nil {
 					return false, err
 				}
@@ -332,8 +548,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		StrToVar: func(val string, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				strVal, err := vars.GetString(varname)
+			return func(env *Env) (bool, error) {
+				strVal, err := env.GetString(varname)
 				if err != nil {
 					return false, err
 				}
@@ -344,13 +560,53 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		VarToBool: CompareBooleanLeft,
 
 		BoolToVar: CompareBooleanRight,
+
+		VarToTime: func(varname string, val TimeFunc) Unit {
+			return func(env *Env) (bool, error) {
+				timeVal, err := env.GetTime(varname)
+				if err != nil {
+					return false, err
+				}
+				return timeVal.After(val(env)), nil
+			}
+		},
+
+		TimeToVar: func(val TimeFunc, varname string) Unit {
+			return func(env *Env) (bool, error) {
+				timeVal, err := env.GetTime(varname)
+				if err != nil {
+					return false, err
+				}
+				return val(env).After(timeVal), nil
+			}
+		},
+
+		VarToDuration: func(varname string, val time.Duration) Unit {
+			return func(env *Env) (bool, error) {
+				durationVal, err := env.GetDuration(varname)
+				if err != nil {
+					return false, err
+				}
+				return (durationVal > val), nil
+			}
+		},
+
+		DurationToVar: func(val time.Duration, varname string) Unit {
+			return func(env *Env) (bool, error) {
+				durationVal, err := env.GetDuration(varname)
+				if err != nil {
+					return false, err
+				}
+				return (val > durationVal), nil
+			}
+		},
 	},
 
 	// >=
 	GEQ: {
 		VarToInt: func(varname string, val int) Unit {
-			return func(vars Kv) (bool, error) {
-				intVal, err := vars.GetInt(varname)
+			return func(env *Env) (bool, error) {
+				intVal, err := env.GetInt(varname)
 				if err != nil {
 					return false, err
 				}
@@ -359,8 +615,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		IntToVar: func(val int, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				intVal, err := vars.GetInt(varname)
+			return func(env *Env) (bool, error) {
+				intVal, err := env.GetInt(varname)
 				if err != nil {
 					return false, err
 				}
@@ -369,8 +625,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		VarToStr: func(varname string, val string) Unit {
-			return func(vars Kv) (bool, error) {
-				strVal, err := vars.GetString(varname)
+			return func(env *Env) (bool, error) {
+				strVal, err := env.GetString(varname)
 				if err != nil {
 					return false, err
 				}
@@ -379,8 +635,8 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		},
 
 		StrToVar: func(val string, varname string) Unit {
-			return func(vars Kv) (bool, error) {
-				strVal, err := vars.GetString(varname)
+			return func(env *Env) (bool, error) {
+				strVal, err := env.GetString(varname)
 				if err != nil {
 					return false, err
 				}
@@ -391,19 +647,170 @@ var DefaultOperatorSet = map[Token]OperatorFuncs{
 		VarToBool: CompareBooleanLeft,
 
 		BoolToVar: CompareBooleanRight,
+
+		VarToTime: func(varname string, val TimeFunc) Unit {
+			return func(env *Env) (bool, error) {
+				timeVal, err := env.GetTime(varname)
+				if err != nil {
+					return false, err
+				}
+				return !timeVal.Before(val(env)), nil
+			}
+		},
+
+		TimeToVar: func(val TimeFunc, varname string) Unit {
+			return func(env *Env) (bool, error) {
+				timeVal, err := env.GetTime(varname)
+				if err != nil {
+					return false, err
+				}
+				return !val(env).Before(timeVal), nil
+			}
+		},
+
+		VarToDuration: func(varname string, val time.Duration) Unit {
+			return func(env *Env) (bool, error) {
+				durationVal, err := env.GetDuration(varname)
+				if err != nil {
+					return false, err
+				}
+				return (durationVal >= val), nil
+			}
+		},
+
+		DurationToVar: func(val time.Duration, varname string) Unit {
+			return func(env *Env) (bool, error) {
+				durationVal, err := env.GetDuration(varname)
+				if err != nil {
+					return false, err
+				}
+				return (val >= durationVal), nil
+			}
+		},
 	},
 }
 
+// 布尔值比较大小时返回的错误
+var errBooleanSize = errors.New("boolean values cannot compare numeric sizes")
+
 // 布尔值无法比较大小，所以直接返回错误
 func CompareBooleanLeft(varname string, val bool) Unit {
-	return func(vars Kv) (bool, error) {
-		return false, errors.New("boolean values cannot compare numeric sizes")
+	return func(env *Env) (bool, error) {
+		return false, errBooleanSize
 	}
 }
 
 // 布尔值无法比较大小，所以直接返回错误
 func CompareBooleanRight(val bool, varname string) Unit {
-	return func(vars Kv) (bool, error) {
-		return false, errors.New("boolean values cannot compare numeric sizes")
+	return func(env *Env) (bool, error) {
+		return false, errBooleanSize
+	}
+}
+
+// 运算符集合，key 为比较运算符
+type OperatorSet map[Token]OperatorFuncs
+
+var (
+	operatorMu  sync.RWMutex       // 保护 defaultOperatorSet、overridden 和 operatorGen 的读写
+	overridden  = map[Token]bool{} // 通过 RegisterOperator 替换过实现的运算符
+	operatorGen uint64             // 每次注册运算符时加一，用于判断之前编译的结果是否还使用当前的实现
+)
+
+// 当前的运算符版本，RegisterOperator 或 RegisterInfixOperator 之后会变化，
+// 缓存编译结果时可以用它判断结果中的运算符实现是否已经过期
+func OperatorGeneration() uint64 {
+	operatorMu.RLock()
+	defer operatorMu.RUnlock()
+	return operatorGen
+}
+
+// 替换比较运算符 t 的函数集，可以和 Compile 并发调用，只影响之后编译的表达式，已经编译好的 Unit 不受影响
+func RegisterOperator(t Token, funcs OperatorFuncs) error {
+	if !isCompareOp(t) {
+		return fmt.Errorf("`%s` is not a comparison operator", t)
+	}
+	if err := funcs.validate(); err != nil {
+		return fmt.Errorf("operator `%s`: %w", t, err)
+	}
+
+	operatorMu.Lock()
+	defer operatorMu.Unlock()
+	defaultOperatorSet[t] = funcs
+	overridden[t] = true
+	operatorGen++
+	return nil
+}
+
+// 获取比较运算符 t 当前的函数集，可以和 RegisterOperator 并发调用
+func LookupOperator(t Token) (OperatorFuncs, bool) {
+	operatorMu.RLock()
+	defer operatorMu.RUnlock()
+	funcs, ok := defaultOperatorSet[t]
+	return funcs, ok
+}
+
+// 判断 params 中的比较是否都使用内置的实现，也就是没有出现在 ops 中、没有通过 RegisterOperator 替换过，也不是具名中缀运算符；
+// 化简、调整顺序、建立索引和检查遮蔽关系都基于内置运算符的语义，只能用于这样的表达式
+func BuiltinOperators(params []*Param, ops OperatorSet) bool {
+	operatorMu.RLock()
+	defer operatorMu.RUnlock()
+
+	for _, p := range params {
+		if _, ok := ops[p.Typ]; ok || overridden[p.Typ] || isInfixOp(p.Typ) {
+			return false
+		}
+	}
+	return true
+}
+
+// 用 def 中的函数补全 f 中为 nil 的函数
+func (f OperatorFuncs) merge(def OperatorFuncs) OperatorFuncs {
+	if f.VarToInt == nil {
+		f.VarToInt = def.VarToInt
+	}
+	if f.IntToVar == nil {
+		f.IntToVar = def.IntToVar
+	}
+	if f.VarToStr == nil {
+		f.VarToStr = def.VarToStr
+	}
+	if f.StrToVar == nil {
+		f.StrToVar = def.StrToVar
+	}
+	if f.VarToBool == nil {
+		f.VarToBool = def.VarToBool
+	}
+	if f.BoolToVar == nil {
+		f.BoolToVar = def.BoolToVar
+	}
+	if f.VarToTime == nil {
+		f.VarToTime = def.VarToTime
+	}
+	if f.TimeToVar == nil {
+		f.TimeToVar = def.TimeToVar
+	}
+	if f.VarToDuration == nil {
+		f.VarToDuration = def.VarToDuration
+	}
+	if f.DurationToVar == nil {
+		f.DurationToVar = def.DurationToVar
+	}
+	return f
+}
+
+// 检查函数集中的函数是否都不为 nil，否则编译到对应类型的比较时会 panic
+func (f OperatorFuncs) validate() error {
+	switch {
+	case f.VarToInt == nil || f.IntToVar == nil:
+		return errors.New("int comparison funcs must not be nil")
+	case f.VarToStr == nil || f.StrToVar == nil:
+		return errors.New("string comparison funcs must not be nil")
+	case f.VarToBool == nil || f.BoolToVar == nil:
+		return errors.New("boolean comparison funcs must not be nil")
+	case f.VarToTime == nil || f.TimeToVar == nil:
+		return errors.New("time comparison funcs must not be nil")
+	case f.VarToDuration == nil || f.DurationToVar == nil:
+		return errors.New("duration comparison funcs must not be nil")
 	}
+	return nil
 }
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+// 恢复被测试替换的运算符，同时清除替换过的标记，避免影响之后的测试
+func restoreOperator(t Token, origin OperatorFuncs) {
+	operatorMu.Lock()
+	defer operatorMu.Unlock()
+	defaultOperatorSet[t] = origin
+	delete(overridden, t)
+	operatorGen++
+}
+
+// 不区分大小写的字符串相等比较
+func foldEqual(varname string, val string) Unit {
+	return func(env *Env) (bool, error) {
+		strVal, err := env.GetString(varname)
+		if err != nil {
+			return false, err
+		}
+		return strings.EqualFold(strVal, val), nil
+	}
+}
+
+func TestCompilerOperators(t *testing.T) {
+	ops := OperatorSet{
+		EQL: {
+			VarToStr: foldEqual,
+			StrToVar: func(val string, varname string) Unit { return foldEqual(varname, val) },
+		},
+	}
+	compile := func(expr string, vm bool, ops OperatorSet) Unit {
+		t.Helper()
+		lex := NewLexer(expr)
+		if err := lex.Parse(); err != nil {
+			t.Fatalf("faild to parse %q, err: %v", expr, err)
+		}
+		c := NewCompiler(lex)
+		c.Operators = ops
+		if !vm {
+			fn, err := c.Compile()
+			if err != nil {
+				t.Fatalf("faild to compile %q, err: %v", expr, err)
+			}
+			return fn
+		}
+		p, err := c.CompileProgram()
+		if err != nil {
+			t.Fatalf("faild to compile %q to program, err: %v", expr, err)
+		}
+		return p.Unit()
+	}
+
+	cases := []struct {
+		Expr string
+		Vars Kv
+		Ret  bool
+		Std  bool // 使用内置实现时的结果
+	}{
+		{`s == "eu"`, Kv{"s": "EU"}, true, false},
+		{`"eu" == s`, Kv{"s": "Eu"}, true, false},
+		{`s != "eu"`, Kv{"s": "EU"}, true, true},                  // != 没有替换
+		{`a == 1 && s == "x"`, Kv{"a": 1, "s": "X"}, true, false}, // 没有提供的函数使用默认实现
+	}
+	for _, vm := range []bool{false, true} {
+		for _, c := range cases {
+			if ret, err := compile(c.Expr, vm, ops).Eval(c.Vars); err != nil || ret != c.Ret {
+				t.Fatalf("vm(%v): %q should be %v, got %v, %v", vm, c.Expr, c.Ret, ret, err)
+			}
+			// 只影响设置了 Operators 的 Compiler
+			if ret, err := compile(c.Expr, vm, nil).Eval(c.Vars); err != nil || ret != c.Std {
+				t.Fatalf("vm(%v): %q should be %v by default, got %v, %v", vm, c.Expr, c.Std, ret, err)
+			}
+		}
+	}
+}
+
+func TestBuiltinOperators(t *testing.T) {
+	lex := NewLexer(`s == "x" && a > 1`)
+	if err := lex.Parse(); err != nil {
+		t.Fatal("faild to call Parse, err:", err)
+	}
+	if !BuiltinOperators(lex.Params, nil) || !BuiltinOperators(lex.Params, OperatorSet{NEQ: {}}) {
+		t.Fatal("should use builtin operators")
+	}
+	if BuiltinOperators(lex.Params, OperatorSet{EQL: {}}) {
+		t.Fatal("== is overridden by compiler")
+	}
+
+	origin, _ := LookupOperator(GTR)
+	defer restoreOperator(GTR, origin)
+	if err := RegisterOperator(GTR, origin); err != nil {
+		t.Fatal("failed to call RegisterOperator, err:", err)
+	}
+	if BuiltinOperators(lex.Params, nil) {
+		t.Fatal("> is overridden by RegisterOperator")
+	}
+}
+
+func TestCompilerOperatorsReorder(t *testing.T) {
+	// 自定义的实现可能出错，调整顺序后短路求值会跳过这个错误，所以不调整顺序
+	ops := OperatorSet{GTR: {VarToInt: func(varname string, val int) Unit {
+		return func(env *Env) (bool, error) { return false, errBooleanSize }
+	}}}
+	lex := NewLexer(`in(a, []int{1, 2, 3}) && b > 1 && c == 1`)
+	if err := lex.Parse(); err != nil {
+		t.Fatal("faild to call Parse, err:", err)
+	}
+	c := NewCompiler(lex)
+	c.Reorder = &CostModel{Selectivity: map[string]float64{"c == 1": 0.01}} // c == 1 不成立的概率大，会被调整到最前面
+	c.Operators = ops
+	fn, err := c.Compile()
+	if err != nil {
+		t.Fatal("faild to call Compile, err:", err)
+	}
+	if _, err := fn.Eval(Kv{"a": 1, "b": 2, "c": 2}); err != errBooleanSize {
+		t.Fatalf("should return error of custom operator, got %v", err)
+	}
+}
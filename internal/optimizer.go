@@ -0,0 +1,285 @@
+package internal
+
+import "strconv"
+
+// 对表达式树做常量折叠和代数化简，生成更小的闭包树；
+// 被折叠掉的子表达式中的变量查找也一起去掉了，需要和原表达式返回相同错误时见 OptimizeParams
+func Optimize(n *Node) *Node {
+	switch n.Typ {
+	case NOT:
+		return optimizeNot(n)
+	case LAND, LOR:
+		return optimizeAndOr(n)
+	case FUNC:
+		return optimizeFunc(n)
+	default:
+		return n
+	}
+}
+
+// 优化逆波兰表达式，供 Lexer 和 Compiler 之间使用；
+// 化简改变了需要查找的变量时（比如 in(a, []int{}) && b == 1 化简为 false），同时返回原表达式按执行顺序需要查找的变量，
+// 设置到 Compiler.Preflight 后求值前会先查找它们，出错时返回的错误和原表达式相同
+func OptimizeParams(params []*Param) ([]*Param, []Lookup, error) {
+	tree, err := BuildTree(params)
+	if err != nil {
+		return nil, nil, err
+	}
+	optimized := Optimize(tree)
+
+	lookups := lookupsOf(tree)
+	if sameLookups(lookups, lookupsOf(optimized)) {
+		return optimized.Params(), nil, nil
+	}
+	return optimized.Params(), lookups, nil
+}
+
+// 两个表达式按执行顺序查找的变量是否相同，相同时出错的位置和错误也相同
+func sameLookups(x, y []Lookup) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// !!x => x，!true => false，!(a == 1) => a != 1
+func optimizeNot(n *Node) *Node {
+	child := Optimize(n.Children[0])
+
+	switch child.Typ {
+	case CONST:
+		return &Node{Typ: CONST, BoolVal: !child.BoolVal}
+	case NOT:
+		return child.Children[0]
+	case EQL:
+		return &Node{Typ: NEQ, Args: child.Args}
+	case NEQ:
+		return &Node{Typ: EQL, Args: child.Args}
+	default:
+		return &Node{Typ: NOT, Children: []*Node{child}}
+	}
+}
+
+// in(a, []int{}) => false，in(a, []int{1}) => a == 1
+func optimizeFunc(n *Node) *Node {
+	if n.Val != "in" {
+		return n
+	}
+
+	list := n.Args[1]
+	switch {
+	case list.Typ == INT_SLICE && len(list.IntSliceVal) == 0,
+		list.Typ == STR_SLICE && len(list.StrSliceVal) == 0:
+		return &Node{Typ: CONST, BoolVal: false}
+
+	case list.Typ == INT_SLICE && len(list.IntSliceVal) == 1:
+		v := list.IntSliceVal[0]
+		return &Node{Typ: EQL, Args: []*Param{n.Args[0], {Typ: INT, IntVal: v, Val: strconv.Itoa(v)}}}
+
+	case list.Typ == STR_SLICE && len(list.StrSliceVal) == 1:
+		return &Node{Typ: EQL, Args: []*Param{n.Args[0], {Typ: STRING, Val: list.StrSliceVal[0]}}}
+	}
+	return n
+}
+
+// 展开嵌套的 &&/||，去掉重复的子表达式，折叠常量，合并同一个变量上的范围比较
+func optimizeAndOr(n *Node) *Node {
+	isAnd := n.Typ == LAND
+
+	// 展开嵌套的同类节点，a && (b && c) => a && b && c
+	var children []*Node
+	for _, child := range n.Children {
+		child = Optimize(child)
+		if child.Typ == n.Typ {
+			children = append(children, child.Children...)
+		} else {
+			children = append(children, child)
+		}
+	}
+
+	children = dedupNodes(children)
+	if isAnd {
+		children = mergeAndRanges(children)
+		children = foldStrEquals(children)
+	} else {
+		children = mergeOrRanges(children)
+	}
+
+	// && 中出现 false 或 || 中出现 true 时整体为常量，另一种常量可以直接去掉，
+	// x 和 !x 同时出现时也是如此
+	keys := make(map[string]bool, len(children))
+	for _, child := range children {
+		keys[child.String()] = true
+	}
+	ret := children[:0]
+	for _, child := range children {
+		if child.Typ == CONST {
+			if child.BoolVal != isAnd {
+				return child
+			}
+			continue
+		}
+		if key, ok := negatedKey(child); ok && keys[key] {
+			return &Node{Typ: CONST, BoolVal: !isAnd}
+		}
+		ret = append(ret, child)
+	}
+
+	switch len(ret) {
+	case 0:
+		return &Node{Typ: CONST, BoolVal: isAnd}
+	case 1:
+		return ret[0]
+	default:
+		return &Node{Typ: n.Typ, Children: ret}
+	}
+}
+
+// n 取反后对应的表达式的字符串表示，!x 取反是 x，a == 1 取反是 a != 1
+func negatedKey(n *Node) (string, bool) {
+	switch n.Typ {
+	case NOT:
+		return n.Children[0].String(), true
+	case EQL:
+		return (&Node{Typ: NEQ, Args: n.Args}).String(), true
+	case NEQ:
+		return (&Node{Typ: EQL, Args: n.Args}).String(), true
+	default:
+		return "", false
+	}
+}
+
+// 去掉重复的子表达式，保留第一次出现的位置
+func dedupNodes(nodes []*Node) []*Node {
+	seen := make(map[string]bool, len(nodes))
+	ret := make([]*Node, 0, len(nodes))
+	for _, n := range nodes {
+		key := n.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ret = append(ret, n)
+	}
+	return ret
+}
+
+// 按变量把可以表示成区间的比较分组，返回每组的下标，分组按第一次出现的位置排序
+func groupRangeLeaves(nodes []*Node) (groups [][]rangeLeaf, firstIdx map[int]int, grouped map[int]bool) {
+	byIdent := map[string]int{}
+	firstIdx = map[int]int{}
+	grouped = map[int]bool{}
+
+	for i, n := range nodes {
+		leaf, ok := rangeLeafOf(n)
+		if !ok {
+			continue
+		}
+
+		gi, ok := byIdent[leaf.ident]
+		if !ok {
+			gi = len(groups)
+			byIdent[leaf.ident] = gi
+			groups = append(groups, nil)
+			firstIdx[i] = gi
+		}
+		groups[gi] = append(groups[gi], leaf)
+		grouped[i] = true
+	}
+	return
+}
+
+// 把分组合并后的结果放回原来的位置，每组的结果放在这一组第一次出现的位置
+func replaceGroups(nodes []*Node, merged [][]*Node, firstIdx map[int]int, grouped map[int]bool) []*Node {
+	ret := make([]*Node, 0, len(nodes))
+	for i, n := range nodes {
+		if gi, ok := firstIdx[i]; ok {
+			ret = append(ret, merged[gi]...)
+		} else if !grouped[i] {
+			ret = append(ret, n)
+		}
+	}
+	return ret
+}
+
+// a > 5 && a > 3 => a > 5，a > 5 && a < 3 => false
+func mergeAndRanges(nodes []*Node) []*Node {
+	groups, firstIdx, grouped := groupRangeLeaves(nodes)
+
+	merged := make([][]*Node, len(groups))
+	for gi, leaves := range groups {
+		r := leaves[0].r
+		for _, leaf := range leaves[1:] {
+			r = r.intersect(leaf.r)
+		}
+		merged[gi] = r.nodes(leaves[0].ident, leaves)
+	}
+	return replaceGroups(nodes, merged, firstIdx, grouped)
+}
+
+// a > 5 || a > 3 => a > 3，a > 0 || a <= 0 => true
+func mergeOrRanges(nodes []*Node) []*Node {
+	groups, firstIdx, grouped := groupRangeLeaves(nodes)
+
+	merged := make([][]*Node, len(groups))
+	for gi, leaves := range groups {
+		// 不断合并相交或相邻的区间，直到无法继续合并
+		parts := make([][]rangeLeaf, 0, len(leaves))
+		ranges := make([]intRange, 0, len(leaves))
+		for _, leaf := range leaves {
+			parts = append(parts, []rangeLeaf{leaf})
+			ranges = append(ranges, leaf.r)
+		}
+		for changed := true; changed; {
+			changed = false
+			for i := 0; i < len(ranges) && !changed; i++ {
+				for j := i + 1; j < len(ranges); j++ {
+					if r, ok := ranges[i].union(ranges[j]); ok {
+						ranges[i], parts[i] = r, append(parts[i], parts[j]...)
+						ranges = append(ranges[:j], ranges[j+1:]...)
+						parts = append(parts[:j], parts[j+1:]...)
+						changed = true
+						break
+					}
+				}
+			}
+		}
+
+		for i, r := range ranges {
+			if len(parts[i]) == 1 {
+				merged[gi] = append(merged[gi], parts[i][0].node)
+				continue
+			}
+
+			sub := r.nodes(leaves[0].ident, parts[i])
+			if len(sub) == 1 {
+				merged[gi] = append(merged[gi], sub[0])
+			} else {
+				merged[gi] = append(merged[gi], &Node{Typ: LAND, Children: sub})
+			}
+		}
+	}
+	return replaceGroups(nodes, merged, firstIdx, grouped)
+}
+
+// a == "x" && a == "y" => false
+func foldStrEquals(nodes []*Node) []*Node {
+	values := map[string]string{}
+	for _, n := range nodes {
+		if n.Typ != EQL || n.Args[0].Typ != IDENT || n.Args[1].Typ != STRING {
+			continue
+		}
+
+		ident, val := n.Args[0].Val, n.Args[1].Val
+		if prev, ok := values[ident]; ok && prev != val {
+			return []*Node{{Typ: CONST, BoolVal: false}}
+		}
+		values[ident] = val
+	}
+	return nodes
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	cases := []struct {
+		Expr   string
+		Result string
+	}{
+		{"a > 5 && a > 3", "a > 5"},
+		{"x == 1 || x == 1", "x == 1"},
+		{"!(!(a == 1))", "a == 1"},
+		{"!!in(a, []int{1, 2})", "in(a, []int{1, 2})"},
+		{"in(a, []int{})", "false"},
+		{"in(a, []int{}) || b == 1", "b == 1"},
+		{"in(a, []int{3})", "a == 3"},
+		{"a > 5 && a < 3", "false"},
+		{"a >= 3 && a <= 3", "a == 3"},
+		{"a > 0 || a <= 0", "true"},
+		{"a > 5 || a > 3", "a > 3"},
+		{"a > 3 && a < 10 && b == 1 && a > 4", "a > 4 && a < 10 && b == 1"},
+		{"a < 0 || a > 10 || a > 5", "a < 0 || a > 5"},
+		{"a == 1 || a == 2 || b == 1", "(a >= 1 && a <= 2) || b == 1"},
+		{"1 < a", "a > 1"},
+		{"!(a == 1)", "a != 1"},
+		{"(a == 1 && b == 2) && x == 3", "a == 1 && b == 2 && x == 3"},
+		{"a == 1 && !(a == 1)", "false"},
+		{"b == 1 || !(b == 1) && a == 1", "b == 1 || (b != 1 && a == 1)"},
+		{"a > 1 && (b == 1 || b == 1)", "a > 1 && b == 1"},
+		{"in(a, []int{}) && b == 1", "false"},
+		{"a > 0 || b == 1 || a <= 0", "true"},
+		{`a == 1 && b == "x" && a == 2`, "false"},
+	}
+
+	for i, c := range cases {
+		lex := NewLexer(c.Expr)
+		if err := lex.Parse(); err != nil {
+			t.Fatalf("faild to parse %q, err: %v", c.Expr, err)
+		}
+
+		params, lookups, err := OptimizeParams(lex.Params)
+		if err != nil {
+			t.Fatalf("failed to optimize %q, err: %v", c.Expr, err)
+		}
+		tree, err := BuildTree(params)
+		if err != nil {
+			t.Fatalf("failed to rebuild tree of %q, err: %v", c.Expr, err)
+		}
+		if tree.String() != c.Result {
+			t.Fatalf("failed to test %d, expr: %q, want: %q, got: %q", i, c.Expr, c.Result, tree.String())
+		}
+
+		// 优化前后的结果和错误应该一致
+		origin, err := NewCompiler(lex).Compile()
+		if err != nil {
+			t.Fatalf("failed to compile %q, err: %v", c.Expr, err)
+		}
+		compiler := NewCompiler(&Lexer{Params: params})
+		compiler.Preflight = lookups
+		optimized, err := compiler.Compile()
+		if err != nil {
+			t.Fatalf("failed to compile optimized %q, err: %v", c.Expr, err)
+		}
+		program, err := (&Compiler{lex: &Lexer{Params: params}, Preflight: lookups}).CompileProgram()
+		if err != nil {
+			t.Fatalf("failed to compile optimized %q to program, err: %v", c.Expr, err)
+		}
+
+		var inputs []Kv
+		for a := -2; a <= 12; a++ {
+			for b := 0; b <= 2; b++ {
+				inputs = append(inputs, Kv{"a": a, "b": b, "x": a % 4})
+			}
+		}
+		inputs = append(inputs, Kv{}, Kv{"a": 1}, Kv{"b": 1, "x": 1}, Kv{"a": "1", "b": 1, "x": 1}, Kv{"a": 1, "b": true, "x": 1})
+		for _, vars := range inputs {
+			want, wantErr := origin.Eval(vars)
+			for _, u := range []Unit{optimized, program.Unit()} {
+				got, gotErr := u.Eval(vars)
+				if got != want || fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {
+					t.Fatalf("result of %q changed after optimize with %v, want: %v, %v, got: %v, %v", c.Expr, vars, want, wantErr, got, gotErr)
+				}
+			}
+		}
+	}
+}
+
+func TestOptimizeStr(t *testing.T) {
+	cases := []struct {
+		Expr   string
+		Result string
+	}{
+		{`a == "x" && a == "y"`, "false"},
+		{`a == "x" && a == "x"`, `a == "x"`},
+		{`a == "x" && in(a, []string{})`, "false"},
+		{`!(a != "x") || in(a, []string{"y"})`, `a == "x" || a == "y"`},
+	}
+
+	for i, c := range cases {
+		lex := NewLexer(c.Expr)
+		if err := lex.Parse(); err != nil {
+			t.Fatalf("faild to parse %q, err: %v", c.Expr, err)
+		}
+
+		params, _, err := OptimizeParams(lex.Params)
+		if err != nil {
+			t.Fatalf("failed to optimize %q, err: %v", c.Expr, err)
+		}
+		tree, _ := BuildTree(params)
+		if tree.String() != c.Result {
+			t.Fatalf("failed to test %d, expr: %q, want: %q, got: %q", i, c.Expr, c.Result, tree.String())
+		}
+	}
+}
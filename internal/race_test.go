@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 在多个 goroutine 中同时执行 f，配合 go test -race 检查数据竞争
+func runConcurrently(t *testing.T, goroutines, times int, f func(g, i int) error) {
+	t.Helper()
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < times; i++ {
+				if err := f(g, i); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestUnitConcurrent(t *testing.T) {
+	// 包含公共子表达式、负数、函数调用和时间
+	expr := `(region == "EU" && a > -1) || (region == "EU" && b < -2) || in(c, []int{1, 3}) || t < now() - duration("1h")`
+	fn, p := compileBoth(t, expr)
+	old := time.Now().Add(-2 * time.Hour)
+
+	cases := []struct {
+		Vars Kv
+		Ret  bool
+	}{
+		{Kv{"region": "EU", "a": 0, "b": 0, "c": 0, "t": time.Now()}, true},
+		{Kv{"region": "EU", "a": -1, "b": -3, "c": 0, "t": time.Now()}, true},
+		{Kv{"region": "EU", "a": -1, "b": 0, "c": 0, "t": time.Now()}, false},
+		{Kv{"region": "US", "a": 0, "b": -3, "c": 3, "t": time.Now()}, true},
+		{Kv{"region": "US", "a": 0, "b": 0, "c": 0, "t": old}, true},
+		{Kv{"region": "US", "a": 0, "b": 0, "c": 0, "t": time.Now()}, false},
+	}
+
+	runConcurrently(t, 16, 500, func(g, i int) error {
+		c := cases[(g+i)%len(cases)]
+		if ret, err := fn.Eval(c.Vars); err != nil || ret != c.Ret {
+			return fmt.Errorf("%v should be %v, got %v, %v", c.Vars, c.Ret, ret, err)
+		}
+		if ret, err := p.Unit().Eval(c.Vars); err != nil || ret != c.Ret {
+			return fmt.Errorf("program: %v should be %v, got %v, %v", c.Vars, c.Ret, ret, err)
+		}
+		return nil
+	})
+}
+
+func TestRuleSetConcurrent(t *testing.T) {
+	for _, index := range []bool{false, true} {
+		rs := mustRuleSet(t, index,
+			"eu", `region == "EU" && a > 1`,
+			"us", `region == "US" && a > 1`,
+			"neg", `region == "EU" && a < -1`,
+		)
+		runConcurrently(t, 16, 500, func(g, i int) error {
+			a := (g+i)%5 - 2
+			ret, err := rs.Eval(Kv{"region": "EU", "a": a})
+			if err != nil {
+				return err
+			}
+			var want []string
+			if a > 1 {
+				want = []string{"eu"}
+			} else if a < -1 {
+				want = []string{"neg"}
+			}
+			if fmt.Sprint(ret) != fmt.Sprint(want) {
+				return fmt.Errorf("a = %d should be %v, got %v", a, want, ret)
+			}
+			return nil
+		})
+	}
+}
+
+func TestRegisterOperatorConcurrent(t *testing.T) {
+	origin, _ := LookupOperator(GTR)
+	defer restoreOperator(GTR, origin)
+
+	// 编译的同时替换运算符，编译结果要么使用旧实现要么使用新实现
+	runConcurrently(t, 8, 200, func(g, i int) error {
+		if g == 0 {
+			return RegisterOperator(GTR, origin)
+		}
+		lex := NewLexer("a > 1")
+		if err := lex.Parse(); err != nil {
+			return err
+		}
+		fn, err := NewCompiler(lex).Compile()
+		if err != nil {
+			return err
+		}
+		if !fn.GetBool(Kv{"a": 2}) {
+			return fmt.Errorf("a > 1 should be true")
+		}
+		return nil
+	})
+
+	if err := RegisterOperator(LAND, origin); err == nil {
+		t.Fatal("&& is not a comparison operator")
+	}
+	if err := RegisterOperator(GTR, OperatorFuncs{}); err == nil {
+		t.Fatal("should reject nil funcs")
+	}
+}
+
+func TestCompileTwice(t *testing.T) {
+	// 编译不会修改 Lexer 中的 token，同一个 Lexer 可以多次编译
+	lex := NewLexer("a > -1")
+	if err := lex.Parse(); err != nil {
+		t.Fatal("faild to call Parse, err:", err)
+	}
+	for i := 0; i < 2; i++ {
+		fn, err := NewCompiler(lex).Compile()
+		if err != nil {
+			t.Fatal("faild to call Compile, err:", err)
+		}
+		if ret, err := fn.Eval(Kv{"a": 0}); !ret || err != nil {
+			t.Fatalf("compile %d: a > -1 should be true when a = 0, got %v, %v", i, ret, err)
+		}
+	}
+}
+
+func TestRegisterInfixOperatorConcurrent(t *testing.T) {
+	gtr, _ := LookupOperator(GTR)
+
+	// 注册具名中缀运算符的同时解析和编译其他表达式
+	runConcurrently(t, 8, 200, func(g, i int) error {
+		if g == 0 {
+			_, err := RegisterInfixOperator("above", gtr)
+			return err
+		}
+		expr := "a > 1"
+		if _, ok := OperatorToken("above"); ok {
+			expr = "a above 1"
+		}
+		lex := NewLexer(expr)
+		if err := lex.Parse(); err != nil {
+			return err
+		}
+		fn, err := NewCompiler(lex).Compile()
+		if err != nil {
+			return err
+		}
+		if !fn.GetBool(Kv{"a": 2}) {
+			return fmt.Errorf("%s should be true", expr)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// 代价模型，用于调整 &&/|| 操作数的执行顺序，让代价低、更容易决定结果的子表达式先执行
+type CostModel struct {
+	Costs       map[string]float64 // 每次执行的代价，key 为运算符（如 "=="）或函数名（如 "in"），未指定时比较为 1，in 为 2，其他函数为 10
+	Selectivity map[string]float64 // 子表达式成立的概率，key 为子表达式的写法（Node.String()），未指定时为 0.5
+}
+
+// 默认的代价
+const (
+	defaultCompareCost = 1
+	defaultInCost      = 2
+	defaultFuncCost    = 10
+	defaultSelectivity = 0.5
+)
+
+// 求值前需要查找的变量
+type Lookup struct {
+	Key string
+	Typ Token // 变量被当作的类型，INT、STRING、BOOLEAN、TIME 或 DURATION
+	Err error // 不为 nil 时表示叶子节点不查找变量，总是返回这个错误，比如布尔值比较大小
+}
+
+// 按执行顺序收集叶子节点需要查找的变量，同一个变量的同一种类型只保留第一次
+func lookupsOf(n *Node) []Lookup {
+	var lookups []Lookup
+	seen := map[Lookup]bool{}
+
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if !n.IsLeaf() {
+			for _, child := range n.Children {
+				walk(child)
+			}
+			return
+		}
+
+		typ := n.Args[1].Typ
+		switch typ {
+		case INT_SLICE:
+			typ = INT
+		case STR_SLICE:
+			typ = STRING
+		}
+		l := Lookup{Key: n.Args[0].Val, Typ: typ}
+		if typ == BOOLEAN && n.Typ != EQL && n.Typ != NEQ {
+			l.Err = errBooleanSize
+		}
+		if n.Args[0].Typ == IDENT && !seen[l] {
+			seen[l] = true
+			lookups = append(lookups, l)
+		}
+	}
+	walk(n)
+	return lookups
+}
+
+// 估算子表达式每次执行的代价和成立的概率，&&/|| 按短路求值计算，各个操作数视为相互独立
+func (m *CostModel) estimate(n *Node) (cost, p float64) {
+	p, hasP := m.Selectivity[n.String()]
+
+	switch n.Typ {
+	case CONST:
+		if n.BoolVal {
+			return 0, 1
+		}
+		return 0, 0
+
+	case NOT:
+		cost, childP := m.estimate(n.Children[0])
+		if !hasP {
+			p = 1 - childP
+		}
+		return cost, p
+
+	case LAND, LOR:
+		isAnd := n.Typ == LAND
+		pass := 1.0 // 执行到当前操作数的概率
+		for _, child := range n.Children {
+			childCost, childP := m.estimate(child)
+			cost += pass * childCost
+			if isAnd {
+				pass *= childP
+			} else {
+				pass *= 1 - childP
+			}
+		}
+		if !hasP {
+			p = pass
+			if !isAnd {
+				p = 1 - pass
+			}
+		}
+		return cost, p
+
+	default:
+		cost, ok := m.Costs[n.opVal()]
+		switch {
+		case ok:
+		case n.Typ != FUNC:
+			cost = defaultCompareCost
+		case n.Val == "in":
+			cost = defaultInCost
+		default:
+			cost = defaultFuncCost
+		}
+		if !hasP {
+			p = defaultSelectivity
+		}
+		return cost, p
+	}
+}
+
+// 调整 &&/|| 操作数的执行顺序，&& 中代价低、容易为 false 的先执行，|| 中代价低、容易为 true 的先执行，
+// 嵌套的同类节点会先展开，原来的树不会被修改
+func (m *CostModel) Reorder(n *Node) *Node {
+	switch n.Typ {
+	case NOT:
+		return &Node{Typ: NOT, Children: []*Node{m.Reorder(n.Children[0])}}
+
+	case LAND, LOR:
+		var operands []*Node
+		collectOperands(n, n.Typ, &operands)
+
+		type ranked struct {
+			node *Node
+			rank float64
+		}
+		children := make([]ranked, 0, len(operands))
+		for _, op := range operands {
+			op = m.Reorder(op)
+			cost, p := m.estimate(op)
+			decisive := 1 - p // && 中为 false 时结束
+			if n.Typ == LOR {
+				decisive = p // || 中为 true 时结束
+			}
+			rank := math.Inf(1)
+			if decisive > 0 {
+				rank = cost / decisive
+			}
+			children = append(children, ranked{node: op, rank: rank})
+		}
+		sort.SliceStable(children, func(i, j int) bool {
+			return children[i].rank < children[j].rank
+		})
+
+		ret := &Node{Typ: n.Typ}
+		for _, child := range children {
+			ret.Children = append(ret.Children, child.node)
+		}
+		return ret
+
+	default:
+		return n
+	}
+}
+
+// 输出表达式树以及每个节点估算的代价和成立的概率，子节点按执行顺序排列
+func (m *CostModel) Explain(n *Node) string {
+	var sb strings.Builder
+	m.explain(n, 0, &sb)
+	return sb.String()
+}
+
+func (m *CostModel) explain(n *Node, depth int, sb *strings.Builder) {
+	cost, p := m.estimate(n)
+	sb.WriteString(strings.Repeat("  ", depth))
+
+	switch n.Typ {
+	case NOT, LAND, LOR:
+		sb.WriteString(n.Typ.String())
+	default:
+		sb.WriteString(n.String())
+	}
+	fmt.Fprintf(sb, " (cost %.3g, p %.3g)\n", cost, p)
+
+	for _, child := range n.Children {
+		m.explain(child, depth+1, sb)
+	}
+}
+
+// 短路求值的 &&，x 为 false 时不再执行 y，只在调整顺序后和 Preflight 一起使用
+func ShortAnd(x, y Unit) Unit {
+	return func(env *Env) (bool, error) {
+		if err := env.step(); err != nil {
+			return false, err
+		}
+		xVal, xErr := x(env)
+		if xErr != nil || !xVal {
+			return false, xErr
+		}
+
+		if err := env.step(); err != nil {
+			return false, err
+		}
+		return y(env)
+	}
+}
+
+// 短路求值的 ||，x 为 true 时不再执行 y，只在调整顺序后和 Preflight 一起使用
+func ShortOr(x, y Unit) Unit {
+	return func(env *Env) (bool, error) {
+		if err := env.step(); err != nil {
+			return false, err
+		}
+		xVal, xErr := x(env)
+		if xErr != nil {
+			return false, xErr
+		}
+		if xVal {
+			return true, nil
+		}
+
+		if err := env.step(); err != nil {
+			return false, err
+		}
+		return y(env)
+	}
+}
+
+// 求值前按原表达式的执行顺序查找所有变量，叶子节点的错误只会来自变量查找或者 Lookup.Err，
+// 所以第一个失败的查找就是原表达式求值时返回的错误；全部成功时执行 u，
+// 这时 u 中的叶子节点都不会出错，短路求值和调整顺序不会改变结果
+func Preflight(lookups []Lookup, u Unit) Unit {
+	return func(env *Env) (bool, error) {
+		env.cacheLookups = true // 之后叶子节点的查找直接使用缓存的结果
+
+		for _, l := range lookups {
+			err := l.Err
+			switch {
+			case err != nil:
+				return false, err
+			case l.Typ == INT:
+				_, err = env.GetInt(l.Key)
+			case l.Typ == STRING:
+				_, err = env.GetString(l.Key)
+			case l.Typ == BOOLEAN:
+				_, err = env.GetBool(l.Key)
+			case l.Typ == TIME:
+				_, err = env.GetTime(l.Key)
+			case l.Typ == DURATION:
+				_, err = env.GetDuration(l.Key)
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+		return u(env)
+	}
+}
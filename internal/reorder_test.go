@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestReorder(t *testing.T) {
+	cases := []struct {
+		Expr   string
+		Model  CostModel
+		Result string
+	}{
+		{"in(a, []int{1, 2}) && b == 1", CostModel{}, "b == 1 && in(a, []int{1, 2})"},
+		{"in(a, []int{1, 2}) || b == 1", CostModel{}, "b == 1 || in(a, []int{1, 2})"},
+		{"a == 1 && b == 1", CostModel{Selectivity: map[string]float64{"b == 1": 0.1}}, "b == 1 && a == 1"},
+		{"a == 1 || b == 1", CostModel{Selectivity: map[string]float64{"b == 1": 0.1}}, "a == 1 || b == 1"},
+		{"a == 1 && b == 1", CostModel{Costs: map[string]float64{"==": 5}}, "a == 1 && b == 1"},
+		{"in(a, []int{1}) && (b == 1 && c > 2)", CostModel{}, "b == 1 && c > 2 && in(a, []int{1})"},
+		{"!(in(a, []int{1}) || b == 1) && c == 1", CostModel{}, "c == 1 && !(b == 1 || in(a, []int{1}))"},
+		{"x == 1 && (in(a, []int{1}) || b == 1)", CostModel{}, "x == 1 && (b == 1 || in(a, []int{1}))"},
+	}
+
+	for i, c := range cases {
+		tree := mustTree(t, c.Expr)
+		if ret := c.Model.Reorder(tree).String(); ret != c.Result {
+			t.Fatalf("case(%d) %q should be reordered to %q, got %q", i, c.Expr, c.Result, ret)
+		}
+		if ret := tree.String(); ret != mustTree(t, c.Expr).String() {
+			t.Fatalf("case(%d) original tree should not be modified, got %q", i, ret)
+		}
+	}
+}
+
+func TestReorderEval(t *testing.T) {
+	exprs := []string{
+		`in(a, []int{1, 2}) && b == 1 && c == "x"`,
+		`in(a, []int{1, 2}) || b == 1 || c == "x"`,
+		`!(in(a, []int{1, 2}) && b > 0) || (c == "x" && b < 5)`,
+		`(a == 1 && b == 1) || (a == 1 && b == 2)`,
+		`in(a, []int{1, 2}) || d > true`,
+	}
+	inputs := []Kv{
+		{"a": 1, "b": 1, "c": "x"},
+		{"a": 3, "b": 2, "c": "y"},
+		{"a": 2, "b": 0, "c": "x"},
+		{"b": 1, "c": "x"},         // 缺少 a
+		{"a": 1, "c": "x"},         // 缺少 b
+		{"a": 1, "b": 1},           // 缺少 c
+		{"a": "1", "b": 1, "c": 1}, // 类型不对
+	}
+
+	for _, expr := range exprs {
+		lex := NewLexer(expr)
+		if err := lex.Parse(); err != nil {
+			t.Fatalf("faild to parse %q, err: %v", expr, err)
+		}
+		fn, err := NewCompiler(lex).Compile()
+		if err != nil {
+			t.Fatalf("faild to compile %q, err: %v", expr, err)
+		}
+
+		lex = NewLexer(expr)
+		if err := lex.Parse(); err != nil {
+			t.Fatalf("faild to parse %q, err: %v", expr, err)
+		}
+		compiler := NewCompiler(lex)
+		compiler.Reorder = &CostModel{}
+		reordered, err := compiler.Compile()
+		if err != nil {
+			t.Fatalf("faild to compile %q, err: %v", expr, err)
+		}
+
+		// 结果和错误都应该和不调整顺序时相同
+		for _, vars := range inputs {
+			ret, err := fn.Eval(vars)
+			ret2, err2 := reordered.Eval(vars)
+			if ret != ret2 || (err == nil) != (err2 == nil) || (err != nil && err.Error() != err2.Error()) {
+				t.Fatalf("%q with %v: expect %v, %v, got %v, %v", expr, vars, ret, err, ret2, err2)
+			}
+		}
+	}
+}
+
+func TestReorderKeepLexer(t *testing.T) {
+	lex := NewLexer(`in(a, []int{1, 2}) && b == "y"`)
+	if err := lex.Parse(); err != nil {
+		t.Fatal("faild to call Parse, err:", err)
+	}
+	lex.Params[3] = &Param{Typ: STRING, Val: "x"} // 解析时会拒绝的 "x" == "y"，编译时才报错
+	origin := fmt.Sprint(lex.Params)
+
+	c := NewCompiler(lex)
+	c.Reorder = &CostModel{}
+	_, err := c.Compile()
+	if s := fmt.Sprint(lex.Params); s != origin {
+		t.Fatalf("tokens of lexer should not be modified, got %s", s)
+	}
+
+	// 出错的下标对应调整后的 token 序列
+	var ce *CompileError
+	if !errors.As(err, &ce) || ce.Index < 0 || c.Params()[ce.Index] != ce.Token || ce.Token.Typ != EQL {
+		t.Fatalf("unexpected error %#v", err)
+	}
+	if fmt.Sprint(c.Params()) == origin {
+		t.Fatal("tokens should be reordered")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	tree := mustTree(t, `b == 1 && !in(a, []int{1})`)
+	expect := "&& (cost 2, p 0.25)\n" +
+		"  b == 1 (cost 1, p 0.5)\n" +
+		"  ! (cost 2, p 0.5)\n" +
+		"    in(a, []int{1}) (cost 2, p 0.5)\n"
+	if ret := (&CostModel{}).Explain(tree); ret != expect {
+		t.Fatalf("unexpected explain output:\n%s", ret)
+	}
+}
+
+// 解析表达式并还原出表达式树
+func mustTree(t *testing.T, expr string) *Node {
+	lex := NewLexer(expr)
+	if err := lex.Parse(); err != nil {
+		t.Fatalf("faild to parse %q, err: %v", expr, err)
+	}
+	tree, err := BuildTree(lex.Params)
+	if err != nil {
+		t.Fatalf("failed to build tree, err: %v", err)
+	}
+	return tree
+}
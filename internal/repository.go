@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Repository 某一时刻加载的所有规则，创建后不会再修改，可以在多个 goroutine 中使用
+type Snapshot struct {
+	Version  uint64              // 从 1 开始，每次成功加载后加一
+	LoadedAt time.Time           // 加载的时间
+	Sets     map[string]*RuleSet // 文件名（不含扩展名） -> 文件中的规则
+}
+
+// 获取文件名为 name（不含扩展名）的规则
+func (s *Snapshot) Get(name string) (*RuleSet, bool) {
+	rs, ok := s.Sets[name]
+	return rs, ok
+}
+
+// 文件的修改时间和大小，任何一个变化时认为文件被修改了
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// 放在 atomic.Value 中的错误，atomic.Value 不能保存 nil
+type errBox struct {
+	err error
+}
+
+// 从匹配 pattern 的规则文件中加载规则，文件变化时重新加载，加载成功后原子地替换成新版本，
+// 任何一个文件加载失败时继续使用之前的版本，并记录错误
+type Repository struct {
+	pattern string
+	load    func(path string) (*RuleSet, error)
+
+	mu      sync.Mutex // 同一时间只有一个 Reload 在执行
+	stamps  map[string]fileStamp
+	current atomic.Value // *Snapshot
+	lastErr atomic.Value // errBox
+}
+
+// 创建 Repository 并加载匹配 pattern（filepath.Glob 的格式）的文件，load 负责编译一个文件，第一次加载失败时返回错误
+func NewRepository(pattern string, load func(path string) (*RuleSet, error)) (*Repository, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	r := &Repository{pattern: pattern, load: load}
+	r.lastErr.Store(errBox{})
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 当前版本的规则
+func (r *Repository) Snapshot() *Snapshot {
+	return r.current.Load().(*Snapshot)
+}
+
+// 当前版本号
+func (r *Repository) Version() uint64 {
+	return r.Snapshot().Version
+}
+
+// 最近一次加载的错误，加载成功后清空
+func (r *Repository) LastError() error {
+	return r.lastErr.Load().(errBox).err
+}
+
+// 使用当前版本中文件名为 name 的规则求值
+func (r *Repository) Eval(name string, vars Kv) ([]string, error) {
+	rs, ok := r.Snapshot().Get(name)
+	if !ok {
+		return nil, fmt.Errorf("rule file %q not found", name)
+	}
+	return rs.Eval(vars)
+}
+
+// 检查文件是否有变化（新增、删除或者修改时间、大小变化），有变化时重新加载所有文件，返回是否切换到了新版本；
+// 加载失败时保留之前的版本，文件再次变化之前不会重试
+func (r *Repository) Reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stamps, err := r.scan()
+	if err != nil {
+		return false, r.fail(err)
+	}
+	if r.current.Load() != nil && sameStamps(stamps, r.stamps) {
+		return false, nil
+	}
+	r.stamps = stamps // 先记录文件的状态再加载，加载过程中文件被修改时下一次检查会重新加载
+
+	paths := make([]string, 0, len(stamps))
+	for path := range stamps {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	sets := make(map[string]*RuleSet, len(paths))
+	for _, path := range paths {
+		base := filepath.Base(path)
+		name := strings.TrimSuffix(base, filepath.Ext(base))
+		if _, ok := sets[name]; ok {
+			return false, r.fail(fmt.Errorf("duplicate rule file name %q", name))
+		}
+		rs, err := r.load(path)
+		if err != nil {
+			return false, r.fail(err)
+		}
+		sets[name] = rs
+	}
+
+	var version uint64 = 1
+	if prev, ok := r.current.Load().(*Snapshot); ok {
+		version = prev.Version + 1
+	}
+	r.current.Store(&Snapshot{Version: version, LoadedAt: time.Now(), Sets: sets})
+	r.lastErr.Store(errBox{})
+	return true, nil
+}
+
+// 记录加载失败的错误
+func (r *Repository) fail(err error) error {
+	r.lastErr.Store(errBox{err: err})
+	return err
+}
+
+// 获取所有匹配的文件的状态
+func (r *Repository) scan() (map[string]fileStamp, error) {
+	paths, err := filepath.Glob(r.pattern)
+	if err != nil {
+		return nil, err
+	}
+	stamps := make(map[string]fileStamp, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			continue
+		}
+		stamps[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+func sameStamps(x, y map[string]fileStamp) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for path, s := range x {
+		if t, ok := y[path]; !ok || !s.modTime.Equal(t.modTime) || s.size != t.size {
+			return false
+		}
+	}
+	return true
+}
+
+// 每隔 interval 检查一次文件是否有变化，直到 ctx 被取消，通常在单独的 goroutine 中执行；
+// 加载的错误可以通过 LastError 获取
+func (r *Repository) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Reload()
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 编译规则文件，和 be2fn.LoadRuleFile 相同但不支持编译选项
+func loadRuleFile(path string) (*RuleSet, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defs, err := ParseRuleFile(path, src, Limits{})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	var compilers []*Compiler
+	for _, def := range defs {
+		lex := NewLexer(def.Expr)
+		if err := lex.Parse(); err != nil {
+			return nil, err
+		}
+		names = append(names, def.Name)
+		compilers = append(compilers, NewCompiler(lex))
+	}
+	return CompileRuleSet(names, compilers, false)
+}
+
+func TestRepository(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Now().Add(-time.Hour)
+	write := func(name, src string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime = mtime.Add(time.Second) // 保证修改时间一定变化
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eval := func(r *Repository, name string, vars Kv, want []string) {
+		t.Helper()
+		if ret, err := r.Eval(name, vars); err != nil || !reflect.DeepEqual(ret, want) {
+			t.Fatalf("%s should be %v, got %v, %v", name, want, ret, err)
+		}
+	}
+
+	write("user.be2fn", "adult := age >= 18\n")
+	write("ignored.txt", "not a rule file")
+	r, err := NewRepository(filepath.Join(dir, "*.be2fn"), loadRuleFile)
+	if err != nil {
+		t.Fatal("failed to call NewRepository, err:", err)
+	}
+	if r.Version() != 1 || r.LastError() != nil {
+		t.Fatalf("unexpected status: %d, %v", r.Version(), r.LastError())
+	}
+	eval(r, "user", Kv{"age": 20}, []string{"adult"})
+
+	// 没有变化时不重新加载
+	if changed, err := r.Reload(); changed || err != nil {
+		t.Fatalf("should not reload, got %v, %v", changed, err)
+	}
+
+	// 修改和新增文件
+	write("user.be2fn", "adult := age >= 21\n")
+	write("geo.be2fn", "us := country == \"US\"\n")
+	if changed, err := r.Reload(); !changed || err != nil || r.Version() != 2 {
+		t.Fatalf("should reload, got %v, %v, version %d", changed, err, r.Version())
+	}
+	eval(r, "user", Kv{"age": 20}, nil)
+	eval(r, "geo", Kv{"country": "US"}, []string{"us"})
+
+	// 加载失败时继续使用之前的版本
+	old := r.Snapshot()
+	write("geo.be2fn", "us := us\n")
+	if changed, err := r.Reload(); changed || err == nil {
+		t.Fatalf("should fail, got %v, %v", changed, err)
+	}
+	if r.Snapshot() != old || r.LastError() == nil {
+		t.Fatalf("should keep version 2, got %d, %v", r.Version(), r.LastError())
+	}
+	eval(r, "geo", Kv{"country": "US"}, []string{"us"})
+	if changed, err := r.Reload(); changed || err != nil { // 文件没有再次变化，不会重试
+		t.Fatalf("should not retry, got %v, %v", changed, err)
+	}
+
+	// 修复后恢复
+	write("geo.be2fn", "us := country == \"US\" || country == \"CA\"\n")
+	if changed, err := r.Reload(); !changed || err != nil || r.Version() != 3 || r.LastError() != nil {
+		t.Fatalf("should reload, got %v, %v, version %d, last error %v", changed, err, r.Version(), r.LastError())
+	}
+	eval(r, "geo", Kv{"country": "CA"}, []string{"us"})
+
+	// 删除文件
+	if err := os.Remove(filepath.Join(dir, "geo.be2fn")); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := r.Reload(); !changed || err != nil {
+		t.Fatalf("should reload, got %v, %v", changed, err)
+	}
+	if _, err := r.Eval("geo", Kv{}); err == nil {
+		t.Fatal("geo should be removed")
+	}
+
+	// 第一次加载失败时返回错误
+	write("bad.be2fn", "a :=")
+	if _, err := NewRepository(filepath.Join(dir, "*.be2fn"), loadRuleFile); err == nil {
+		t.Fatal("should return error of bad.be2fn")
+	}
+}
+
+func TestRepositoryWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.be2fn")
+	if err := os.WriteFile(path, []byte("r := x > 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRepository(filepath.Join(dir, "*.be2fn"), loadRuleFile)
+	if err != nil {
+		t.Fatal("failed to call NewRepository, err:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.Watch(ctx, time.Millisecond)
+	}()
+
+	// 切换版本的同时求值
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if _, err := r.Eval("a", Kv{"x": 1}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	if err := os.WriteFile(path, []byte("r := x > 0 && x < 100\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Version() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+
+	if r.Version() != 2 {
+		t.Fatalf("should reload in background, got version %d", r.Version())
+	}
+}
@@ -0,0 +1,283 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+// 规则文件中的错误，指向出错的文件和位置
+type FileError struct {
+	File   string
+	Line   int
+	Column int // 为 0 时表示只能定位到行
+	Err    error
+}
+
+func (e *FileError) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %v", e.File, e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// 规则文件中的一个定义
+type RuleDef struct {
+	Name string
+	Expr string // 引用的其他定义已经展开成带括号的表达式
+	Line int    // 定义所在的行
+}
+
+// 没有设置 Limits.MaxSourceLen 时，规则文件中的定义展开引用后的最大字节数
+const DefaultMaxRuleLen = 1 << 20
+
+// 定义的开头：`name := expr`
+var ruleDefPattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*:=(.*)$`)
+
+// 解析中的定义
+type ruleDecl struct {
+	RuleDef
+	src  string    // 多行的定义用空格连接成一行
+	segs []segment // src 中每一段在文件中的位置
+	refs []ruleRef // 引用的其他定义，按在 src 中的位置排列
+}
+
+// src 中从 off 开始的一段来自文件中的第 line 行第 col 列
+type segment struct {
+	off, line, col int
+}
+
+// 对其他定义的引用
+type ruleRef struct {
+	name     string
+	pos, end int // 在 src 中的位置
+}
+
+// 解析规则文件，每个定义形如 `name := expr`，以空白开头的行是上一个定义的延续，`#` 或 `//` 开头的行是注释；
+// 定义的名字出现在需要布尔表达式的位置（&&、|| 和 ! 的操作数，或者整个表达式）时表示引用这个定义，
+// 引用会被展开成带括号的表达式，所以引用的定义在编译时会被内联到闭包树中；
+// 展开后的表达式按照 limits 检查，展开的过程中长度超过 limits.MaxSourceLen（为 0 时为 DefaultMaxRuleLen）时报告在引用的位置，
+// 避免 `b := a && a`、`c := b && b` 这样层层引用的定义指数级膨胀；
+// 返回的定义按照在文件中的顺序排列，出错时返回 *FileError
+func ParseRuleFile(filename string, src []byte, limits Limits) ([]RuleDef, error) {
+	decls, err := splitRuleFile(filename, src)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*ruleDecl, len(decls))
+	for _, d := range decls {
+		if prev, ok := byName[d.Name]; ok {
+			return nil, &FileError{File: filename, Line: d.Line, Err: fmt.Errorf("%s redeclared, previous declaration at line %d", d.Name, prev.Line)}
+		}
+		byName[d.Name] = d
+	}
+
+	for _, d := range decls {
+		if err := d.parse(filename, byName); err != nil {
+			return nil, err
+		}
+	}
+
+	maxLen := limits.MaxSourceLen
+	if maxLen <= 0 {
+		maxLen = DefaultMaxRuleLen
+	}
+
+	// 按照依赖关系展开引用，被依赖的定义先展开，同时检查循环引用
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(decls))
+	var order []*ruleDecl
+	var path []string
+	var visit func(d *ruleDecl) error
+	visit = func(d *ruleDecl) error {
+		state[d.Name] = visiting
+		path = append(path, d.Name)
+		for _, ref := range d.refs {
+			switch state[ref.name] {
+			case visiting:
+				cycle := append(path[indexOf(path, ref.name):], ref.name)
+				return d.errorAt(filename, ref.pos, fmt.Errorf("reference cycle: %s", strings.Join(cycle, " -> ")))
+			case unvisited:
+				if err := visit(byName[ref.name]); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[d.Name] = done
+
+		expr, err := d.expand(filename, byName, maxLen)
+		if err != nil {
+			return err
+		}
+		d.Expr = expr
+		order = append(order, d)
+		return nil
+	}
+	for _, d := range decls {
+		if state[d.Name] == unvisited {
+			if err := visit(d); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// 按照依赖顺序检查，错误会报告在真正出错的定义上
+	for _, d := range order {
+		lexer := NewLexer(d.Expr)
+		lexer.Limits = limits
+		if err := lexer.Parse(); err != nil {
+			return nil, &FileError{File: filename, Line: d.Line, Err: fmt.Errorf("%s: %w", d.Name, err)}
+		}
+	}
+
+	defs := make([]RuleDef, 0, len(decls))
+	for _, d := range decls {
+		defs = append(defs, d.RuleDef)
+	}
+	return defs, nil
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// 把文件拆分成定义
+func splitRuleFile(filename string, src []byte) ([]*ruleDecl, error) {
+	var decls []*ruleDecl
+	for i, line := range strings.Split(string(src), "\n") {
+		lineNo := i + 1
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//") {
+			continue
+		}
+
+		if trimmed != line { // 上一个定义的延续
+			if len(decls) == 0 {
+				return nil, &FileError{File: filename, Line: lineNo, Err: errors.New("unexpected indentation")}
+			}
+			d := decls[len(decls)-1]
+			d.src += " "
+			d.segs = append(d.segs, segment{off: len(d.src), line: lineNo, col: len(line) - len(trimmed) + 1})
+			d.src += trimmed
+			continue
+		}
+
+		m := ruleDefPattern.FindStringSubmatchIndex(line)
+		if m == nil {
+			return nil, &FileError{File: filename, Line: lineNo, Err: errors.New("expected `name := expr`")}
+		}
+		expr := strings.TrimLeft(line[m[4]:], " \t")
+		d := &ruleDecl{RuleDef: RuleDef{Name: line[m[2]:m[3]], Line: lineNo}, src: expr}
+		d.segs = []segment{{off: 0, line: lineNo, col: len(line) - len(expr) + 1}}
+		decls = append(decls, d)
+	}
+	return decls, nil
+}
+
+// 解析定义中的表达式，找出对其他定义的引用
+func (d *ruleDecl) parse(filename string, byName map[string]*ruleDecl) error {
+	if strings.TrimSpace(d.src) == "" {
+		return &FileError{File: filename, Line: d.Line, Err: fmt.Errorf("%s: missing expression", d.Name)}
+	}
+
+	// 和 Lexer 一样先把具名中缀运算符替换成等长的 `==`，替换不改变位置，错误和引用的位置仍然对应 d.src
+	src, infix := translateInfix(d.src)
+	fset := token.NewFileSet()
+	expr, err := parser.ParseExprFrom(fset, "", src, 0)
+	if err != nil {
+		var list scanner.ErrorList
+		if errors.As(err, &list) && len(list) > 0 {
+			return d.errorAt(filename, list[0].Pos.Offset, errors.New(list[0].Msg))
+		}
+		return &FileError{File: filename, Line: d.Line, Err: err}
+	}
+
+	base := fset.File(expr.Pos()).Base()
+	var walk func(e ast.Expr)
+	walk = func(e ast.Expr) {
+		switch e := e.(type) {
+		case *ast.ParenExpr:
+			walk(e.X)
+		case *ast.UnaryExpr:
+			if e.Op == token.NOT {
+				walk(e.X)
+			}
+		case *ast.BinaryExpr:
+			if _, ok := infix[e.OpPos]; ok { // 具名中缀运算符是比较，操作数不是引用
+				return
+			}
+			if e.Op == token.LAND || e.Op == token.LOR {
+				walk(e.X)
+				walk(e.Y)
+			}
+		case *ast.Ident: // 比较运算符和函数的操作数是变量，不会走到这里
+			if _, ok := byName[e.Name]; ok {
+				pos := int(e.Pos()) - base
+				d.refs = append(d.refs, ruleRef{name: e.Name, pos: pos, end: pos + len(e.Name)})
+			}
+		}
+	}
+	walk(expr)
+	return nil
+}
+
+// 把引用替换成带括号的表达式，引用的定义需要已经展开；
+// 展开后的长度超过 maxLen 时返回指向使长度超出的引用的错误
+func (d *ruleDecl) expand(filename string, byName map[string]*ruleDecl, maxLen int) (string, error) {
+	n := len(d.src)
+	for _, ref := range d.refs {
+		n += len(byName[ref.name].Expr) + 2 - (ref.end - ref.pos)
+		if n > maxLen {
+			return "", d.errorAt(filename, ref.pos, limitError("expanding %s makes %s longer than %d", ref.name, d.Name, maxLen))
+		}
+	}
+	if n > maxLen {
+		return "", &FileError{File: filename, Line: d.Line, Err: limitError("source length %d > %d", n, maxLen)}
+	}
+	if len(d.refs) == 0 {
+		return d.src, nil
+	}
+
+	var b strings.Builder
+	b.Grow(n)
+	last := 0
+	for _, ref := range d.refs {
+		b.WriteString(d.src[last:ref.pos])
+		b.WriteString("(" + byName[ref.name].Expr + ")")
+		last = ref.end
+	}
+	b.WriteString(d.src[last:])
+	return b.String(), nil
+}
+
+// 生成指向 src 中 off 处的错误
+func (d *ruleDecl) errorAt(filename string, off int, err error) error {
+	seg := d.segs[0]
+	for _, s := range d.segs {
+		if s.off <= off {
+			seg = s
+		}
+	}
+	return &FileError{File: filename, Line: seg.line, Column: seg.col + off - seg.off, Err: err}
+}
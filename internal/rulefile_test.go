@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseRuleFile(t *testing.T) {
+	const src = `# 用户相关
+is_adult := age >= 18
+is_us := country == "US"
+
+// 多行的定义
+eligible := is_adult
+    && (is_us || in(country, []string{"CA"}))
+    && !blocked
+blocked := blocked == true || is_adult && score < 0
+`
+	defs, err := ParseRuleFile("rules.be2fn", []byte(src), Limits{})
+	if err != nil {
+		t.Fatal("failed to call ParseRuleFile, err:", err)
+	}
+	want := []RuleDef{
+		{Name: "is_adult", Expr: "age >= 18", Line: 2},
+		{Name: "is_us", Expr: `country == "US"`, Line: 3},
+		{Name: "eligible", Expr: `(age >= 18) && ((country == "US") || in(country, []string{"CA"})) && !(blocked == true || (age >= 18) && score < 0)`, Line: 6},
+		{Name: "blocked", Expr: "blocked == true || (age >= 18) && score < 0", Line: 9},
+	}
+	if !reflect.DeepEqual(defs, want) {
+		t.Fatalf("should be %+v, got %+v", want, defs)
+	}
+
+	// 展开后的表达式和直接写出来的结果相同
+	fn, err := NewCompiler(mustLexer(t, defs[2].Expr)).Compile()
+	if err != nil {
+		t.Fatal("failed to call Compile, err:", err)
+	}
+	if ret, err := fn.Eval(Kv{"age": 20, "country": "CA", "blocked": false, "score": 1}); !ret || err != nil {
+		t.Fatalf("should be true, got %v, %v", ret, err)
+	}
+}
+
+func TestParseRuleFileInfix(t *testing.T) {
+	registerMatches(t)
+
+	const src = `matches := name matches "^ab"
+named := matches
+    && "c$" matches name
+`
+	defs, err := ParseRuleFile("rules.be2fn", []byte(src), Limits{})
+	if err != nil {
+		t.Fatal("failed to call ParseRuleFile, err:", err)
+	}
+	want := []RuleDef{
+		{Name: "matches", Expr: `name matches "^ab"`, Line: 1},
+		{Name: "named", Expr: `(name matches "^ab") && "c$" matches name`, Line: 2},
+	}
+	if !reflect.DeepEqual(defs, want) {
+		t.Fatalf("should be %+v, got %+v", want, defs)
+	}
+
+	fn, err := NewCompiler(mustLexer(t, defs[1].Expr)).Compile()
+	if err != nil {
+		t.Fatal("failed to call Compile, err:", err)
+	}
+	if ret, err := fn.Eval(Kv{"name": "abc"}); !ret || err != nil {
+		t.Fatalf("should be true, got %v, %v", ret, err)
+	}
+
+	// 错误的位置仍然指向文件中的原文
+	_, err = ParseRuleFile("f", []byte("a := x == 1\n  && name matches"), Limits{})
+	if err == nil || err.Error() != "f:2:18: expected operand, found 'EOF'" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestParseRuleFileError(t *testing.T) {
+	cases := []struct {
+		Src string
+		Err string
+	}{
+		{"  a := b == 1", "f:1: unexpected indentation"},
+		{"a == 1", "f:1: expected `name := expr`"},
+		{"a :=", "f:1: a: missing expression"},
+		{"a := x == 1\na := x == 2", "f:2: a redeclared, previous declaration at line 1"},
+		{"a := x == 1 &&", "f:1:15: expected operand, found 'EOF'"},
+		{"a := x == 1\n  && (y == 2", "f:2:13: expected ')', found newline"},
+		{"a := b && x == 1\nb := c\nc := x > 1 || a", "f:3:15: reference cycle: a -> b -> c -> a"},
+		{"a := a", "f:1:6: reference cycle: a -> a"},
+		{"a := b && x == 1\nb := x + 1", "f:2: b: `+` can only be used for time arithmetic, err at 3"},
+		{"a := x == 1 && y", "f:1: a: `&&`'s subExpr must be bool expr, got bool expr and ident, err at 8"},
+	}
+	for _, c := range cases {
+		_, err := ParseRuleFile("f", []byte(c.Src), Limits{})
+		var fe *FileError
+		if !errors.As(err, &fe) || err.Error() != c.Err {
+			t.Fatalf("src %q should return %q, got %v", c.Src, c.Err, err)
+		}
+	}
+}
+
+func TestParseRuleFileLimit(t *testing.T) {
+	// 每个定义引用两次上一个定义，展开后的长度指数级增长
+	src := "r0 := x == 1\n"
+	for i := 1; i <= 64; i++ {
+		src += fmt.Sprintf("r%d := r%d && r%d\n", i, i-1, i-1)
+	}
+
+	cases := []struct {
+		Limits Limits
+		Err    string
+	}{
+		{Limits{MaxSourceLen: 100}, "f:4:13: compile limit exceeded: expanding r2 makes r3 longer than 100"},
+		{Limits{}, fmt.Sprintf("f:18:15: compile limit exceeded: expanding r16 makes r17 longer than %d", DefaultMaxRuleLen)},
+	}
+	for _, c := range cases {
+		_, err := ParseRuleFile("f", []byte(src), c.Limits)
+		var fe *FileError
+		if !errors.As(err, &fe) || !errors.Is(err, ErrLimitExceeded) || err.Error() != c.Err {
+			t.Fatalf("limits %+v should return %q, got %v", c.Limits, c.Err, err)
+		}
+	}
+
+	// 其他限制同样对展开后的表达式生效
+	_, err := ParseRuleFile("f", []byte("a := x == 1\nb := a && a && a"), Limits{MaxTokens: 10})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("should exceed token limit, got %v", err)
+	}
+}
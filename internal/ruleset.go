@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RuleSet 中某条规则求值出错时的错误
+type RuleError struct {
+	Name string // 规则名
+	Err  error
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("rule %s: %v", e.Name, e.Err)
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+// RuleSet 求值时出错的所有规则
+type RuleErrors []*RuleError
+
+func (es RuleErrors) Error() string {
+	msgs := make([]string, 0, len(es))
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// 一组一起求值的规则，编译时相同的子表达式在所有规则之间共享，
+// 一次求值中每个不同的子表达式和变量查找最多只执行一次
+type RuleSet struct {
+	names []string
+	units []Unit
+	index *ruleIndex // 不为 nil 时只执行索引选出的规则
+}
+
+// 编译一组规则，compilers 和 names 一一对应，Compiler 的设置（时钟、代价模型等）需要在调用前完成，
+// 规则之间共享相同的子表达式，所以各个 Compiler 的 Operators 需要相同；
+// index 为 true 时根据规则中顶层的 `变量 == 常量` 和 `in(变量, 切片)` 条件建立索引，
+// 求值时跳过这个条件不成立的规则，这些规则中其他条件出的错不会再返回
+func CompileRuleSet(names []string, compilers []*Compiler, index bool) (*RuleSet, error) {
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate rule name %q", name)
+		}
+		seen[name] = true
+	}
+
+	t := newCSETable()
+	trees := make([]*Node, 0, len(compilers))
+	for _, c := range compilers {
+		root := c.countCSE(t)
+		if !BuiltinOperators(c.params, c.Operators) { // 索引基于内置 == 的语义
+			root = nil
+		}
+		trees = append(trees, root)
+	}
+
+	rs := &RuleSet{names: names, units: make([]Unit, 0, len(compilers))}
+	for i, c := range compilers {
+		c.cse = t
+		u, err := c.Compile()
+		if err != nil {
+			return nil, &RuleError{Name: names[i], Err: err}
+		}
+		rs.units = append(rs.units, u)
+	}
+	if index {
+		rs.index = buildRuleIndex(trees)
+	}
+	return rs, nil
+}
+
+// 规则名，顺序和编译时相同
+func (rs *RuleSet) Names() []string {
+	return rs.names
+}
+
+// 使用默认的求值上下文执行所有规则，返回成立的规则名
+func (rs *RuleSet) Eval(vars Kv) ([]string, error) {
+	return rs.EvalEnv(NewEnv(vars))
+}
+
+// 执行所有规则，每个子表达式执行前都会检查 ctx 是否已经被取消，WithStepBudget 设置的步数上限对所有规则一起生效
+func (rs *RuleSet) EvalContext(ctx context.Context, vars Kv) ([]string, error) {
+	return rs.EvalEnv(&Env{Vars: vars, Ctx: ctx, MaxSteps: StepBudget(ctx)})
+}
+
+// 执行所有规则，返回成立的规则名，顺序和编译时相同；
+// 某条规则出错时跳过它继续执行，最后返回 RuleErrors，
+// ctx 被取消或超过步数上限时直接返回，此时只包含已经执行的规则中成立的规则
+func (rs *RuleSet) EvalEnv(env *Env) ([]string, error) {
+	env.reset()
+
+	e := &ruleSetEval{rs: rs, env: env}
+	if rs.index == nil {
+		for i := range rs.units {
+			if err := e.eval(i); err != nil {
+				return e.matched, err
+			}
+		}
+	} else {
+		for _, i := range rs.index.candidates(env.Vars) {
+			if err := e.eval(i); err != nil {
+				return e.matched, err
+			}
+		}
+	}
+
+	if len(e.errs) > 0 {
+		return e.matched, e.errs
+	}
+	return e.matched, nil
+}
+
+// 一次求值的状态
+type ruleSetEval struct {
+	rs      *RuleSet
+	env     *Env
+	matched []string
+	errs    RuleErrors
+}
+
+// 执行第 i 条规则，需要中止求值时返回错误
+func (e *ruleSetEval) eval(i int) error {
+	if err := e.env.step(); err != nil {
+		return err
+	}
+
+	ok, err := e.rs.units[i](e.env)
+	switch {
+	case err != nil && (errors.Is(err, ErrStepBudgetExceeded) || e.env.Err() != nil):
+		return err
+	case err != nil:
+		e.errs = append(e.errs, &RuleError{Name: e.rs.names[i], Err: err})
+	case ok:
+		e.matched = append(e.matched, e.rs.names[i])
+	}
+	return nil
+}
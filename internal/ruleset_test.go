@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// 编译一组规则，rules 中依次为规则名和表达式
+func mustRuleSet(t *testing.T, index bool, rules ...string) *RuleSet {
+	t.Helper()
+
+	var names []string
+	var compilers []*Compiler
+	for i := 0; i < len(rules); i += 2 {
+		names = append(names, rules[i])
+		compilers = append(compilers, NewCompiler(mustLexer(t, rules[i+1])))
+	}
+	rs, err := CompileRuleSet(names, compilers, index)
+	if err != nil {
+		t.Fatal("failed to call CompileRuleSet, err:", err)
+	}
+	return rs
+}
+
+func TestRuleSet(t *testing.T) {
+	rules := []string{
+		"adult", `age >= 18`,
+		"eu_adult", `region == "EU" && age >= 18`,
+		"eu_vip", `region == "EU" && in(level, []int{3, 4})`,
+		"not_eu", `!(region == "EU")`,
+	}
+	rs := mustRuleSet(t, false, rules...)
+	if names := rs.Names(); !reflect.DeepEqual(names, []string{"adult", "eu_adult", "eu_vip", "not_eu"}) {
+		t.Fatalf("unexpected names: %v", names)
+	}
+
+	cases := []struct {
+		arg Kv
+		ret []string
+	}{
+		{arg: Kv{"age": 20, "region": "EU", "level": 3}, ret: []string{"adult", "eu_adult", "eu_vip"}},
+		{arg: Kv{"age": 10, "region": "EU", "level": 1}, ret: nil},
+		{arg: Kv{"age": 20, "region": "US", "level": 4}, ret: []string{"adult", "not_eu"}},
+	}
+	for _, c := range cases {
+		ret, err := rs.Eval(c.arg)
+		if err != nil || !reflect.DeepEqual(ret, c.ret) {
+			t.Fatalf("arg: %v, should be %v, got %v, %v", c.arg, c.ret, ret, err)
+		}
+
+		// 结果和单独编译每条规则时相同
+		var want []string
+		for i := 0; i < len(rules); i += 2 {
+			fn, err := NewCompiler(mustLexer(t, rules[i+1])).Compile()
+			if err != nil {
+				t.Fatal("failed to call Compile, err:", err)
+			}
+			if ok, _ := fn.Eval(c.arg); ok {
+				want = append(want, rules[i])
+			}
+		}
+		if !reflect.DeepEqual(ret, want) {
+			t.Fatalf("arg: %v, should match single rules %v, got %v", c.arg, want, ret)
+		}
+	}
+}
+
+func TestRuleSetShare(t *testing.T) {
+	// 统计 region == "EU" 的执行次数
+	count := 0
+	origin, _ := LookupOperator(EQL)
+	defer restoreOperator(EQL, origin)
+	opFuncs := origin
+	opFuncs.VarToStr = func(varname string, val string) Unit {
+		u := origin.VarToStr(varname, val)
+		return func(env *Env) (bool, error) {
+			count++
+			return u(env)
+		}
+	}
+	if err := RegisterOperator(EQL, opFuncs); err != nil {
+		t.Fatal("failed to call RegisterOperator, err:", err)
+	}
+
+	// 每条规则中 region == "EU" 只出现一次，但在规则之间是重复的
+	rs := mustRuleSet(t, false,
+		"a", `region == "EU" && a > 1`,
+		"b", `region == "EU" && b > 1`,
+		"c", `"EU" == region || a < 0`,
+	)
+	env := NewEnv(Kv{"region": "EU", "a": 2, "b": 0})
+	for i := 1; i <= 2; i++ {
+		ret, err := rs.EvalEnv(env)
+		if err != nil || !reflect.DeepEqual(ret, []string{"a", "c"}) {
+			t.Fatalf("should be [a c], got %v, %v", ret, err)
+		}
+		if count != i {
+			t.Fatalf("region == \"EU\" should run once per evaluation, got %d", count)
+		}
+	}
+}
+
+func TestRuleSetError(t *testing.T) {
+	rs := mustRuleSet(t, false,
+		"a", `a > 1`,
+		"b", `b == "x"`,
+		"c", `c > 1`,
+	)
+
+	// 出错的规则被跳过，其他规则照常执行
+	ret, err := rs.Eval(Kv{"a": 2, "c": 2})
+	if !reflect.DeepEqual(ret, []string{"a", "c"}) {
+		t.Fatalf("should be [a c], got %v", ret)
+	}
+	var errs RuleErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Name != "b" {
+		t.Fatalf("should return error of rule b, got %v", err)
+	}
+	var ruleErr *RuleError
+	if !errors.As(errs[0], &ruleErr) || errors.Unwrap(ruleErr) == nil {
+		t.Fatalf("should wrap the original error, got %v", errs[0])
+	}
+
+	// 超过步数上限时直接返回
+	ctx := WithStepBudget(context.Background(), 2)
+	if _, err := rs.EvalContext(ctx, Kv{"a": 2, "b": "x", "c": 2}); err != ErrStepBudgetExceeded {
+		t.Fatalf("should return ErrStepBudgetExceeded, got %v", err)
+	}
+
+	// 规则名不能重复
+	names := []string{"a", "a"}
+	compilers := []*Compiler{NewCompiler(mustLexer(t, `a > 1`)), NewCompiler(mustLexer(t, `a > 2`))}
+	if _, err := CompileRuleSet(names, compilers, false); err == nil {
+		t.Fatal("should reject duplicate rule names")
+	}
+
+	// 编译失败时返回出错的规则名
+	compilers = []*Compiler{NewCompiler(mustLexer(t, `a > 1`)), NewCompiler(&Lexer{Params: []*Param{{Typ: IDENT, Val: "a"}}})}
+	_, err = CompileRuleSet([]string{"a", "b"}, compilers, false)
+	if !errors.As(err, &ruleErr) || ruleErr.Name != "b" {
+		t.Fatalf("should return error of rule b, got %v", err)
+	}
+}
@@ -0,0 +1,341 @@
+package internal
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 决策表中的一行
+type TableRow struct {
+	Line   int    // 在 CSV 中的行号
+	Expr   string // 所有条件组成的表达式，为空时表示任何输入都满足
+	Result string // 结果列的值
+}
+
+// 行在 RuleSet 中的规则名
+func (r TableRow) Name() string {
+	return "line " + strconv.Itoa(r.Line)
+}
+
+// 合法的变量名，可以用 `.` 连接多级
+var varNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// 从 CSV 中读取决策表，第一行是表头，resultColumn 为结果列的列名，为空时使用最后一列，其他列的列名为变量名；
+// 每行的条件单元格转换成表达式后用 && 连接
+func ParseDecisionTable(r io.Reader, resultColumn string) ([]TableRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty decision table")
+	} else if err != nil {
+		return nil, err
+	}
+
+	result := len(header) - 1
+	if resultColumn != "" {
+		result = -1
+		for i, name := range header {
+			if strings.TrimSpace(name) == resultColumn {
+				result = i
+				break
+			}
+		}
+		if result < 0 {
+			return nil, fmt.Errorf("result column %q not found", resultColumn)
+		}
+	}
+	for i, name := range header {
+		if i != result && !varNamePattern.MatchString(strings.TrimSpace(name)) {
+			return nil, fmt.Errorf("line 1: invalid variable name %q", name)
+		}
+	}
+
+	var rows []TableRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := TableRow{Line: line, Result: strings.TrimSpace(record[result])}
+		var conds []string
+		for i, cell := range record {
+			if i == result {
+				continue
+			}
+			name := strings.TrimSpace(header[i])
+			cond, err := CellExpr(name, cell)
+			if err != nil {
+				return nil, fmt.Errorf("line %d, column %s: %w", line, name, err)
+			}
+			if cond != "" {
+				conds = append(conds, cond)
+			}
+		}
+		row.Expr = strings.Join(conds, " && ")
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// 把决策表的单元格转换成变量 name 上的条件：
+//
+//	-、空白            任意值，返回空字符串
+//	> 18、!= "x"       比较运算符后跟一个值
+//	in ("US", "CA")    在列表中，not in (...) 表示不在列表中
+//	"US"、18、true     等于这个值
+//
+// 值可以是整数、带引号的字符串、true/false 或者 time("...")、now() 这样的表达式，其他不带引号的值当作字符串；
+// 值中不能出现 && 和 ||，否则和同一行的其他条件连接后优先级会改变
+func CellExpr(name, cell string) (string, error) {
+	cell = strings.TrimSpace(cell)
+	if cell == "" || cell == "-" {
+		return "", nil
+	}
+
+	for _, op := range []string{"==", "!=", ">=", "<=", ">", "<"} {
+		if strings.HasPrefix(cell, op) {
+			val, err := cellValue(cell[len(op):])
+			if err != nil {
+				return "", err
+			}
+			return name + " " + op + " " + val, nil
+		}
+	}
+
+	if list, ok := cutKeyword(cell, "not in"); ok {
+		slice, err := cellList(list)
+		if err != nil {
+			return "", err
+		}
+		return "!in(" + name + ", " + slice + ")", nil
+	}
+	if list, ok := cutKeyword(cell, "in"); ok {
+		slice, err := cellList(list)
+		if err != nil {
+			return "", err
+		}
+		return "in(" + name + ", " + slice + ")", nil
+	}
+
+	val, err := cellValue(cell)
+	if err != nil {
+		return "", err
+	}
+	return name + " == " + val, nil
+}
+
+// cell 以关键字 kw 开头，并且后面跟着空白或 `(` 时返回剩下的部分，不区分大小写
+func cutKeyword(cell, kw string) (string, bool) {
+	if len(cell) <= len(kw) || !strings.EqualFold(cell[:len(kw)], kw) {
+		return "", false
+	}
+	rest := cell[len(kw):]
+	if rest[0] != ' ' && rest[0] != '\t' && rest[0] != '(' {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// 比较运算符后面的值
+func cellValue(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return "", errors.New("missing value")
+	case s == "true" || s == "false":
+		return s, nil
+	case strings.ContainsAny(s, `"(`): // 字符串和表达式交给 Lexer 检查
+		return s, checkCellValue(s)
+	}
+	if _, err := strconv.Atoi(s); err == nil {
+		return s, nil
+	}
+	if strings.ContainsAny(s[:1], "0123456789+-.'`") { // 小数、字符这样 Lexer 不支持的字面量，不能当作字符串
+		return "", fmt.Errorf("unsupported value %q, numbers must be integers and strings must use double quotes", s)
+	}
+	return strconv.Quote(s), nil
+}
+
+// 检查交给 Lexer 的值中没有 && 和 ||，并且括号是配对的，保证值和其他条件连接后仍然是一个整体
+func checkCellValue(s string) error {
+	var sc scanner.Scanner
+	var scanErr error
+	src := []byte(s)
+	fset := token.NewFileSet()
+	sc.Init(fset.AddFile("", -1, len(src)), src, func(_ token.Position, msg string) {
+		scanErr = errors.New(msg)
+	}, 0)
+
+	depth := 0
+	for {
+		_, tok, _ := sc.Scan()
+		switch tok {
+		case token.EOF:
+			if scanErr != nil {
+				return scanErr
+			}
+			if depth != 0 {
+				return fmt.Errorf("unbalanced parentheses in %q", s)
+			}
+			return nil
+		case token.LAND, token.LOR:
+			return fmt.Errorf("value %q must not contain `%s`", s, tok)
+		case token.LPAREN:
+			depth++
+		case token.RPAREN:
+			if depth--; depth < 0 {
+				return fmt.Errorf("unbalanced parentheses in %q", s)
+			}
+		}
+	}
+}
+
+// 把 ("US", "CA")、(1, 2) 这样的列表转换成切片
+func cellList(s string) (string, error) {
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return "", fmt.Errorf("list must be enclosed in parentheses, got %q", s)
+	}
+
+	var sc scanner.Scanner
+	var scanErr error
+	src := []byte(s[1 : len(s)-1])
+	fset := token.NewFileSet()
+	sc.Init(fset.AddFile("", -1, len(src)), src, func(_ token.Position, msg string) {
+		scanErr = errors.New(msg)
+	}, 0)
+
+	var ints, strs []string
+	neg := false
+	for expectElem := true; ; {
+		_, tok, lit := sc.Scan()
+		switch {
+		case tok == token.EOF || tok == token.SEMICOLON && lit == "\n": // 扫描到结尾时会自动插入分号
+			if scanErr != nil {
+				return "", scanErr
+			}
+			if expectElem && (len(ints) > 0 || len(strs) > 0) {
+				return "", errors.New("missing value after `,`")
+			}
+			if len(ints) > 0 && len(strs) > 0 {
+				return "", errors.New("list mixes numbers and strings")
+			}
+			if len(strs) > 0 {
+				return "[]string{" + strings.Join(strs, ", ") + "}", nil
+			}
+			return "[]int{" + strings.Join(ints, ", ") + "}", nil
+
+		case tok == token.COMMA && !expectElem:
+			expectElem = true
+
+		case tok == token.SUB && expectElem && !neg:
+			neg = true
+
+		case tok == token.INT && expectElem:
+			if neg {
+				lit = "-" + lit
+			}
+			ints = append(ints, lit)
+			expectElem, neg = false, false
+
+		case (tok == token.STRING || tok == token.IDENT) && expectElem && !neg:
+			if tok == token.IDENT { // 不带引号的值当作字符串
+				lit = strconv.Quote(lit)
+			}
+			strs = append(strs, lit)
+			expectElem = false
+
+		default:
+			return "", fmt.Errorf("invalid list %q", s)
+		}
+	}
+}
+
+// 决策表的匹配方式
+type TableMode int
+
+const (
+	FirstMatch TableMode = iota // 返回第一行成立的结果
+	CollectAll                  // 返回所有成立的行的结果
+)
+
+// 编译好的决策表
+type DecisionTable struct {
+	rows  []TableRow
+	list  *DecisionList  // FirstMatch 时使用
+	set   *RuleSet       // CollectAll 时使用，规则名为 TableRow.Name()
+	index map[string]int // 规则名 -> 行在 rows 中的下标
+}
+
+// 创建按顺序返回第一行成立的结果的决策表，list 中的规则和 rows 一一对应
+func NewFirstMatchTable(rows []TableRow, list *DecisionList) *DecisionTable {
+	return &DecisionTable{rows: rows, list: list}
+}
+
+// 创建返回所有成立的行的结果的决策表，set 中的规则名为 TableRow.Name()
+func NewCollectAllTable(rows []TableRow, set *RuleSet) *DecisionTable {
+	index := make(map[string]int, len(rows))
+	for i, row := range rows {
+		index[row.Name()] = i
+	}
+	return &DecisionTable{rows: rows, set: set, index: index}
+}
+
+// 所有行
+func (t *DecisionTable) Rows() []TableRow {
+	return t.rows
+}
+
+// 使用默认的求值上下文执行决策表
+func (t *DecisionTable) Eval(vars Kv) ([]string, error) {
+	return t.EvalEnv(NewEnv(vars))
+}
+
+// 执行决策表，每个子表达式执行前都会检查 ctx 是否已经被取消
+func (t *DecisionTable) EvalContext(ctx context.Context, vars Kv) ([]string, error) {
+	return t.EvalEnv(&Env{Vars: vars, Ctx: ctx, MaxSteps: StepBudget(ctx)})
+}
+
+// 执行决策表，返回成立的行的结果，FirstMatch 时最多只有一个；
+// FirstMatch 时某行出错会直接返回，CollectAll 时跳过出错的行，和 RuleSet 一样最后返回 RuleErrors
+func (t *DecisionTable) EvalEnv(env *Env) ([]string, error) {
+	if t.list != nil {
+		i, err := t.list.Match(env)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("line %d: %w", t.rows[i].Line, err)
+		case i < 0:
+			return nil, nil
+		default:
+			return []string{t.rows[i].Result}, nil
+		}
+	}
+
+	names, err := t.set.EvalEnv(env)
+	var results []string
+	for _, name := range names {
+		results = append(results, t.rows[t.index[name]].Result)
+	}
+	return results, err
+}
+
+// FirstMatch 时检查被前面更宽泛的行遮蔽的行，Shadow 中的下标为行在 Rows() 中的下标；CollectAll 时返回 nil
+func (t *DecisionTable) Shadowed() []Shadow {
+	if t.list == nil {
+		return nil
+	}
+	return t.list.Shadowed()
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCellExpr(t *testing.T) {
+	cases := []struct {
+		Cell string
+		Expr string
+	}{
+		{"-", ""},
+		{"  ", ""},
+		{"> 18", "age > 18"},
+		{">=-1", "age >= -1"},
+		{`!= "x"`, `age != "x"`},
+		{"== true", "age == true"},
+		{"18", "age == 18"},
+		{`"US"`, `age == "US"`},
+		{"US", `age == "US"`},
+		{`> now() - duration("24h")`, `age > now() - duration("24h")`},
+		{`in ("US","CA")`, `in(age, []string{"US", "CA"})`},
+		{`IN (US, CA)`, `in(age, []string{"US", "CA"})`},
+		{"in(1, -2, 3)", "in(age, []int{1, -2, 3})"},
+		{`not in ("a, b", "c")`, `!in(age, []string{"a, b", "c"})`},
+		{"in ()", "in(age, []int{})"},
+		{"inactive", `age == "inactive"`},
+	}
+	for _, c := range cases {
+		expr, err := CellExpr("age", c.Cell)
+		if err != nil || expr != c.Expr {
+			t.Fatalf("cell %q should be %q, got %q, %v", c.Cell, c.Expr, expr, err)
+		}
+	}
+
+	for _, cell := range []string{
+		">", `in "US"`, `in ("US", 1)`, "in (1,)", "in (1 2)", `in ("x)`, "in (-x)",
+		">= 18.5", "1e3", "-1.5", "'US'", // 不支持的字面量
+		`"US" || true`, `== "a") || (true`, `> now() && true`, `== ("a"`, `"x`, // 会改变和其他条件的优先级
+	} {
+		if expr, err := CellExpr("age", cell); err == nil {
+			t.Fatalf("cell %q should be invalid, got %q", cell, expr)
+		}
+	}
+}
+
+func TestParseDecisionTable(t *testing.T) {
+	const table = `country, user.age, plan, discount
+"in (""US"", ""CA"")", >= 18, pro, 20
+US, -, -, 10
+-, -, -, 0
+`
+	rows, err := ParseDecisionTable(strings.NewReader(table), "")
+	if err != nil {
+		t.Fatal("failed to call ParseDecisionTable, err:", err)
+	}
+	want := []TableRow{
+		{Line: 2, Expr: `in(country, []string{"US", "CA"}) && user.age >= 18 && plan == "pro"`, Result: "20"},
+		{Line: 3, Expr: `country == "US"`, Result: "10"},
+		{Line: 4, Expr: "", Result: "0"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("should be %+v, got %+v", want, rows)
+	}
+
+	// 指定结果列
+	rows, err = ParseDecisionTable(strings.NewReader("tier,age\ngold,> 60\n"), "tier")
+	if err != nil || len(rows) != 1 || rows[0].Expr != "age > 60" || rows[0].Result != "gold" {
+		t.Fatalf("unexpected rows: %+v, %v", rows, err)
+	}
+
+	errCases := []struct {
+		Table  string
+		Result string
+		Err    string
+	}{
+		{"", "", "empty decision table"},
+		{"a,b\n1,2\n", "c", `result column "c" not found`},
+		{"a b,c\n1,2\n", "", `line 1: invalid variable name "a b"`},
+		{"a,c\n1,2\n\"in (1,,2)\",3\n", "", "line 3, column a: invalid list \"(1,,2)\""},
+		{"a,c\n1,2,3\n", "", "record on line 2: wrong number of fields"},
+		{"a,c\n1,2\n>= 18.5,3\n", "", `line 3, column a: unsupported value "18.5", numbers must be integers and strings must use double quotes`},
+		{"a,b,c\n\"\"\"x\"\" || true\",1,2\n", "", `line 2, column a: value "\"x\" || true" must not contain ` + "`||`"},
+	}
+	for _, c := range errCases {
+		if _, err := ParseDecisionTable(strings.NewReader(c.Table), c.Result); err == nil || err.Error() != c.Err {
+			t.Fatalf("table %q should return %q, got %v", c.Table, c.Err, err)
+		}
+	}
+}
+
+func TestDecisionTable(t *testing.T) {
+	const table = `country,age,discount
+"in (US, CA)",>= 18,20
+US,-,10
+-,> 60,5
+`
+	rows, err := ParseDecisionTable(strings.NewReader(table), "")
+	if err != nil {
+		t.Fatal("failed to call ParseDecisionTable, err:", err)
+	}
+
+	var units []Unit
+	var trees []*Node
+	var payloads []interface{}
+	var names []string
+	var compilers []*Compiler
+	for _, row := range rows {
+		fn, err := NewCompiler(mustLexer(t, row.Expr)).Compile()
+		if err != nil {
+			t.Fatal("failed to call Compile, err:", err)
+		}
+		units = append(units, fn)
+		trees = append(trees, mustTree(t, row.Expr))
+		payloads = append(payloads, row.Result)
+		names = append(names, row.Name())
+		compilers = append(compilers, NewCompiler(mustLexer(t, row.Expr)))
+	}
+	first := NewFirstMatchTable(rows, NewDecisionList(units, trees, payloads, nil))
+	set, err := CompileRuleSet(names, compilers, false)
+	if err != nil {
+		t.Fatal("failed to call CompileRuleSet, err:", err)
+	}
+	all := NewCollectAllTable(rows, set)
+
+	cases := []struct {
+		arg   Kv
+		first []string
+		all   []string
+	}{
+		{arg: Kv{"country": "US", "age": 70}, first: []string{"20"}, all: []string{"20", "10", "5"}},
+		{arg: Kv{"country": "CN", "age": 70}, first: []string{"5"}, all: []string{"5"}},
+		{arg: Kv{"country": "CN", "age": 10}, first: nil, all: nil},
+	}
+	for _, c := range cases {
+		if ret, err := first.Eval(c.arg); err != nil || !reflect.DeepEqual(ret, c.first) {
+			t.Fatalf("arg: %v, first match should be %v, got %v, %v", c.arg, c.first, ret, err)
+		}
+		if ret, err := all.Eval(c.arg); err != nil || !reflect.DeepEqual(ret, c.all) {
+			t.Fatalf("arg: %v, collect all should be %v, got %v, %v", c.arg, c.all, ret, err)
+		}
+	}
+
+	// 错误中带有 CSV 中的行号
+	if _, err := first.Eval(Kv{"country": "US"}); err == nil || err.Error() != "line 2: failed to get int by key(age)" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ret, err := all.Eval(Kv{"country": "US"})
+	if !reflect.DeepEqual(ret, []string{"10"}) || err == nil || err.Error() != "rule line 2: failed to get int by key(age); rule line 4: failed to get int by key(age)" {
+		t.Fatalf("unexpected result: %v, %v", ret, err)
+	}
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+)
+
+// 时钟，now() 通过它获取当前时间，测试时可以替换成固定的时间
+type Clock interface {
+	Now() time.Time
+}
+
+// 用普通函数实现 Clock
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// 系统时钟，没有指定时钟时使用
+var SystemClock Clock = ClockFunc(time.Now)
+
+// 获取时间常量的函数，now() 相关的时间常量在每次执行时都会根据 Env 中的时钟重新计算
+type TimeFunc func(*Env) time.Time
+
+// 解析 time("...") 中的字符串，格式为 RFC3339
+func parseTimeLit(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid time(%q), must be RFC3339", s)
+	}
+	return t, nil
+}
+
+// 解析 duration("...") 中的字符串，格式同 time.ParseDuration
+func parseDurationLit(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return d, fmt.Errorf("invalid duration(%q)", s)
+	}
+	return d, nil
+}
+
+// 对时间和时长常量做加减法，结果仍是常量，所以在词法解析阶段就可以直接计算出来
+func foldTimeArith(x, y *Param, isSub bool) (*Param, error) {
+	d := y.DurationVal
+	if isSub {
+		d = -d
+	}
+
+	switch {
+	case x.Typ == TIME && y.Typ == DURATION: // time ± duration
+		ret := *x
+		if ret.IsNow {
+			ret.DurationVal += d
+		} else {
+			ret.TimeVal = ret.TimeVal.Add(d)
+		}
+		return &ret, nil
+
+	case x.Typ == DURATION && y.Typ == TIME && !isSub: // duration + time
+		return foldTimeArith(y, x, false)
+
+	case x.Typ == DURATION && y.Typ == DURATION: // duration ± duration
+		return &Param{Typ: DURATION, DurationVal: x.DurationVal + d}, nil
+
+	default:
+		return nil, fmt.Errorf("invalid time arithmetic between %v and %v", x.Typ, y.Typ)
+	}
+}
+
+// 根据时间常量生成 TimeFunc，只有 now() 相关的常量才需要用到时钟，
+// Env 中指定了时钟时优先使用，否则使用编译时指定的 clock
+func timeFuncOf(p *Param, clock Clock) TimeFunc {
+	if !p.IsNow {
+		t := p.TimeVal
+		return func(*Env) time.Time { return t }
+	}
+
+	offset := p.DurationVal
+	return func(env *Env) time.Time { return env.clock(clock).Now().Add(offset) }
+}
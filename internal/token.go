@@ -16,6 +16,8 @@ const (
 	BOOLEAN   // 布尔值
 	INT_SLICE // 数字切片
 	STR_SLICE // 字符串切片
+	TIME      // 时间，由 time("...") 或 now() 以及它们和时长的加减法得到
+	DURATION  // 时长，由 duration("...") 得到
 
 	// 一元表达式操作符
 	NOT // 非操作
@@ -34,6 +36,10 @@ const (
 	// 特殊标记，遇到时要做一些动作
 	FUNC // 函数调用
 	DOT  // `a.b.c` 这种字段选择表达式中的 `.`，Compiler 遇到时会把前两个 ident 合并
+
+	CONST // 常量布尔表达式，只会在优化后出现，值保存在 Param.BoolVal 中
+
+	INFIX // 通过 RegisterInfixOperator 注册的具名中缀运算符（如 `a matches "x"`）从这里开始依次分配
 )
 
 // 将 token 转换成对应的字符串表示
@@ -47,6 +53,8 @@ var token2String = map[Token]string{
 	BOOLEAN:   "boolean",
 	INT_SLICE: "[]int",
 	STR_SLICE: "[]string",
+	TIME:      "time",
+	DURATION:  "duration",
 
 	// 一元表达式操作符
 	NOT: "!",
@@ -64,9 +72,14 @@ var token2String = map[Token]string{
 
 	FUNC: "func",
 	DOT:  ".",
+
+	CONST: "const",
 }
 
 func (t Token) String() string {
+	if isInfixOp(t) {
+		return infixName(t)
+	}
 	val, ok := token2String[t]
 	if !ok {
 		return "<invalid>"
@@ -98,3 +111,13 @@ var golangToken2Token = map[token.Token]Token{
 	token.GTR:  GTR,  // >
 	token.GEQ:  GEQ,  // >=
 }
+
+// 根据字符串表示获取比较运算符对应的 token，如 "==" 对应 EQL，注册过的具名中缀运算符也可以获取
+func OperatorToken(op string) (Token, bool) {
+	for t := range flippedOperator {
+		if token2String[t] == op {
+			return t, true
+		}
+	}
+	return infixToken(op)
+}
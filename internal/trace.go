@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 对表达式树中的每个节点分别求值，输出带有每个子表达式结果的表达式树，每行一个节点，
+// 用于排查规则为什么成立或不成立；每个节点都会单独编译，只适合调试时使用
+func Trace(n *Node, env *Env, clock Clock, ops OperatorSet) (string, error) {
+	var sb strings.Builder
+	if err := trace(n, env, clock, ops, 0, &sb); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func trace(n *Node, env *Env, clock Clock, ops OperatorSet, depth int, sb *strings.Builder) error {
+	compiler := NewCompiler(&Lexer{Params: n.Params()})
+	compiler.Clock = clock
+	compiler.Operators = ops
+	u, err := compiler.Compile()
+	if err != nil {
+		return err
+	}
+
+	sb.WriteString(strings.Repeat("  ", depth))
+	switch n.Typ {
+	case NOT, LAND, LOR:
+		sb.WriteString(n.Typ.String())
+	default:
+		sb.WriteString(n.String())
+	}
+	if val, err := u.EvalEnv(env); err != nil {
+		fmt.Fprintf(sb, " => error: %v\n", err)
+	} else {
+		fmt.Fprintf(sb, " => %v\n", val)
+	}
+
+	for _, child := range n.Children {
+		if err := trace(child, env, clock, ops, depth+1, sb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 表达式树，由 Lexer 生成的逆波兰表达式还原而来，供优化、检查等 pass 使用，
+// 处理完后可以通过 Params 重新生成逆波兰表达式交给 Compiler
+type Node struct {
+	Typ      Token    // LAND/LOR/NOT 为逻辑节点，CONST 为常量，其他为叶子节点（比较运算符或 FUNC）
+	Val      string   // FUNC 节点的函数名
+	BoolVal  bool     // CONST 节点的值
+	Args     []*Param // 叶子节点的操作数，`.` 和负号已经合并，比较时变量总在左边（具名中缀运算符除外）
+	Children []*Node  // 逻辑节点的子节点，LAND/LOR 可以有两个以上的子节点
+}
+
+// 交换比较运算符两边的操作数后对应的运算符
+var flippedOperator = map[Token]Token{
+	EQL: EQL,
+	NEQ: NEQ,
+	LSS: GTR,
+	LEQ: GEQ,
+	GTR: LSS,
+	GEQ: LEQ,
+}
+
+// 判断 t 是否是比较运算符
+func isCompareOp(t Token) bool {
+	_, ok := flippedOperator[t]
+	return ok
+}
+
+// 生成比较节点，统一把变量放在左边，方便比较和合并；具名中缀运算符不能交换操作数，保持原样
+func compareNode(t Token, x, y *Param) *Node {
+	if x.Typ == IDENT || isInfixOp(t) {
+		return &Node{Typ: t, Args: []*Param{x, y}}
+	}
+	return &Node{Typ: flippedOperator[t], Args: []*Param{y, x}}
+}
+
+// 根据逆波兰表达式还原出表达式树，计算过程和 Compiler 一致，但不会修改 params 中的值
+func BuildTree(params []*Param) (*Node, error) {
+	var nodes []*Node
+	var literals []*Param
+
+	popLiterals := func(t *Param) (x, y *Param, err error) {
+		lastIdx := len(literals) - 1
+		if lastIdx < 1 {
+			return nil, nil, fmt.Errorf("invalid `%s` token", t.Typ)
+		}
+		x, y = literals[lastIdx-1], literals[lastIdx]
+		literals = literals[:lastIdx-1]
+		return x, y, nil
+	}
+
+	for _, t := range params {
+		switch {
+		case t.Typ == IDENT, t.Typ == INT, t.Typ == STRING, t.Typ == BOOLEAN,
+			t.Typ == INT_SLICE, t.Typ == STR_SLICE, t.Typ == TIME, t.Typ == DURATION:
+			literals = append(literals, t)
+
+		case t.Typ == SUB:
+			lastIdx := len(literals) - 1
+			if lastIdx < 0 || literals[lastIdx].Typ != INT {
+				return nil, errors.New("invalid `-` token")
+			}
+			neg := *literals[lastIdx]
+			neg.IntVal = -neg.IntVal
+			neg.Val = strconv.Itoa(neg.IntVal)
+			literals[lastIdx] = &neg
+
+		case t.Typ == DOT:
+			x, y, err := popLiterals(t)
+			if err != nil {
+				return nil, err
+			}
+			literals = append(literals, &Param{Typ: IDENT, Val: x.Val + "." + y.Val})
+
+		case t.Typ == NOT:
+			lastIdx := len(nodes) - 1
+			if lastIdx < 0 {
+				return nil, errors.New("invalid `!` token")
+			}
+			nodes[lastIdx] = &Node{Typ: NOT, Children: []*Node{nodes[lastIdx]}}
+
+		case t.Typ == LAND || t.Typ == LOR:
+			lastIdx := len(nodes) - 1
+			if lastIdx < 1 {
+				return nil, fmt.Errorf("invalid `%s` token", t.Typ)
+			}
+			nodes[lastIdx-1] = &Node{Typ: t.Typ, Children: []*Node{nodes[lastIdx-1], nodes[lastIdx]}}
+			nodes = nodes[:lastIdx]
+
+		case t.Typ == CONST:
+			nodes = append(nodes, &Node{Typ: CONST, BoolVal: t.BoolVal})
+
+		case t.Typ == FUNC:
+			x, y, err := popLiterals(t)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, &Node{Typ: FUNC, Val: t.Val, Args: []*Param{x, y}})
+
+		case isCompareOp(t.Typ) || isInfixOp(t.Typ):
+			x, y, err := popLiterals(t)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, compareNode(t.Typ, x, y))
+
+		default:
+			return nil, fmt.Errorf("invalid `%s` token", t.Typ)
+		}
+	}
+
+	if len(nodes) != 1 || len(literals) != 0 {
+		return nil, ErrInvalidTokenSequence
+	}
+	return nodes[0], nil
+}
+
+// 判断是否是叶子节点
+func (n *Node) IsLeaf() bool {
+	return n.Typ != LAND && n.Typ != LOR && n.Typ != NOT && n.Typ != CONST
+}
+
+// 重新生成逆波兰表达式，LAND/LOR 的多个子节点会按顺序两两组合
+func (n *Node) Params() []*Param {
+	return n.appendParams(nil)
+}
+
+func (n *Node) appendParams(params []*Param) []*Param {
+	switch n.Typ {
+	case CONST:
+		return append(params, &Param{Typ: CONST, BoolVal: n.BoolVal})
+
+	case NOT:
+		params = n.Children[0].appendParams(params)
+		return append(params, &Param{Typ: NOT, Val: NOT.String()})
+
+	case LAND, LOR:
+		for i, child := range n.Children {
+			params = child.appendParams(params)
+			if i > 0 {
+				params = append(params, &Param{Typ: n.Typ, Val: n.Typ.String()})
+			}
+		}
+		return params
+
+	default:
+		params = append(params, n.Args...)
+		return append(params, &Param{Typ: n.Typ, Val: n.opVal()})
+	}
+}
+
+// 叶子节点对应 token 的 Val
+func (n *Node) opVal() string {
+	if n.Typ == FUNC {
+		return n.Val
+	}
+	return n.Typ.String()
+}
+
+// 还原成表达式的写法，同样的表达式得到的字符串相同，所以也可以作为节点的唯一标识
+func (n *Node) String() string {
+	switch n.Typ {
+	case CONST:
+		return strconv.FormatBool(n.BoolVal)
+
+	case NOT:
+		child := n.Children[0]
+		if child.Typ == LAND || child.Typ == LOR || isCompareOp(child.Typ) || isInfixOp(child.Typ) {
+			return "!(" + child.String() + ")"
+		}
+		return "!" + child.String()
+
+	case LAND, LOR:
+		subs := make([]string, 0, len(n.Children))
+		for _, child := range n.Children {
+			if (child.Typ == LAND || child.Typ == LOR) && child.Typ != n.Typ { // 同类节点满足结合律，不需要括号
+				subs = append(subs, "("+child.String()+")")
+			} else {
+				subs = append(subs, child.String())
+			}
+		}
+		return strings.Join(subs, " "+n.Typ.String()+" ")
+
+	case FUNC:
+		args := make([]string, 0, len(n.Args))
+		for _, arg := range n.Args {
+			args = append(args, ParamSource(arg))
+		}
+		return n.Val + "(" + strings.Join(args, ", ") + ")"
+
+	default:
+		return ParamSource(n.Args[0]) + " " + n.opVal() + " " + ParamSource(n.Args[1])
+	}
+}
+
+// 把操作数还原成表达式中的写法
+func ParamSource(p *Param) string {
+	switch p.Typ {
+	case INT:
+		return strconv.Itoa(p.IntVal)
+
+	case STRING:
+		return `"` + p.Val + `"`
+
+	case BOOLEAN:
+		return strconv.FormatBool(p.BoolVal)
+
+	case INT_SLICE:
+		elems := make([]string, 0, len(p.IntSliceVal))
+		for _, v := range p.IntSliceVal {
+			elems = append(elems, strconv.Itoa(v))
+		}
+		return "[]int{" + strings.Join(elems, ", ") + "}"
+
+	case STR_SLICE:
+		elems := make([]string, 0, len(p.StrSliceVal))
+		for _, v := range p.StrSliceVal {
+			elems = append(elems, `"`+v+`"`)
+		}
+		return "[]string{" + strings.Join(elems, ", ") + "}"
+
+	case TIME:
+		if !p.IsNow {
+			return `time("` + p.TimeVal.Format(time.RFC3339Nano) + `")`
+		}
+		switch {
+		case p.DurationVal > 0:
+			return `now() + duration("` + p.DurationVal.String() + `")`
+		case p.DurationVal < 0:
+			return `now() - duration("` + (-p.DurationVal).String() + `")`
+		default:
+			return "now()"
+		}
+
+	case DURATION:
+		return `duration("` + p.DurationVal.String() + `")`
+
+	default:
+		return p.Val
+	}
+}
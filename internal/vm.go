@@ -0,0 +1,422 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 字节码的操作码
+type opcode uint8
+
+const (
+	opCmpInt  opcode = iota // 变量和整数比较，变量总在左边
+	opCmpStr                // 变量和字符串比较
+	opCmpBool               // 变量和布尔值比较，只有 == 和 !=
+	opCall                  // 执行 Unit，用于时间、时长比较以及函数调用
+	opConst                 // 常量
+	opNot                   // 栈顶取反
+	opAnd                   // 栈顶两个值做 &&
+	opOr                    // 栈顶两个值做 ||
+	opStore                 // 保存栈顶的值，用于出现多次的子表达式，不出栈
+	opLoad                  // 读取 opStore 保存的值
+)
+
+// 一条指令
+type instr struct {
+	op    opcode
+	cmp   Token  // 比较运算符
+	steps int    // 执行前需要计入的步数，和闭包树中 And/Or/Not 执行子表达式前的计数一致
+	key   string // 变量名
+	slot  int    // 变量在 Run 中的缓存下标，opStore/opLoad 为结果的缓存下标
+	ival  int
+	sval  string
+	bval  bool
+	unit  Unit
+}
+
+// 由逆波兰表达式编译出的字节码，由一个简单的栈式虚拟机执行，
+// 和闭包树相比，求值时不需要层层调用函数指针，变量比较也直接在虚拟机中完成
+type Program struct {
+	code     []instr
+	maxStack int            // 执行时栈的最大深度
+	slots    map[Lookup]int // 每个变量按类型分配的缓存下标，一次执行中同一个变量只查找一次
+	memos    int            // 出现多次的子表达式的个数，每个占用一个结果的缓存下标
+	lookups  []Lookup       // Compiler.Preflight，不为 nil 时 Unit 执行前先按顺序查找这些变量
+}
+
+// 一次执行中缓存的变量
+type varSlot struct {
+	loaded bool
+	i      int
+	s      string
+	b      bool
+	err    error
+}
+
+// 把逆波兰表达式编译成字节码，token 的处理方式和 Compile 一致；虚拟机不支持短路求值，设置了 Reorder 时返回错误
+func (c *Compiler) CompileProgram() (*Program, error) {
+	if c.Reorder != nil {
+		return nil, errors.New("program does not support Reorder")
+	}
+	if c.cse == nil {
+		c.cse = newCSETable()
+		c.countCSE(c.cse)
+	}
+	c.prepare()
+	p := &Program{slots: map[Lookup]int{}, lookups: c.lookups}
+	var starts []int  // 栈上每个子表达式第一条指令的下标，And/Or/Not 在这里计入执行子表达式前的步数
+	var nodes []*Node // 和 starts 一一对应的表达式树节点，用于识别相同的子表达式
+	memos := map[int]int{}
+	depth := 0
+
+	// 子表达式的指令生成完后调用，出现多次的子表达式第一次执行后保存结果，之后出现的地方直接读取，
+	// 和闭包树中的 Memo 一样，内部的步数只在第一次执行时计入
+	share := func(n *Node) {
+		nodes[len(nodes)-1] = n
+		id, ok := c.cse.sharedID(n)
+		if !ok {
+			return
+		}
+		if slot, ok := memos[id]; ok {
+			p.code = append(p.code[:starts[len(starts)-1]], instr{op: opLoad, slot: slot})
+			return
+		}
+		memos[id] = len(memos)
+		p.code = append(p.code, instr{op: opStore, slot: memos[id]})
+	}
+
+	emit := func(in instr, n *Node) {
+		starts = append(starts, len(p.code))
+		nodes = append(nodes, nil)
+		p.code = append(p.code, in)
+		if depth++; depth > p.maxStack {
+			p.maxStack = depth
+		}
+		share(n)
+	}
+
+	for i, t := range c.params {
+		switch t.Typ {
+		case IDENT, INT, STRING, BOOLEAN, INT_SLICE, STR_SLICE, TIME, DURATION:
+			c.literals = append(c.literals, t)
+
+		case SUB: // 复制一份再取反，不修改 Lexer 中的 token
+			lastIdx := len(c.literals) - 1
+			if lastIdx < 0 || c.literals[lastIdx].Typ != INT {
+				return nil, c.fail(i, t, errors.New("invalid `-` token"))
+			}
+			neg := *c.literals[lastIdx]
+			neg.IntVal = -neg.IntVal
+			neg.Val = strconv.Itoa(neg.IntVal)
+			c.literals[lastIdx] = &neg
+
+		case NOT:
+			if len(starts) == 0 {
+				return nil, c.fail(i, t, errors.New("invalid `!` token"))
+			}
+			p.code[starts[len(starts)-1]].steps++
+			p.code = append(p.code, instr{op: opNot})
+			share(&Node{Typ: NOT, Children: []*Node{nodes[len(nodes)-1]}})
+
+		case LAND, LOR:
+			lastIdx := len(starts) - 1
+			if lastIdx < 1 {
+				return nil, c.fail(i, t, fmt.Errorf("invalid `%s` token", t.Typ))
+			}
+			p.code[starts[lastIdx-1]].steps++
+			p.code[starts[lastIdx]].steps++
+			n := &Node{Typ: t.Typ, Children: []*Node{nodes[lastIdx-1], nodes[lastIdx]}}
+			starts, nodes = starts[:lastIdx], nodes[:lastIdx]
+			depth--
+
+			op := opAnd
+			if t.Typ == LOR {
+				op = opOr
+			}
+			p.code = append(p.code, instr{op: op})
+			share(n)
+
+		case EQL, NEQ, LSS, LEQ, GTR, GEQ:
+			in, n, err := c.compareInstr(t.Typ)
+			if err != nil {
+				return nil, c.fail(i, t, err)
+			}
+			if in.op != opCall {
+				in.slot = p.slot(in.key, in.op)
+			}
+			emit(in, n)
+
+		case FUNC:
+			u, n, err := c.handleFuncCall(t.Val)
+			if err != nil {
+				return nil, c.fail(i, t, err)
+			}
+			emit(instr{op: opCall, unit: u}, n)
+
+		case CONST:
+			emit(instr{op: opConst, bval: t.BoolVal}, &Node{Typ: CONST, BoolVal: t.BoolVal})
+
+		case DOT:
+			lastIdx := len(c.literals) - 1
+			if len(c.literals) < 2 {
+				return nil, c.fail(i, t, errors.New("invalid `.` token"))
+			}
+			x, y := c.literals[lastIdx-1], c.literals[lastIdx]
+			c.literals = c.literals[:lastIdx-1]
+			c.literals = append(c.literals, &Param{Typ: IDENT, Val: x.Val + "." + y.Val})
+
+		default:
+			if !isInfixOp(t.Typ) {
+				return nil, c.fail(i, t, fmt.Errorf("invalid `%s` token", t.Typ))
+			}
+			in, n, err := c.compareInstr(t.Typ) // 具名中缀运算符总是通过 Unit 执行
+			if err != nil {
+				return nil, c.fail(i, t, err)
+			}
+			emit(in, n)
+		}
+
+		c.logf("token(%d): %v, instrs: %d, literals: %v", i, t, len(p.code), c.literals)
+	}
+
+	if len(starts) != 1 || len(c.literals) != 0 {
+		return nil, c.fail(-1, nil, ErrInvalidTokenSequence)
+	}
+	p.memos = len(memos)
+	return p, nil
+}
+
+// 分配变量的缓存下标
+func (p *Program) slot(key string, op opcode) int {
+	l := Lookup{Key: key, Typ: INT}
+	switch op {
+	case opCmpStr:
+		l.Typ = STRING
+	case opCmpBool:
+		l.Typ = BOOLEAN
+	}
+
+	slot, ok := p.slots[l]
+	if !ok {
+		slot = len(p.slots)
+		p.slots[l] = slot
+	}
+	return slot
+}
+
+// 生成比较指令，同时返回对应的表达式树节点；
+// 运算符使用内置的实现时，整数、字符串和布尔值的相等比较直接由虚拟机完成，其他情况退回到 Unit
+func (c *Compiler) compareInstr(t Token) (instr, *Node, error) {
+	if len(c.literals) < 2 {
+		return instr{}, nil, fmt.Errorf("invalid `%s` token", t)
+	}
+
+	lastIdx := len(c.literals) - 1
+	x, y := c.literals[lastIdx-1], c.literals[lastIdx]
+	c.literals = c.literals[:lastIdx-1]
+	n := compareNode(t, x, y)
+
+	if c.builtinOperator(t) { // 自定义的实现只能通过 Unit 执行
+		if x.Typ != IDENT && y.Typ == IDENT { // 统一把变量放在左边
+			x, y, t = y, x, flippedOperator[t]
+		}
+		if x.Typ == IDENT {
+			switch {
+			case y.Typ == INT:
+				return instr{op: opCmpInt, cmp: t, key: x.Val, ival: y.IntVal}, n, nil
+			case y.Typ == STRING:
+				return instr{op: opCmpStr, cmp: t, key: x.Val, sval: y.Val}, n, nil
+			case y.Typ == BOOLEAN && (t == EQL || t == NEQ):
+				return instr{op: opCmpBool, cmp: t, key: x.Val, bval: y.BoolVal}, n, nil
+			}
+		}
+	}
+
+	u, err := c.compareUnit(t, x, y)
+	if err != nil {
+		return instr{}, nil, err
+	}
+	return instr{op: opCall, unit: u}, n, nil
+}
+
+// 执行字节码，可以直接作为 Unit 使用，但不会执行 Compiler.Preflight 中的查找，需要时使用 Unit
+func (p *Program) Run(env *Env) (bool, error) {
+	var buf [16]bool // 大多数表达式的栈深度不超过 16，变量不超过 8 个，避免每次执行都分配内存
+	stack := buf[:0]
+	if p.maxStack > len(buf) {
+		stack = make([]bool, 0, p.maxStack)
+	}
+	var varBuf [8]varSlot
+	vars := varBuf[:]
+	if len(p.slots) > len(varBuf) {
+		vars = make([]varSlot, len(p.slots))
+	}
+	var memoBuf [8]bool
+	memos := memoBuf[:]
+	if p.memos > len(memoBuf) {
+		memos = make([]bool, p.memos)
+	}
+
+	checkSteps := env.MaxSteps > 0 || env.Ctx != nil // 不需要检查时只计数，避免每一步都调用 env.step
+	for i := range p.code {
+		in := &p.code[i]
+		if !checkSteps {
+			env.steps += in.steps
+		} else {
+			for s := 0; s < in.steps; s++ {
+				if err := env.step(); err != nil {
+					return false, err
+				}
+			}
+		}
+
+		top := len(stack) - 1
+		switch in.op {
+		case opCmpInt:
+			v := &vars[in.slot]
+			if !v.loaded {
+				v.i, v.err = env.GetInt(in.key)
+				v.loaded = true
+			}
+			if v.err != nil {
+				return false, v.err
+			}
+			stack = append(stack, compareInt(in.cmp, v.i, in.ival))
+
+		case opCmpStr:
+			v := &vars[in.slot]
+			if !v.loaded {
+				v.s, v.err = env.GetString(in.key)
+				v.loaded = true
+			}
+			if v.err != nil {
+				return false, v.err
+			}
+			stack = append(stack, compareStr(in.cmp, v.s, in.sval))
+
+		case opCmpBool:
+			v := &vars[in.slot]
+			if !v.loaded {
+				v.b, v.err = env.GetBool(in.key)
+				v.loaded = true
+			}
+			if v.err != nil {
+				return false, v.err
+			}
+			stack = append(stack, (v.b == in.bval) == (in.cmp == EQL))
+
+		case opCall:
+			v, err := in.unit(env)
+			if err != nil {
+				return false, err
+			}
+			stack = append(stack, v)
+
+		case opConst:
+			stack = append(stack, in.bval)
+
+		case opNot:
+			stack[top] = !stack[top]
+
+		case opAnd:
+			stack[top-1] = stack[top-1] && stack[top]
+			stack = stack[:top]
+
+		case opOr:
+			stack[top-1] = stack[top-1] || stack[top]
+			stack = stack[:top]
+
+		case opStore:
+			memos[in.slot] = stack[top]
+
+		case opLoad:
+			stack = append(stack, memos[in.slot])
+		}
+	}
+	return stack[0], nil
+}
+
+// 编译成 Unit，求值方式和闭包树相同
+func (p *Program) Unit() Unit {
+	if p.lookups != nil {
+		return Preflight(p.lookups, p.Run)
+	}
+	return p.Run
+}
+
+// 输出字节码，每行一条指令，调试用
+func (p *Program) String() string {
+	var sb strings.Builder
+	for i, in := range p.code {
+		fmt.Fprintf(&sb, "%03d %s\n", i, in)
+	}
+	return sb.String()
+}
+
+func (in instr) String() string {
+	var s string
+	switch in.op {
+	case opCmpInt:
+		s = fmt.Sprintf("CMP_INT %s(%d) %s %d", in.key, in.slot, in.cmp, in.ival)
+	case opCmpStr:
+		s = fmt.Sprintf("CMP_STR %s(%d) %s %q", in.key, in.slot, in.cmp, in.sval)
+	case opCmpBool:
+		s = fmt.Sprintf("CMP_BOOL %s(%d) %s %v", in.key, in.slot, in.cmp, in.bval)
+	case opCall:
+		s = "CALL"
+	case opConst:
+		s = fmt.Sprintf("CONST %v", in.bval)
+	case opNot:
+		s = "NOT"
+	case opAnd:
+		s = "AND"
+	case opOr:
+		s = "OR"
+	case opStore:
+		s = fmt.Sprintf("STORE %d", in.slot)
+	case opLoad:
+		s = fmt.Sprintf("LOAD %d", in.slot)
+	}
+	if in.steps > 0 {
+		s += fmt.Sprintf(" (steps %d)", in.steps)
+	}
+	return s
+}
+
+// 整数比较
+func compareInt(t Token, x, y int) bool {
+	switch t {
+	case EQL:
+		return x == y
+	case NEQ:
+		return x != y
+	case LSS:
+		return x < y
+	case LEQ:
+		return x <= y
+	case GTR:
+		return x > y
+	default:
+		return x >= y
+	}
+}
+
+// 字符串比较
+func compareStr(t Token, x, y string) bool {
+	switch t {
+	case EQL:
+		return x == y
+	case NEQ:
+		return x != y
+	case LSS:
+		return x < y
+	case LEQ:
+		return x <= y
+	case GTR:
+		return x > y
+	default:
+		return x >= y
+	}
+}
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 分别编译成闭包树和字节码
+func compileBoth(t testing.TB, expr string) (Unit, *Program) {
+	lex := NewLexer(expr)
+	if err := lex.Parse(); err != nil {
+		t.Fatalf("faild to parse %q, err: %v", expr, err)
+	}
+	fn, err := NewCompiler(lex).Compile()
+	if err != nil {
+		t.Fatalf("faild to compile %q, err: %v", expr, err)
+	}
+
+	lex = NewLexer(expr)
+	if err := lex.Parse(); err != nil {
+		t.Fatalf("faild to parse %q, err: %v", expr, err)
+	}
+	p, err := NewCompiler(lex).CompileProgram()
+	if err != nil {
+		t.Fatalf("faild to compile %q to program, err: %v", expr, err)
+	}
+	return fn, p
+}
+
+func TestProgram(t *testing.T) {
+	exprs := []string{
+		"(val > 0 && val < 10) || (val > -10 && val < -1)",
+		`!(s == "x") && s >= "a" || -3 < val`,
+		"b == true || !(b != false) && val != 2",
+		"b > true || val == 1",
+		`in(val, []int{1, 2, 3}) && !in(s, []string{"y"})`,
+		`t.created < now() - duration("1h") || d >= duration("10m")`,
+		"!!(val == 1) && (val == 1 || val == 2 || val == 3)",
+		"(!(in(val, []int{3, 1})) || !(!(!(in(val, []int{3, 1})))))", // 重复的子表达式只执行一次，步数也只计入一次
+		`(val > 0 && s == "x") || !(val > 0 && s == "x") && !(!(val > 0 && s == "x"))`,
+	}
+	now := time.Now()
+	inputs := []Kv{
+		{"val": 1, "s": "x", "b": true, "t.created": now, "d": "5m"},
+		{"val": -5, "s": "b", "b": false, "t.created": now.Add(-2 * time.Hour), "d": "1h"},
+		{"val": 2, "s": "y", "b": true, "t.created": now, "d": "5m"},
+		{"val": 11, "s": "A", "b": false},
+		{"s": "x", "b": true},
+		{"val": "1", "s": 1, "b": 1},
+	}
+
+	for _, expr := range exprs {
+		fn, p := compileBoth(t, expr)
+		for _, vars := range inputs {
+			ret, err := fn.Eval(vars)
+			ret2, err2 := p.Unit().Eval(vars)
+			if ret != ret2 || fmt.Sprint(err) != fmt.Sprint(err2) {
+				t.Fatalf("%q with %v: expect %v, %v, got %v, %v\n%s", expr, vars, ret, err, ret2, err2, p)
+			}
+
+			// 步数上限也应该在同样的位置触发
+			for budget := 1; budget < 20; budget++ {
+				ctx := WithStepBudget(context.Background(), budget)
+				ret, err := fn.EvalContext(ctx, vars)
+				ret2, err2 := p.Unit().EvalContext(ctx, vars)
+				if ret != ret2 || fmt.Sprint(err) != fmt.Sprint(err2) {
+					t.Fatalf("%q with %v and budget %d: expect %v, %v, got %v, %v", expr, vars, budget, ret, err, ret2, err2)
+				}
+			}
+		}
+	}
+
+	// 编译字节码不会修改 Lexer 中的 token
+	lex := NewLexer("a == -1")
+	if err := lex.Parse(); err != nil {
+		t.Fatal("faild to call Parse, err:", err)
+	}
+	for i := 0; i < 2; i++ {
+		p, err := NewCompiler(lex).CompileProgram()
+		if err != nil {
+			t.Fatal("faild to call CompileProgram, err:", err)
+		}
+		if ret, err := p.Unit().Eval(Kv{"a": -1}); !ret || err != nil {
+			t.Fatalf("a == -1 should be true, got %v, %v", ret, err)
+		}
+	}
+}
+
+// README 中的例子
+const benchExpr = "(val > 0 && val < 10) || (val > -10 && val < -1)"
+
+// 生成一个包含 n 条规则的大表达式，mod 不为 0 时规则中的常量会重复出现，可以消除公共子表达式
+func largeExpr(n, mod int) string {
+	rules := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		j := i
+		if mod > 0 {
+			j = i % mod
+		}
+		rules = append(rules, fmt.Sprintf(`(region == "r%d" && age > %d && !(level == %d))`, j, j+1000, j))
+	}
+	return strings.Join(rules, " || ")
+}
+
+func benchmarkBackends(b *testing.B, expr string, vars Kv) {
+	fn, p := compileBoth(b, expr)
+	vm := p.Unit()
+
+	b.Run("closure", func(b *testing.B) {
+		env := NewEnv(vars)
+		for i := 0; i < b.N; i++ {
+			fn.EvalEnv(env)
+		}
+	})
+	b.Run("vm", func(b *testing.B) {
+		env := NewEnv(vars)
+		for i := 0; i < b.N; i++ {
+			vm.EvalEnv(env)
+		}
+	})
+}
+
+func BenchmarkReadmeExample(b *testing.B) {
+	benchmarkBackends(b, benchExpr, Kv{"val": 5})
+}
+
+func BenchmarkLargeRuleSet(b *testing.B) {
+	benchmarkBackends(b, largeExpr(1000, 0), Kv{"region": "r3", "age": 30, "level": 2})
+}
+
+func BenchmarkLargeRuleSetRepeated(b *testing.B) {
+	benchmarkBackends(b, largeExpr(1000, 10), Kv{"region": "r3", "age": 30, "level": 2})
+}
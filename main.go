@@ -1,20 +1,120 @@
 package be2fn
 
-import "github.com/wqvoon/be2fn/internal"
+import (
+	"context"
+	"fmt"
+
+	"github.com/wqvoon/be2fn/internal"
+)
 
 // 传给编译出的函数的参数，key 是字符串，value 是 interface{}，
 // 目前 key 的类型在编译期根据二元表达式的另一个参数确定
 type Kv = internal.Kv
 
-// 将 expr 编译为一个可执行的函数，编译失败时返回错误原因
-func Compile(expr string) (internal.Unit, error) {
+// 编译出的函数，可以通过 Eval 传入 Kv 执行，或通过 EvalEnv 传入完整的求值上下文执行
+type Unit = internal.Unit
+
+// 求值上下文，包含变量以及时钟、随机数来源、区域设置、取消信号和步数上限，
+// 固定其中的时钟等设置后，同一个 Unit 的求值结果可以复现；同一个 Env 可以依次用于多次求值，但不能同时使用
+type Env = internal.Env
+
+// 使用默认设置创建求值上下文
+func NewEnv(vars Kv) *Env {
+	return internal.NewEnv(vars)
+}
+
+// 把一个 JSON 对象转换成 Kv，嵌套的对象展开成用 `.` 连接的 key，整数转换成 int
+func KvFromJSON(data []byte) (Kv, error) {
+	return internal.KvFromJSON(data)
+}
+
+// 求值步数超过上限时返回的错误
+var ErrStepBudgetExceeded = internal.ErrStepBudgetExceeded
+
+// 在 ctx 中设置求值的步数上限，供 Unit.EvalContext 使用
+func WithStepBudget(ctx context.Context, maxSteps int) context.Context {
+	return internal.WithStepBudget(ctx, maxSteps)
+}
+
+// 将 expr 编译为一个可执行的函数，编译失败时返回错误原因；
+// Compile 可以在多个 goroutine 中同时调用，编译出的函数也可以在多个 goroutine 中同时执行，
+// 每次 Eval 都使用新的 Env，但同一个 Env 不能同时传给多个 EvalEnv
+func Compile(expr string, opts ...Option) (Unit, error) {
+	o := newOptions(opts)
+	compiler, err := newCompiler(expr, o)
+	if err != nil {
+		return nil, err
+	}
+	return compileUnit(compiler, o)
+}
+
+// 按照 o 选择闭包树或虚拟机，把 compiler 编译成 Unit
+func compileUnit(compiler *internal.Compiler, o *options) (Unit, error) {
+	if !o.vm {
+		return compiler.Compile()
+	}
+
+	if o.costs != nil { // 虚拟机不支持短路求值
+		return nil, fmt.Errorf("%w: WithVM and WithReorder", ErrConflictingOptions)
+	}
+	program, err := compiler.CompileProgram()
+	if err != nil {
+		return nil, err
+	}
+	return program.Unit(), nil
+}
+
+// 解析 expr 并按照 o 创建 compiler
+func newCompiler(expr string, o *options) (*internal.Compiler, error) {
 	// 词法解析，生成组成逆波兰表达式的 token 序列
-	lexer := internal.NewLexer(expr)
-	if err := lexer.Parse(); err != nil {
+	lexer, err := parse(expr, o)
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := o.operators.tokens()
+	if err != nil {
 		return nil, err
 	}
 
+	// 化简逆波兰表达式，token 序列不合法时保持原样，交给 compiler 报告错误；
+	// 化简基于内置运算符的语义，用到了自定义实现的表达式不做化简
+	// 化简去掉的变量查找在求值前仍会执行，出错时返回的错误和原表达式相同
+	var preflight []internal.Lookup
+	if o.optimize && internal.BuiltinOperators(lexer.Params, ops) {
+		if params, lookups, err := internal.OptimizeParams(lexer.Params); err == nil {
+			lexer.Params, preflight = params, lookups
+		}
+	}
+
 	// 根据 lexer 解析出的 token 编译出最终的函数
 	compiler := internal.NewCompiler(lexer)
-	return compiler.Compile()
+	compiler.Preflight = preflight
+	compiler.Clock = o.clock
+	compiler.Logger = o.logger
+	compiler.Reorder = o.costs
+	compiler.Operators = ops
+	return compiler, nil
+}
+
+// 直接接受 Kv 的函数，和 Unit 改为接受 *Env 之前 Compile 返回的函数签名相同
+type Func func(Kv) (bool, error)
+
+// 和 Compile 相同，但返回可以直接通过 fn(kv) 调用的函数，方便还在使用旧签名的代码迁移
+func CompileFunc(expr string, opts ...Option) (Func, error) {
+	fn, err := Compile(expr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return fn.Eval, nil
+}
+
+// 词法解析，生成组成逆波兰表达式的 token 序列
+func parse(expr string, o *options) (*internal.Lexer, error) {
+	lexer := internal.NewLexer(expr)
+	lexer.Limits = o.limits
+	if err := lexer.Parse(); err != nil {
+		return nil, err
+	}
+	return lexer, nil
 }
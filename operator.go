@@ -0,0 +1,60 @@
+package be2fn
+
+import (
+	"fmt"
+
+	"github.com/wqvoon/be2fn/internal"
+)
+
+// 比较运算符的函数集，包含变量和各种类型的常量比较时使用的函数
+type OperatorFuncs = internal.OperatorFuncs
+
+// 比较运算符的实现，key 为运算符，如 "==" 或注册过的具名中缀运算符，通过 WithOperators 传给 Compile
+type OperatorSet map[string]OperatorFuncs
+
+// 求值时计算出时间的函数，用于 OperatorFuncs 中和时间比较的函数
+type TimeFunc = internal.TimeFunc
+
+// 替换比较运算符 op（如 "=="）的实现，可以和 Compile 并发调用；只影响之后编译的表达式，已经编译好的函数不受影响。
+// 只需要在一部分表达式中使用自定义的实现时，使用 WithOperators
+func RegisterOperator(op string, funcs OperatorFuncs) error {
+	t, ok := internal.OperatorToken(op)
+	if !ok {
+		return fmt.Errorf("`%s` is not a comparison operator", op)
+	}
+	return internal.RegisterOperator(t, funcs)
+}
+
+// 获取比较运算符 op（如 "=="）当前的实现，可以在它的基础上修改后通过 RegisterOperator 注册
+func LookupOperator(op string) (OperatorFuncs, bool) {
+	t, ok := internal.OperatorToken(op)
+	if !ok {
+		return OperatorFuncs{}, false
+	}
+	return internal.LookupOperator(t)
+}
+
+// 转换成 internal 中以 token 为 key 的运算符集合
+func (ops OperatorSet) tokens() (internal.OperatorSet, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	ret := make(internal.OperatorSet, len(ops))
+	for op, funcs := range ops {
+		t, ok := internal.OperatorToken(op)
+		if !ok {
+			return nil, fmt.Errorf("`%s` is not a comparison operator", op)
+		}
+		ret[t] = funcs
+	}
+	return ret, nil
+}
+
+// 注册具名中缀运算符，之后的表达式中可以使用 `name matches "^a.*"` 这样的写法，优先级和 == 等比较运算符相同；
+// 变量和常量可以在任意一边，分别使用 funcs 中的 VarToXxx 和 XxxToVar，为 nil 的函数表示不支持和对应类型的常量比较，
+// 编译到这样的比较时返回错误。name 需要是至少两个字符的标识符，不能是关键字、布尔值或内置函数名，
+// 已经注册过时替换它的实现；可以和 Compile 并发调用，也可以通过 WithOperators 在一部分表达式中使用其他实现
+func RegisterInfixOperator(name string, funcs OperatorFuncs) error {
+	_, err := internal.RegisterInfixOperator(name, funcs)
+	return err
+}
@@ -0,0 +1,131 @@
+package be2fn
+
+import (
+	"errors"
+
+	"github.com/wqvoon/be2fn/internal"
+)
+
+// 时钟，表达式中的 now() 通过它获取当前时间
+type Clock = internal.Clock
+
+// 用普通函数实现 Clock，比如 ClockFunc(func() time.Time { return fixedTime })
+type ClockFunc = internal.ClockFunc
+
+// 编译时的限制，用于处理不可信的表达式，值为 0 的项不做限制
+type Limits = internal.Limits
+
+// 超过 Limits 中的限制时返回的错误，可以通过 errors.Is 判断
+var ErrLimitExceeded = internal.ErrLimitExceeded
+
+// 编译失败时返回的错误，附带出错时编译器的状态，可以通过 errors.As 获取
+type CompileError = internal.CompileError
+
+// 解析表达式失败时的错误，附带出错的位置，可以通过 errors.As 获取
+type ParseError = internal.ParseError
+
+// 处理完所有 token 后栈的状态不对时返回的错误
+var ErrInvalidTokenSequence = internal.ErrInvalidTokenSequence
+
+// 日志接口，*log.Logger 实现了这个接口
+type Logger = internal.Logger
+
+// 同时指定了不能一起使用的编译选项时返回的错误
+var ErrConflictingOptions = errors.New("conflicting compile options")
+
+// 代价模型，用于调整 &&/|| 操作数的执行顺序
+type CostModel = internal.CostModel
+
+// 编译选项，传给 Compile 用于调整编译行为
+type Option func(*options)
+
+type options struct {
+	clock  Clock  // now() 使用的时钟
+	limits Limits // 编译时的限制
+	logger Logger // 输出编译过程的调试信息
+
+	optimize bool       // 编译前是否对表达式做化简
+	costs    *CostModel // 不为 nil 时按代价调整 &&/|| 操作数的执行顺序
+	vm       bool       // 是否编译成字节码由虚拟机执行
+	index    bool       // CompileRuleSet 是否建立索引
+
+	operators OperatorSet // 比较运算符的自定义实现
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// 指定 now() 默认使用的时钟，默认使用系统时钟，求值时 Env 中指定的时钟优先级更高
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// 指定编译时的限制，比如表达式长度、AST 深度等，默认不做限制
+func WithLimits(l Limits) Option {
+	return func(o *options) {
+		o.limits = l
+	}
+}
+
+// 指定输出编译过程调试信息的 Logger，编写规则时可以用来观察每个 token 的处理过程，默认不输出
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// 编译前对表达式做常量折叠和代数化简，比如合并同一个变量上的范围比较、去掉重复的子表达式、
+// 把 in(a, []int{}) 折叠成 false、消去双重否定，从而生成更小的闭包树；
+// 被折叠掉的子表达式中的变量在求值前仍会按原来的顺序查找，缺少变量等错误和不化简时相同；
+// 表达式中的运算符使用了自定义的实现时不做化简
+func WithOptimize() Option {
+	return func(o *options) {
+		o.optimize = true
+	}
+}
+
+// 根据代价模型调整 &&/|| 操作数的执行顺序，代价低、更容易决定结果的子表达式先执行，并在结果确定后跳过剩下的操作数；
+// 求值前会按原来的顺序查找所有变量，查找失败时返回的错误和不调整顺序时相同，只是求值的步数会变少；
+// m 中可以指定运算符和函数的代价以及子表达式成立的概率，为 nil 时使用默认的代价
+func WithReorder(m *CostModel) Option {
+	return func(o *options) {
+		if m == nil {
+			m = &CostModel{}
+		}
+		o.costs = m
+	}
+}
+
+// 把表达式编译成字节码，由一个简单的栈式虚拟机执行，而不是生成闭包树，
+// 求值结果、错误和步数与闭包树相同，重复的子表达式同样只执行一次；不能和 WithReorder 一起使用
+func WithVM() Option {
+	return func(o *options) {
+		o.vm = true
+	}
+}
+
+// 让 CompileRuleSet 根据规则中顶层的 `变量 == 常量` 和 `in(变量, 切片)` 条件建立索引，求值时只执行这个条件可能成立的规则，
+// 适合由等值条件组成的大量规则；成立的规则和不建立索引时相同，但被跳过的规则中其他条件出的错（比如缺少变量）不会再返回。
+// 对 Compile 没有作用
+func WithIndex() Option {
+	return func(o *options) {
+		o.index = true
+	}
+}
+
+// 编译时使用 ops 中比较运算符的实现，比如让字符串的 == 不区分大小写，ops 中没有的运算符以及为 nil 的函数
+// 使用全局的实现（见 RegisterOperator），只影响这一次编译；
+// 化简、调整顺序、建立索引和检查遮蔽关系都基于内置运算符的语义，用到了 ops 中的运算符的表达式不会做这些处理，
+// WithVM 编译时这些比较也会退回到普通的函数调用
+func WithOperators(ops OperatorSet) Option {
+	return func(o *options) {
+		o.operators = ops
+	}
+}
@@ -0,0 +1,19 @@
+package be2fn
+
+import "github.com/wqvoon/be2fn/internal"
+
+// 从目录中加载规则文件并在文件变化时热更新，见 OpenRepository
+type Repository = internal.Repository
+
+// Repository 某一时刻加载的所有规则
+type Snapshot = internal.Snapshot
+
+// 加载匹配 pattern（比如 "rules/*.be2fn"，格式同 filepath.Glob）的规则文件，每个文件通过 LoadRuleFile 编译成一个 RuleSet，
+// opts 对所有文件生效，可以通过文件名（不含扩展名）获取；
+// repo.Reload() 或在后台执行的 repo.Watch(ctx, interval) 会根据修改时间和大小检查文件是否有变化，
+// 有变化时重新加载所有文件，全部成功后原子地切换到新版本，否则继续使用之前的版本，错误可以通过 repo.LastError() 获取
+func OpenRepository(pattern string, opts ...Option) (*Repository, error) {
+	return internal.NewRepository(pattern, func(path string) (*RuleSet, error) {
+		return LoadRuleFile(path, opts...)
+	})
+}
@@ -0,0 +1,84 @@
+package be2fn
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 不区分大小写的字符串相等比较，用于检查编译选项是否生效
+var foldOperators = OperatorSet{
+	"==": {
+		VarToStr: func(varname string, val string) Unit {
+			return func(env *Env) (bool, error) {
+				s, err := env.GetString(varname)
+				if err != nil {
+					return false, err
+				}
+				return strings.EqualFold(s, val), nil
+			}
+		},
+	},
+}
+
+func TestOpenRepository(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Now().Add(-time.Hour)
+	write := func(name, src string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime = mtime.Add(time.Second) // 保证修改时间一定变化
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eval := func(r *Repository, name string, vars Kv, want []string) {
+		t.Helper()
+		if ret, err := r.Eval(name, vars); err != nil || !reflect.DeepEqual(ret, want) {
+			t.Fatalf("%s should be %v, got %v, %v", name, want, ret, err)
+		}
+	}
+
+	write("user.be2fn", "adult := age >= 18\n")
+	write("geo.be2fn", "us := country == \"US\"\n")
+	pattern := filepath.Join(dir, "*.be2fn")
+	r, err := OpenRepository(pattern, WithOperators(foldOperators))
+	if err != nil {
+		t.Fatal("failed to call OpenRepository, err:", err)
+	}
+	eval(r, "user", Kv{"age": 20}, []string{"adult"})
+	eval(r, "geo", Kv{"country": "us"}, []string{"us"}) // opts 对所有文件生效
+	if rs, ok := r.Snapshot().Get("geo"); !ok || !reflect.DeepEqual(rs.Names(), []string{"us"}) {
+		t.Fatalf("unexpected snapshot: %v", r.Snapshot())
+	}
+
+	// 加载失败时返回带有文件名和行号的错误，继续使用之前的版本
+	write("geo.be2fn", "us := country == \"US\"\nca := country ==\n")
+	changed, err := r.Reload()
+	var fe *FileError
+	if changed || !errors.As(err, &fe) || fe.File != filepath.Join(dir, "geo.be2fn") || fe.Line != 2 {
+		t.Fatalf("should fail at geo.be2fn:2, got %v, %v", changed, err)
+	}
+	if r.Version() != 1 || r.LastError() == nil {
+		t.Fatalf("should keep version 1, got %d, %v", r.Version(), r.LastError())
+	}
+	eval(r, "geo", Kv{"country": "us"}, []string{"us"})
+
+	write("geo.be2fn", "us := country == \"US\"\nca := country == \"CA\"\n")
+	if changed, err := r.Reload(); !changed || err != nil || r.Version() != 2 {
+		t.Fatalf("should reload, got %v, %v, version %d", changed, err, r.Version())
+	}
+	eval(r, "geo", Kv{"country": "ca"}, []string{"ca"})
+
+	// 规则集不支持 WithVM
+	if _, err := OpenRepository(pattern, WithVM()); !errors.Is(err, ErrConflictingOptions) {
+		t.Fatalf("should return ErrConflictingOptions, got %v", err)
+	}
+}
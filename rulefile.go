@@ -0,0 +1,51 @@
+package be2fn
+
+import (
+	"errors"
+	"os"
+
+	"github.com/wqvoon/be2fn/internal"
+)
+
+// 规则文件中的错误，指向出错的文件和位置，可以通过 errors.As 获取
+type FileError = internal.FileError
+
+// 读取并编译规则文件，见 CompileRuleFile
+func LoadRuleFile(path string, opts ...Option) (*RuleSet, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return CompileRuleFile(path, src, opts...)
+}
+
+// 编译规则文件，文件中的每个定义都是 RuleSet 中的一条规则：
+//
+//	# 注释，只能单独占一行
+//	is_adult := age >= 18
+//	eligible := is_adult && country == "US"
+//	    && !(status == "blocked")
+//
+// 以空白开头的行是上一个定义的延续；定义的名字出现在 &&、|| 和 ! 的操作数或整个表达式的位置时表示引用这个定义，引用在编译时被内联，
+// 循环引用会报错；WithLimits 对展开后的表达式生效，展开时超过 MaxSourceLen（没有设置时为 1MiB）会报告在引用的位置；
+// filename 只用于生成错误，出错时返回 *FileError
+func CompileRuleFile(filename string, src []byte, opts ...Option) (*RuleSet, error) {
+	defs, err := internal.ParseRuleFile(filename, src, newOptions(opts).limits)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]Rule, 0, len(defs))
+	lines := make(map[string]int, len(defs))
+	for _, def := range defs {
+		rules = append(rules, Rule{Name: def.Name, Expr: def.Expr})
+		lines[def.Name] = def.Line
+	}
+
+	rs, err := CompileRuleSet(rules, opts...)
+	var ruleErr *RuleError
+	if errors.As(err, &ruleErr) {
+		return nil, &FileError{File: filename, Line: lines[ruleErr.Name], Err: err}
+	}
+	return rs, err
+}
@@ -0,0 +1,83 @@
+package be2fn
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testRuleFile = `# 用户相关
+adult := age >= 18
+us := country == "US"
+
+eligible := adult
+    && (us || country == "CA")
+`
+
+func TestCompileRuleFile(t *testing.T) {
+	rs, err := CompileRuleFile("rules.be2fn", []byte(testRuleFile), WithIndex())
+	if err != nil {
+		t.Fatal("failed to call CompileRuleFile, err:", err)
+	}
+	if names := rs.Names(); !reflect.DeepEqual(names, []string{"adult", "us", "eligible"}) {
+		t.Fatalf("unexpected names: %v", names)
+	}
+	if ret, err := rs.Eval(Kv{"age": 20, "country": "CA"}); err != nil || !reflect.DeepEqual(ret, []string{"adult", "eligible"}) {
+		t.Fatalf("should be [adult eligible], got %v, %v", ret, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "rules.be2fn")
+	if err := os.WriteFile(path, []byte(testRuleFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rs, err = LoadRuleFile(path)
+	if err != nil {
+		t.Fatal("failed to call LoadRuleFile, err:", err)
+	}
+	if ret, err := rs.Eval(Kv{"age": 20, "country": "US"}); err != nil || !reflect.DeepEqual(ret, []string{"adult", "us", "eligible"}) {
+		t.Fatalf("should be [adult us eligible], got %v, %v", ret, err)
+	}
+}
+
+func TestCompileRuleFileError(t *testing.T) {
+	err := RegisterInfixOperator("hasprefix", OperatorFuncs{
+		VarToStr: func(varname string, prefix string) Unit {
+			return func(env *Env) (bool, error) {
+				s, err := env.GetString(varname)
+				return strings.HasPrefix(s, prefix), err
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal("failed to call RegisterInfixOperator, err:", err)
+	}
+
+	cases := []struct {
+		Src  string
+		Opts []Option
+		Line int
+		Rule string // 为空时表示不是编译规则时的错误
+		Err  string
+	}{
+		// 编译规则时的错误指向定义所在的行
+		{"# c\nadult := age >= 18\nnamed := name hasprefix \"a\"\n  || name hasprefix 1\n", nil, 3, "named", "f:3: rule named: `hasprefix` does not support int operand `1`"},
+		{"adult := age >= 18\nx := adult && a > 1", []Option{WithOperators(OperatorSet{"<>": {}})}, 1, "adult", "f:1: rule adult: `<>` is not a comparison operator"},
+		// 解析规则文件时的错误
+		{"a := x == 1\n  && (y == 2", nil, 2, "", "f:2:13: expected ')', found newline"},
+		{"a := b && b\nb := x == 1", []Option{WithLimits(Limits{MaxSourceLen: 15})}, 1, "", "f:1:11: compile limit exceeded: expanding b makes a longer than 15"},
+	}
+	for _, c := range cases {
+		_, err := CompileRuleFile("f", []byte(c.Src), c.Opts...)
+		var fe *FileError
+		if !errors.As(err, &fe) || fe.Line != c.Line || err.Error() != c.Err {
+			t.Fatalf("src %q should return %q, got %v", c.Src, c.Err, err)
+		}
+		var re *RuleError
+		if errors.As(err, &re) != (c.Rule != "") || (re != nil && re.Name != c.Rule) {
+			t.Fatalf("src %q should return RuleError of %q, got %v", c.Src, c.Rule, err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package be2fn
+
+import (
+	"fmt"
+
+	"github.com/wqvoon/be2fn/internal"
+)
+
+// RuleSet 中的一条规则
+type Rule struct {
+	Name string // 规则名，同一个 RuleSet 中不能重复
+	Expr string // 表达式
+}
+
+// 一组一起求值的规则，求值时返回成立的规则名，
+// 相同的子表达式在所有规则之间共享，一次求值中每个不同的子表达式和变量查找最多只执行一次
+type RuleSet = internal.RuleSet
+
+// RuleSet 中某条规则编译或求值出错时的错误，可以通过 errors.As 获取
+type RuleError = internal.RuleError
+
+// RuleSet 求值时出错的所有规则
+type RuleErrors = internal.RuleErrors
+
+// 编译一组规则，opts 对所有规则生效，不支持 WithVM；某条规则编译失败时返回 *RuleError
+func CompileRuleSet(rules []Rule, opts ...Option) (*RuleSet, error) {
+	o := newOptions(opts)
+	if o.vm {
+		return nil, fmt.Errorf("%w: WithVM and CompileRuleSet", ErrConflictingOptions)
+	}
+
+	names := make([]string, 0, len(rules))
+	compilers := make([]*internal.Compiler, 0, len(rules))
+	for _, r := range rules {
+		c, err := newCompiler(r.Expr, o)
+		if err != nil {
+			return nil, &RuleError{Name: r.Name, Err: err}
+		}
+		names = append(names, r.Name)
+		compilers = append(compilers, c)
+	}
+	return internal.CompileRuleSet(names, compilers, o.index)
+}
@@ -0,0 +1,99 @@
+package be2fn
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/wqvoon/be2fn/internal"
+)
+
+// 决策表的匹配方式
+type TableMode = internal.TableMode
+
+const (
+	FirstMatch = internal.FirstMatch // 返回第一行成立的结果
+	CollectAll = internal.CollectAll // 返回所有成立的行的结果
+)
+
+// 决策表的设置
+type TableConfig struct {
+	Mode         TableMode
+	ResultColumn string // 结果列的列名，为空时使用最后一列
+}
+
+// 决策表中的一行，Expr 为条件单元格转换成的表达式
+type TableRow = internal.TableRow
+
+// 编译好的决策表，Eval 返回成立的行的结果
+type DecisionTable = internal.DecisionTable
+
+// 从 CSV 中加载决策表：第一行为表头，除结果列外每一列的列名都是变量名，
+// 单元格可以是 `> 18`、`in ("US", "CA")`、`not in (1, 2)`、`"US"` 这样的条件，`-` 或空白表示任意值，
+// 每行的条件用 && 连接后通过和 Compile 相同的流程编译，opts 对所有行生效；
+// CollectAll 时行之间相同的子表达式会被共享，也支持 WithIndex
+func LoadDecisionTable(r io.Reader, cfg TableConfig, opts ...Option) (*DecisionTable, error) {
+	rows, err := internal.ParseDecisionTable(r, cfg.ResultColumn)
+	if err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+
+	compilers := make([]*internal.Compiler, 0, len(rows))
+	for _, row := range rows {
+		c, err := tableCompiler(row, o)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", row.Line, err)
+		}
+		compilers = append(compilers, c)
+	}
+
+	switch cfg.Mode {
+	case FirstMatch:
+		units := make([]Unit, 0, len(rows))
+		trees := make([]*internal.Node, 0, len(rows))
+		payloads := make([]interface{}, 0, len(rows))
+		for i, row := range rows {
+			u, err := compileUnit(compilers[i], o)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", row.Line, err)
+			}
+			units = append(units, u)
+			if row.Expr == "" {
+				trees = append(trees, &internal.Node{Typ: internal.CONST, BoolVal: true})
+			} else {
+				trees = append(trees, decisionTree(row.Expr, o))
+			}
+			payloads = append(payloads, row.Result)
+		}
+		return internal.NewFirstMatchTable(rows, internal.NewDecisionList(units, trees, payloads, nil)), nil
+
+	case CollectAll:
+		if o.vm {
+			return nil, fmt.Errorf("%w: WithVM and CollectAll", ErrConflictingOptions)
+		}
+		names := make([]string, 0, len(rows))
+		for _, row := range rows {
+			names = append(names, row.Name())
+		}
+		set, err := internal.CompileRuleSet(names, compilers, o.index)
+		if err != nil {
+			return nil, err
+		}
+		return internal.NewCollectAllTable(rows, set), nil
+
+	default:
+		return nil, fmt.Errorf("invalid table mode %d", cfg.Mode)
+	}
+}
+
+// 创建一行对应的 compiler，没有条件的行总是成立
+func tableCompiler(row TableRow, o *options) (*internal.Compiler, error) {
+	if row.Expr != "" {
+		return newCompiler(row.Expr, o)
+	}
+	lexer := &internal.Lexer{Params: []*internal.Param{{Typ: internal.CONST, BoolVal: true}}}
+	compiler := internal.NewCompiler(lexer)
+	compiler.Clock = o.clock
+	compiler.Logger = o.logger
+	return compiler, nil
+}
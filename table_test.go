@@ -0,0 +1,83 @@
+package be2fn
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testTable = `country, user.age, plan, discount
+"in (""US"", ""CA"")", >= 18, pro, 20
+US, -, -, 10
+-, < 18, -, 5
+-, -, -, 0
+`
+
+func TestLoadDecisionTable(t *testing.T) {
+	cases := []struct {
+		Vars       Kv
+		FirstMatch []string
+		CollectAll []string
+	}{
+		{Kv{"country": "US", "user.age": 20, "plan": "pro"}, []string{"20"}, []string{"20", "10", "0"}},
+		{Kv{"country": "US", "user.age": 10, "plan": "free"}, []string{"10"}, []string{"10", "5", "0"}},
+		{Kv{"country": "CN", "user.age": 10, "plan": "pro"}, []string{"5"}, []string{"5", "0"}},
+		{Kv{"country": "CN", "user.age": 30, "plan": "pro"}, []string{"0"}, []string{"0"}},
+	}
+
+	for _, mode := range []TableMode{FirstMatch, CollectAll} {
+		for _, opts := range [][]Option{nil, {WithOptimize()}, {WithIndex()}} {
+			table, err := LoadDecisionTable(strings.NewReader(testTable), TableConfig{Mode: mode}, opts...)
+			if err != nil {
+				t.Fatalf("failed to load table in mode %d, err: %v", mode, err)
+			}
+			for _, c := range cases {
+				want := c.FirstMatch
+				if mode == CollectAll {
+					want = c.CollectAll
+				}
+				if ret, err := table.Eval(c.Vars); err != nil || !reflect.DeepEqual(ret, want) {
+					t.Fatalf("mode %d with %v should be %v, got %v, %v", mode, c.Vars, want, ret, err)
+				}
+			}
+		}
+	}
+
+	// 指定结果列，FirstMatch 时出错的行带有 CSV 的行号
+	table, err := LoadDecisionTable(strings.NewReader("discount, age\n10, > 18\n0, -\n"), TableConfig{Mode: FirstMatch, ResultColumn: "discount"})
+	if err != nil {
+		t.Fatal("failed to call LoadDecisionTable, err:", err)
+	}
+	if ret, err := table.Eval(Kv{"age": 20}); err != nil || !reflect.DeepEqual(ret, []string{"10"}) {
+		t.Fatalf("should be [10], got %v, %v", ret, err)
+	}
+	if _, err := table.Eval(Kv{"age": "x"}); err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Fatalf("should return error of line 2, got %v", err)
+	}
+}
+
+func TestLoadDecisionTableError(t *testing.T) {
+	cases := []struct {
+		Table string
+		Cfg   TableConfig
+		Opts  []Option
+		Err   string
+	}{
+		{"age, result\n> 18, a\n>= 18.5, b\n", TableConfig{Mode: FirstMatch}, nil, "line 3, column age"},
+		{"age, result\n> 18, a\n", TableConfig{Mode: FirstMatch}, []Option{WithOperators(OperatorSet{"<>": {}})}, "line 2: "},
+		{"age, result\n> 18, a\n", TableConfig{Mode: TableMode(-1)}, nil, "invalid table mode"},
+		{"age, result\n> 18, a\n", TableConfig{ResultColumn: "x"}, nil, "x"},
+	}
+	for _, c := range cases {
+		_, err := LoadDecisionTable(strings.NewReader(c.Table), c.Cfg, c.Opts...)
+		if err == nil || !strings.Contains(err.Error(), c.Err) {
+			t.Fatalf("table %q should return error containing %q, got %v", c.Table, c.Err, err)
+		}
+	}
+
+	_, err := LoadDecisionTable(strings.NewReader("age, result\n> 18, a\n"), TableConfig{Mode: CollectAll}, WithVM())
+	if !errors.Is(err, ErrConflictingOptions) {
+		t.Fatalf("should return ErrConflictingOptions, got %v", err)
+	}
+}